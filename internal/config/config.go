@@ -1,19 +1,21 @@
 package config
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"sync"
 
 	"github.com/google/fhir/go/jsonformat"
-	"github.com/hashicorp/go-retryablehttp"
 	"github.com/philips-software/go-hsdp-api/ai"
 	"github.com/philips-software/go-hsdp-api/ai/inference"
 	"github.com/philips-software/go-hsdp-api/ai/workspace"
 	"github.com/philips-software/go-hsdp-api/cartel"
 	"github.com/philips-software/go-hsdp-api/cdl"
 	"github.com/philips-software/go-hsdp-api/cdr"
-	"github.com/philips-software/go-hsdp-api/config"
 	"github.com/philips-software/go-hsdp-api/connect/mdm"
 	"github.com/philips-software/go-hsdp-api/console"
 	"github.com/philips-software/go-hsdp-api/console/docker"
@@ -25,6 +27,13 @@ import (
 	"github.com/philips-software/go-hsdp-api/stl"
 )
 
+// StrictHostKeyChecking values, with the meaning of the ssh_config(5) option
+const (
+	HostKeyCheckingYes       = "yes"
+	HostKeyCheckingAcceptNew = "accept-new"
+	HostKeyCheckingNo        = "no"
+)
+
 // Config contains configuration for the client
 type Config struct {
 	iam.Config
@@ -41,11 +50,32 @@ type Config struct {
 	CartelNoTLS         bool
 	CartelSkipVerify    bool
 	RetryMax            int
+	Retry               RetryOverride
+	RetryServices       map[string]RetryOverride
 	UAAUsername         string
 	UAAPassword         string
 	UAAURL              string
 	AIInferenceEndpoint string
 	AIWorkspaceEndpoint string
+	// SimulatorURL, when set, points every service client at an HSDP API
+	// simulator instead of the real service endpoints
+	SimulatorURL string
+	// DefaultTags are merged into the tags and labels of all resources
+	// supporting them
+	DefaultTags map[string]string
+	// ReadOnly refuses all operations which could change anything at HSDP
+	ReadOnly bool
+	// Discovery resolves service endpoints, including those of the
+	// service_discovery_file
+	Discovery *Discovery
+	// KnownHostsFile is checked for the SSH host keys of bastion hosts
+	KnownHostsFile string
+	// StrictHostKeyChecking is one of the HostKeyChecking values
+	StrictHostKeyChecking string
+	// credentialSources are read for credentials missing from the provider
+	// block, see LoadCredentials
+	credentialSources   CredentialSources
+	explicitCredentials Credentials
 
 	iamClient             *iam.Client
 	cartelClient          *cartel.Client
@@ -56,6 +86,7 @@ type Config struct {
 	notificationClient    *notification.Client
 	mdmClient             *mdm.Client
 	DebugFile             *os.File
+	Tracer                *Tracer
 	credsClientErr        error
 	cartelClientErr       error
 	iamClientErr          error
@@ -66,302 +97,546 @@ type Config struct {
 	mdmClientErr          error
 	TimeZone              string
 
+	iamOnce          sync.Once
+	cartelOnce       sync.Once
+	s3credsOnce      sync.Once
+	consoleOnce      sync.Once
+	pkiOnce          sync.Once
+	stlOnce          sync.Once
+	notificationOnce sync.Once
+	mdmOnce          sync.Once
+	clients          clientRegistry
+	policiesOnce     sync.Once
+	policies         *HostPolicies
+
+	securityGroupsOnce sync.Once
+	securityGroups     []string
+	securityGroupsErr  error
+
 	STU3MA *jsonformat.Marshaller
 	STU3UM *jsonformat.Unmarshaller
 	R4MA   *jsonformat.Marshaller
 	R4UM   *jsonformat.Unmarshaller
 }
 
-func (c *Config) IAMClient() (*iam.Client, error) {
-	return c.iamClient, c.iamClientErr
+// IAMClient returns the IAM client, logging in on first use. An optional
+// region and environment can be passed to get a client for another pair.
+func (c *Config) IAMClient(regionEnvironment ...string) (*iam.Client, error) {
+	region, environment, override := c.resolve(regionEnvironment...)
+	if !override {
+		c.iamOnce.Do(c.SetupIAMClient)
+		keepAlive(c.iamClient)
+		return c.iamClient, c.iamClientErr
+	}
+	client, err := c.clients.get(clientKey{"iam", region, environment}, func() (interface{}, error) {
+		return c.newIAMClient(region, environment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	keepAlive(client.(*iam.Client))
+	return client.(*iam.Client), nil
+}
+
+// CartelClient returns the Cartel client, creating it on first use
+func (c *Config) CartelClient(region ...string) (*cartel.Client, error) {
+	r, _, override := c.resolve(region...)
+	if !override {
+		c.cartelOnce.Do(c.SetupCartelClient)
+		return c.cartelClient, c.cartelClientErr
+	}
+	client, err := c.clients.get(clientKey{"cartel", r, ""}, func() (interface{}, error) {
+		return c.newCartelClient(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client.(*cartel.Client), nil
+}
+
+// CartelSecurityGroups returns the names of the security groups Cartel
+// offers, fetching them once per provider instance
+func (c *Config) CartelSecurityGroups() ([]string, error) {
+	c.securityGroupsOnce.Do(func() {
+		client, err := c.CartelClient()
+		if err != nil {
+			c.securityGroupsErr = err
+			return
+		}
+		groups, _, err := client.GetSecurityGroups()
+		if err != nil {
+			c.securityGroupsErr = err
+			return
+		}
+		c.securityGroups = *groups
+	})
+	return c.securityGroups, c.securityGroupsErr
 }
 
-func (c *Config) CartelClient() (*cartel.Client, error) {
-	return c.cartelClient, c.cartelClientErr
+// S3CredsClient returns the S3 Credentials client, creating it on first use
+func (c *Config) S3CredsClient(regionEnvironment ...string) (*s3creds.Client, error) {
+	region, environment, override := c.resolve(regionEnvironment...)
+	if !override {
+		c.s3credsOnce.Do(c.SetupS3CredsClient)
+		return c.s3credsClient, c.credsClientErr
+	}
+	client, err := c.clients.get(clientKey{"s3creds", region, environment}, func() (interface{}, error) {
+		return c.newS3CredsClient(region, environment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client.(*s3creds.Client), nil
 }
 
-func (c *Config) S3CredsClient() (*s3creds.Client, error) {
-	return c.s3credsClient, c.credsClientErr
+// ConsoleClient returns the Console client, logging in on first use
+func (c *Config) ConsoleClient(region ...string) (*console.Client, error) {
+	r, _, override := c.resolve(region...)
+	if !override {
+		c.consoleOnce.Do(c.SetupConsoleClient)
+		return c.consoleClient, c.consoleClientErr
+	}
+	client, err := c.clients.get(clientKey{"console", r, ""}, func() (interface{}, error) {
+		return c.newConsoleClient(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client.(*console.Client), nil
 }
 
-func (c *Config) ConsoleClient() (*console.Client, error) {
-	return c.consoleClient, c.consoleClientErr
+// MDMClient returns the Connect MDM client, creating it on first use
+func (c *Config) MDMClient(regionEnvironment ...string) (*mdm.Client, error) {
+	region, environment, override := c.resolve(regionEnvironment...)
+	if !override {
+		c.mdmOnce.Do(c.SetupMDMClient)
+		return c.mdmClient, c.mdmClientErr
+	}
+	client, err := c.clients.get(clientKey{"mdm", region, environment}, func() (interface{}, error) {
+		return c.newMDMClient(region, environment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client.(*mdm.Client), nil
 }
 
-func (c *Config) MDMClient() (*mdm.Client, error) {
-	return c.mdmClient, c.mdmClientErr
+// STLClient returns the STL client, creating it on first use
+func (c *Config) STLClient(region ...string) (*stl.Client, error) {
+	r, _, override := c.resolve(region...)
+	if !override {
+		c.stlOnce.Do(c.SetupSTLClient)
+		return c.stlClient, c.stlClientErr
+	}
+	client, err := c.clients.get(clientKey{"stl", r, ""}, func() (interface{}, error) {
+		return c.newSTLClient(r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client.(*stl.Client), nil
 }
 
-func (c *Config) STLClient(_ ...string) (*stl.Client, error) {
-	return c.stlClient, c.stlClientErr
+// STLClientFromEndpoint returns an STL client which uses the given API endpoint
+func (c *Config) STLClientFromEndpoint(endpointURL string) (*stl.Client, error) {
+	consoleClient, err := c.ConsoleClient()
+	if err != nil {
+		return nil, err
+	}
+	return stl.NewClient(consoleClient, &stl.Config{
+		STLAPIURL: c.endpoint(endpointURL),
+		DebugLog:  c.DebugLog,
+	})
 }
 
 func (c *Config) DockerClient(region ...string) (*docker.Client, error) {
-	r := c.Region
-	if len(region) > 0 {
-		r = region[0]
-	}
-	if c.consoleClientErr != nil {
-		return nil, c.consoleClientErr
+	r, _, _ := c.resolve(region...)
+	consoleClient, err := c.ConsoleClient(r)
+	if err != nil {
+		return nil, err
 	}
-	return docker.NewClient(c.consoleClient, &docker.Config{
-		Region: r,
+	return docker.NewClient(consoleClient, &docker.Config{
+		Region:       r,
+		DockerAPIURL: c.Discovery.Service(r, "", "docker-registry").URL,
 	})
 }
 
+// PKIClient returns the PKI client, creating it on first use
 func (c *Config) PKIClient(regionEnvironment ...string) (*pki.Client, error) {
-	if len(regionEnvironment) == 2 && c.consoleClient != nil && c.iamClient != nil {
-		region := regionEnvironment[0]
-		environment := regionEnvironment[1]
-		return pki.NewClient(c.consoleClient, c.iamClient, &pki.Config{
-			Region:      region,
-			Environment: environment,
-			DebugLog:    c.DebugLog,
-		})
+	region, environment, override := c.resolve(regionEnvironment...)
+	if !override {
+		c.pkiOnce.Do(c.SetupPKIClient)
+		return c.pkiClient, c.pkiClientErr
 	}
-	return c.pkiClient, c.pkiClientErr
+	client, err := c.clients.get(clientKey{"pki", region, environment}, func() (interface{}, error) {
+		return c.newPKIClient(region, environment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client.(*pki.Client), nil
 }
 
 func (c *Config) S3CredsClientWithLogin(username, password string) (*s3creds.Client, error) {
-	if c.iamClientErr != nil {
-		return nil, c.iamClientErr
+	iamClient, err := c.IAMClient()
+	if err != nil {
+		return nil, err
 	}
-	newIAMClient, err := c.iamClient.WithLogin(username, password)
+	newIAMClient, err := iamClient.WithLogin(username, password)
 	if err != nil {
 		return nil, err
 	}
 	return s3creds.NewClient(newIAMClient, &s3creds.Config{
-		BaseURL:  c.S3CredsURL,
+		BaseURL:  c.serviceURL("s3creds", c.S3CredsURL, c.Region, c.Environment),
 		DebugLog: c.DebugLog,
 	})
 }
 
-func (c *Config) NotificationClient() (*notification.Client, error) {
-	return c.notificationClient, c.notificationClientErr
+// NotificationClient returns the Notification client, creating it on first use
+func (c *Config) NotificationClient(regionEnvironment ...string) (*notification.Client, error) {
+	region, environment, override := c.resolve(regionEnvironment...)
+	if !override {
+		c.notificationOnce.Do(c.SetupNotificationClient)
+		return c.notificationClient, c.notificationClientErr
+	}
+	client, err := c.clients.get(clientKey{"notification", region, environment}, func() (interface{}, error) {
+		return c.newNotificationClient(region, environment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return client.(*notification.Client), nil
 }
 
-// SetupIAMClient sets up an HSDP IAM client
-func (c *Config) SetupIAMClient() {
-	var standardClient *http.Client
-	if c.RetryMax > 0 {
-		retryClient := retryablehttp.NewClient()
-		retryClient.RetryMax = c.RetryMax
-		standardClient = retryClient.StandardClient()
+// serviceURL returns the configured URL when targeting the provider defaults
+// and looks up the URL of the service for the region and environment otherwise
+func (c *Config) serviceURL(service, configured, region, environment string) string {
+	if configured != "" && region == c.Region && environment == c.Environment {
+		return c.endpoint(configured)
 	}
-	c.iamClient = nil
-	client, err := iam.NewClient(standardClient, &c.Config)
+	if environment == "" {
+		environment = "prod"
+	}
+	return c.endpoint(c.Discovery.Service(region, environment, service).URL)
+}
+
+// endpoint returns rawURL, or when a simulator is configured, rawURL with
+// its scheme and host replaced by those of the simulator. The path is kept
+// so services sharing the simulator stay apart.
+func (c *Config) endpoint(rawURL string) string {
+	if c.SimulatorURL == "" {
+		return rawURL
+	}
+	simulator, err := url.Parse(c.SimulatorURL)
 	if err != nil {
-		c.iamClientErr = fmt.Errorf("possible invalid environment/region: %w", err)
-		return
+		return rawURL
 	}
-	if c.ServiceID != "" && c.ServicePrivateKey != "" {
-		err = client.ServiceLogin(iam.Service{
-			ServiceID:  c.ServiceID,
-			PrivateKey: c.ServicePrivateKey,
-		})
-		if err != nil {
-			c.iamClientErr = fmt.Errorf("invalid IAM Service Identity credentials: %w", err)
-			return
-		}
+	u, err := url.Parse(rawURL)
+	if err != nil || rawURL == "" {
+		return c.SimulatorURL
 	}
-	if c.OrgAdminUsername != "" && c.OrgAdminPassword != "" {
-		if c.OAuth2ClientID == "" {
-			c.iamClientErr = ErrMissingClientID
-			return
-		}
-		err = client.Login(c.OrgAdminUsername, c.OrgAdminPassword)
-		if err != nil {
-			c.iamClientErr = fmt.Errorf("invalid IAM Org Admin credentials: %w", err)
-			return
+	u.Scheme = simulator.Scheme
+	u.Host = simulator.Host
+	u.User = nil
+	return u.String()
+}
+
+// RetryPolicy returns the retry policy of service, which is the provider
+// wide policy with any service specific overrides applied
+func (c *Config) RetryPolicy(service string) RetryPolicy {
+	policy := DefaultRetryPolicy()
+	if c.RetryMax > 0 {
+		policy.MaxRetries = c.RetryMax
+	}
+	policy = policy.Merge(c.Retry)
+	if override, ok := c.RetryServices[service]; ok {
+		policy = policy.Merge(override)
+	}
+	return policy
+}
+
+// HostPolicies returns the host policies shared by all transports
+func (c *Config) HostPolicies() *HostPolicies {
+	c.policiesOnce.Do(func() {
+		c.policies = NewHostPolicies(c.RetryPolicy(""))
+	})
+	return c.policies
+}
+
+// registerService applies the retry policy of service to the hosts of urls
+func (c *Config) registerService(service string, urls ...string) {
+	policy := c.RetryPolicy(service)
+	for _, u := range urls {
+		c.HostPolicies().Register(u, policy)
+	}
+}
+
+// httpClient returns an HTTP client for service which retries and rate
+// limits requests. A nil base uses a transport honoring proxy settings.
+func (c *Config) httpClient(service string, base http.RoundTripper, urls ...string) *http.Client {
+	return c.clientFor(c.retryTransport(service, base, urls...))
+}
+
+// retryTransport returns the transport retrying and rate limiting requests
+// of service on top of base
+func (c *Config) retryTransport(service string, base http.RoundTripper, urls ...string) *Transport {
+	if base == nil {
+		base = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
 		}
 	}
-	c.iamClient = client
+	if c.Tracer != nil {
+		base = &traceTransport{base: base, tracer: c.Tracer}
+	}
+	c.registerService(service, urls...)
+	return &Transport{
+		Base:     base,
+		Policies: c.HostPolicies(),
+	}
 }
 
-func (c *Config) SetupSTLClient() {
-	if c.consoleClientErr != nil {
-		c.stlClient = nil
-		c.stlClientErr = c.consoleClientErr
-		return
+func (c *Config) clientFor(transport http.RoundTripper) *http.Client {
+	if c.ReadOnly {
+		transport = &readOnlyTransport{base: transport}
 	}
-	region := c.Region
-	if region == "" {
-		region = "dev"
+	return &http.Client{
+		Transport: transport,
 	}
-	ac, err := config.New(config.WithRegion(region))
-	if err == nil {
-		if url := ac.Service("stl").URL; c.STLURL == "" {
-			c.STLURL = url
-		}
+}
+
+// FunctionHTTPClient returns an HTTP client for calling the function
+// gateway at endpoint
+func (c *Config) FunctionHTTPClient(endpoint string) *http.Client {
+	return c.httpClient("function", nil, endpoint)
+}
+
+// FunctionInvocationClient returns an HTTP client for invoking the function
+// at endpoint. An invocation may have run even when the gateway responds
+// with an error, so it is never retried.
+func (c *Config) FunctionInvocationClient(endpoint string) *http.Client {
+	transport := c.retryTransport("function", nil, endpoint)
+	transport.NoRetries = true
+	return c.clientFor(transport)
+}
+
+// SetupIAMClient sets up an HSDP IAM client
+func (c *Config) SetupIAMClient() {
+	c.iamClient, c.iamClientErr = c.newIAMClient(c.Region, c.Environment)
+}
+
+func (c *Config) newIAMClient(region, environment string) (*iam.Client, error) {
+	iamConfig := c.Config
+	if region != c.Region || environment != c.Environment {
+		iamConfig.Region = region
+		iamConfig.Environment = environment
+		iamConfig.IAMURL = ""
+		iamConfig.IDMURL = ""
 	}
-	client, err := stl.NewClient(c.consoleClient, &stl.Config{
-		STLAPIURL: c.STLURL,
-		DebugLog:  c.DebugLog,
-	})
+	if c.SimulatorURL != "" {
+		iamConfig.IAMURL = c.SimulatorURL
+		iamConfig.IDMURL = c.SimulatorURL
+	}
+	if iamConfig.IAMURL == "" {
+		iamConfig.IAMURL = c.Discovery.Service(region, environment, "iam").URL
+	}
+	if iamConfig.IDMURL == "" {
+		iamConfig.IDMURL = c.Discovery.Service(region, environment, "idm").URL
+	}
+	httpClient := c.httpClient("iam", nil)
+	reauth := &reauthTransport{base: httpClient.Transport}
+	httpClient.Transport = reauth
+	client, err := iam.NewClient(httpClient, &iamConfig)
 	if err != nil {
-		c.stlClient = nil
-		c.stlClientErr = err
-		return
+		return nil, fmt.Errorf("possible invalid environment/region: %w", err)
+	}
+	c.registerService("iam", iamConfig.IAMURL, iamConfig.IDMURL)
+	err = login(client, c.configCredentials())
+	if errors.Is(err, ErrMissingIAMCredentials) {
+		return client, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	reauth.renew = func(rejected string) (string, error) {
+		return c.renewToken(client, rejected)
 	}
-	c.stlClient = client
+	return client, nil
 }
 
-func (c *Config) SetupS3CredsClient() {
-	if c.iamClientErr != nil {
-		c.s3credsClient = nil
-		c.credsClientErr = c.iamClientErr
+// keepAlive logs client in again when its token expired and could not be
+// refreshed, so callers of IAMClient get a usable client during long runs
+func keepAlive(client *iam.Client) {
+	if client == nil {
 		return
 	}
-	if c.Region != "" {
-		env := c.Environment
-		if env == "" {
-			env = "prod"
-		}
-		ac, err := config.New(config.WithRegion(c.Region), config.WithEnv(env))
-		if err == nil {
-			if url := ac.Service("s3creds").URL; c.S3CredsURL == "" {
-				c.S3CredsURL = url
-			}
+	reauth, ok := client.HttpClient().Transport.(*reauthTransport)
+	if !ok || reauth.renew == nil || client.Token() != "" {
+		return
+	}
+	reauth.mu.Lock()
+	defer reauth.mu.Unlock()
+	_, _ = reauth.renew("")
+}
+
+func (c *Config) SetupSTLClient() {
+	c.stlClient, c.stlClientErr = c.newSTLClient(c.Region)
+}
+
+func (c *Config) newSTLClient(region string) (*stl.Client, error) {
+	consoleClient, err := c.ConsoleClient(region)
+	if err != nil {
+		return nil, err
+	}
+	stlURL := c.STLURL
+	if stlURL == "" || region != c.Region {
+		if region == "" {
+			region = "dev"
 		}
+		stlURL = c.Discovery.Service(region, "", "stl").URL
 	}
-	client, err := s3creds.NewClient(c.iamClient, &s3creds.Config{
-		BaseURL:  c.S3CredsURL,
-		DebugLog: c.DebugLog,
+	return stl.NewClient(consoleClient, &stl.Config{
+		STLAPIURL: c.endpoint(stlURL),
+		DebugLog:  c.DebugLog,
 	})
+}
+
+func (c *Config) SetupS3CredsClient() {
+	c.s3credsClient, c.credsClientErr = c.newS3CredsClient(c.Region, c.Environment)
+}
+
+func (c *Config) newS3CredsClient(region, environment string) (*s3creds.Client, error) {
+	iamClient, err := c.IAMClient(region, environment)
 	if err != nil {
-		c.s3credsClient = nil
-		c.credsClientErr = err
-		return
+		return nil, err
 	}
-	c.s3credsClient = client
+	s3credsURL := c.serviceURL("s3creds", c.S3CredsURL, region, environment)
+	c.registerService("s3creds", s3credsURL)
+	return s3creds.NewClient(iamClient, &s3creds.Config{
+		BaseURL:  s3credsURL,
+		DebugLog: c.DebugLog,
+	})
 }
 
 func (c *Config) SetupNotificationClient() {
-	if c.iamClientErr != nil {
-		c.notificationClient = nil
-		c.notificationClientErr = c.iamClientErr
-		return
-	}
-	if c.NotificationURL == "" {
-		env := c.Environment
-		if env == "" {
-			env = "prod"
-		}
-		ac, err := config.New(config.WithRegion(c.Region), config.WithEnv(env))
-		if err == nil {
-			if url := ac.Service("notification").URL; url != "" {
-				c.NotificationURL = url
-			}
-		}
+	c.notificationClient, c.notificationClientErr = c.newNotificationClient(c.Region, c.Environment)
+}
+
+func (c *Config) newNotificationClient(region, environment string) (*notification.Client, error) {
+	iamClient, err := c.IAMClient(region, environment)
+	if err != nil {
+		return nil, err
 	}
-	client, err := notification.NewClient(c.iamClient, &notification.Config{
-		NotificationURL: c.NotificationURL,
+	notificationURL := c.serviceURL("notification", c.NotificationURL, region, environment)
+	c.registerService("notification", notificationURL)
+	return notification.NewClient(iamClient, &notification.Config{
+		NotificationURL: notificationURL,
 		DebugLog:        c.DebugLog,
 	})
-	if err != nil {
-		c.notificationClient = nil
-		c.notificationClientErr = err
-		return
-	}
-	c.notificationClient = client
 }
 
 func (c *Config) SetupMDMClient() {
-	if c.iamClientErr != nil {
-		c.mdmClient = nil
-		c.mdmClientErr = c.iamClientErr
-		return
+	c.mdmClient, c.mdmClientErr = c.newMDMClient(c.Region, c.Environment)
+}
+
+func (c *Config) newMDMClient(region, environment string) (*mdm.Client, error) {
+	iamClient, err := c.IAMClient(region, environment)
+	if err != nil {
+		return nil, err
 	}
-	if c.MDMURL == "" {
-		env := c.Environment
-		if env == "" {
-			env = "prod"
-		}
-		ac, err := config.New(config.WithRegion(c.Region), config.WithEnv(env))
-		if err == nil {
-			url := ac.Service("connect-mdm").URL
-			if url != "" {
-				c.MDMURL = url
-			} else {
-				c.mdmClient = nil
-				c.mdmClientErr = fmt.Errorf("missing MDM URL (%s/%s), you can set a custom value using 'mdm_url'", env, c.Region)
-				return
-			}
-		}
+	mdmURL := c.serviceURL("connect-mdm", c.MDMURL, region, environment)
+	if mdmURL == "" {
+		return nil, fmt.Errorf("missing MDM URL (%s/%s), you can set a custom value using 'mdm_url'", environment, region)
 	}
-	client, err := mdm.NewClient(c.iamClient, &mdm.Config{
-		BaseURL:  c.MDMURL,
+	c.registerService("mdm", mdmURL)
+	client, err := mdm.NewClient(iamClient, &mdm.Config{
+		BaseURL:  mdmURL,
 		DebugLog: c.DebugLog,
 	})
 	if err != nil {
-		c.mdmClient = nil
-		c.mdmClientErr = fmt.Errorf("configuration error (%s/%s): %w", c.Environment, c.Region, err)
-		return
+		return nil, fmt.Errorf("configuration error (%s/%s): %w", environment, region, err)
 	}
-	c.mdmClient = client
+	return client, nil
 }
 
 // SetupCartelClient sets up an Cartel client
 func (c *Config) SetupCartelClient() {
-	if c.CartelHost == "" {
-		ac, err := config.New(config.WithRegion(c.Region))
-		if err == nil {
-			if host := ac.Service("cartel").Host; host != "" {
-				c.CartelHost = host
-			}
+	c.cartelClient, c.cartelClientErr = c.newCartelClient(c.Region)
+}
+
+func (c *Config) newCartelClient(region string) (*cartel.Client, error) {
+	host := c.CartelHost
+	if host == "" || region != c.Region {
+		if h := c.Discovery.Service(region, "", "cartel").Host; h != "" {
+			host = h
 		}
 	}
 	if c.CartelToken == "" || c.CartelSecret == "" {
-		c.cartelClient = nil
-		c.cartelClientErr = fmt.Errorf("missing Cartel token or secret, set 'cartel_token' and 'cartel_secret'")
-		return
+		return nil, fmt.Errorf("missing Cartel token or secret, set 'cartel_token' and 'cartel_secret'")
+	}
+	base := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: c.CartelSkipVerify},
+	}
+	noTLS := c.CartelNoTLS
+	if c.SimulatorURL != "" {
+		if u, err := url.Parse(c.SimulatorURL); err == nil {
+			host = u.Host
+			noTLS = u.Scheme == "http"
+		}
 	}
-	client, err := cartel.NewClient(nil, &cartel.Config{
-		Region:     c.Region,
-		Host:       c.CartelHost,
+	scheme := "https"
+	if noTLS {
+		scheme = "http"
+	}
+	return cartel.NewClient(c.httpClient("cartel", base, scheme+"://"+host), &cartel.Config{
+		Region:     region,
+		Host:       host,
 		Token:      c.CartelToken,
 		Secret:     c.CartelSecret,
-		NoTLS:      c.CartelNoTLS,
+		NoTLS:      noTLS,
 		SkipVerify: c.CartelSkipVerify,
 		DebugLog:   c.DebugLog,
 	})
-	if err != nil {
-		c.cartelClient = nil
-		c.cartelClientErr = err
-		return
-	}
-	c.cartelClient = client
 }
 
 // SetupConsoleClient sets up an Console client
 func (c *Config) SetupConsoleClient() {
-	client, err := console.NewClient(nil, &console.Config{
-		Region:   c.Region,
-		DebugLog: c.DebugLog,
+	c.consoleClient, c.consoleClientErr = c.newConsoleClient(c.Region)
+}
+
+func (c *Config) newConsoleClient(region string) (*console.Client, error) {
+	uaaURL := c.Discovery.Service(region, "", "uaa").URL
+	consoleURL := c.Discovery.Service(region, "", "console").URL
+	if c.SimulatorURL != "" {
+		uaaURL, consoleURL = c.SimulatorURL, c.SimulatorURL
+	}
+	client, err := console.NewClient(c.httpClient("console", nil), &console.Config{
+		Region:         region,
+		UAAURL:         uaaURL,
+		BaseConsoleURL: consoleURL,
+		DebugLog:       c.DebugLog,
 	})
 	if err != nil {
-		c.consoleClient = nil
-		c.consoleClientErr = err
-		return
+		return nil, err
 	}
 	if c.UAAUsername == "" || c.UAAPassword == "" {
-		c.consoleClientErr = ErrMissingUAACredentials
-		c.consoleClient = nil
-		return
+		return nil, ErrMissingUAACredentials
 	}
 	err = client.Login(c.UAAUsername, c.UAAPassword)
 	if err != nil {
-		c.consoleClientErr = err
-		return
+		return nil, err
 	}
-	c.consoleClient = client
+	return client, nil
 }
 
 func (c *Config) GetFHIRClientFromEndpoint(endpointURL string) (*cdr.Client, error) {
-	if c.iamClientErr != nil {
-		return nil, c.iamClientErr
+	iamClient, err := c.IAMClient()
+	if err != nil {
+		return nil, err
 	}
-	client, err := cdr.NewClient(c.iamClient, &cdr.Config{
+	endpointURL = c.endpoint(endpointURL)
+	c.registerService("cdr", endpointURL)
+	client, err := cdr.NewClient(iamClient, &cdr.Config{
 		CDRURL:    "https://localhost.domain",
 		RootOrgID: "",
 		TimeZone:  c.TimeZone,
@@ -377,10 +652,13 @@ func (c *Config) GetFHIRClientFromEndpoint(endpointURL string) (*cdr.Client, err
 }
 
 func (c *Config) GetCDLClientFromEndpoint(endpointURL string) (*cdl.Client, error) {
-	if c.iamClientErr != nil {
-		return nil, c.iamClientErr
+	iamClient, err := c.IAMClient()
+	if err != nil {
+		return nil, err
 	}
-	client, err := cdl.NewClient(c.iamClient, &cdl.Config{
+	endpointURL = c.endpoint(endpointURL)
+	c.registerService("cdl", endpointURL)
+	client, err := cdl.NewClient(iamClient, &cdl.Config{
 		CDLURL:   "https://localhost.domain",
 		DebugLog: c.DebugLog,
 	})
@@ -395,13 +673,16 @@ func (c *Config) GetCDLClientFromEndpoint(endpointURL string) (*cdl.Client, erro
 
 // GetCDLClient creates a HSDP CDL client
 func (c *Config) GetCDLClient(baseURL, tenantID string) (*cdl.Client, error) {
-	if c.iamClientErr != nil {
-		return nil, fmt.Errorf("IAM client error in GetCDLClient: %w", c.iamClientErr)
+	iamClient, err := c.IAMClient()
+	if err != nil {
+		return nil, fmt.Errorf("IAM client error in GetCDLClient: %w", err)
 	}
 	if tenantID == "" {
 		return nil, fmt.Errorf("GetCDLClient: %w", ErrMissingOrganizationID)
 	}
-	client, err := cdl.NewClient(c.iamClient, &cdl.Config{
+	baseURL = c.endpoint(baseURL)
+	c.registerService("cdl", baseURL)
+	client, err := cdl.NewClient(iamClient, &cdl.Config{
 		CDLURL:         baseURL,
 		OrganizationID: tenantID,
 		DebugLog:       c.DebugLog,
@@ -413,13 +694,16 @@ func (c *Config) GetCDLClient(baseURL, tenantID string) (*cdl.Client, error) {
 }
 
 func (c *Config) GetAIInferenceClient(baseURL, tenantID string) (*inference.Client, error) {
-	if c.iamClientErr != nil {
-		return nil, fmt.Errorf("IAM client error in getAIInferenceClient: %w", c.iamClientErr)
+	iamClient, err := c.IAMClient()
+	if err != nil {
+		return nil, fmt.Errorf("IAM client error in getAIInferenceClient: %w", err)
 	}
 	if tenantID == "" {
 		return nil, fmt.Errorf("getAIInferenceClient: %w", ErrMissingOrganizationID)
 	}
-	client, err := inference.NewClient(c.iamClient, &ai.Config{
+	baseURL = c.endpoint(baseURL)
+	c.registerService("ai", baseURL)
+	client, err := inference.NewClient(iamClient, &ai.Config{
 		BaseURL:        baseURL,
 		OrganizationID: tenantID,
 		DebugLog:       c.DebugLog,
@@ -431,13 +715,16 @@ func (c *Config) GetAIInferenceClient(baseURL, tenantID string) (*inference.Clie
 }
 
 func (c *Config) GetAIInferenceClientFromEndpoint(endpointURL string) (*inference.Client, error) {
-	if c.iamClientErr != nil {
-		return nil, c.iamClientErr
+	iamClient, err := c.IAMClient()
+	if err != nil {
+		return nil, err
 	}
 	if endpointURL == "" {
 		endpointURL = c.AIInferenceEndpoint
 	}
-	client, err := inference.NewClient(c.iamClient, &ai.Config{
+	endpointURL = c.endpoint(endpointURL)
+	c.registerService("ai", endpointURL)
+	client, err := inference.NewClient(iamClient, &ai.Config{
 		BaseURL:        "http://localhost",
 		OrganizationID: "not-set",
 		DebugLog:       c.DebugLog,
@@ -452,13 +739,16 @@ func (c *Config) GetAIInferenceClientFromEndpoint(endpointURL string) (*inferenc
 }
 
 func (c *Config) GetAIWorkspaceClient(baseURL, tenantID string) (*workspace.Client, error) {
-	if c.iamClientErr != nil {
-		return nil, fmt.Errorf("IAM client error in getAIWorkspaceClient: %w", c.iamClientErr)
+	iamClient, err := c.IAMClient()
+	if err != nil {
+		return nil, fmt.Errorf("IAM client error in getAIWorkspaceClient: %w", err)
 	}
 	if tenantID == "" {
 		return nil, fmt.Errorf("getAIWorkspaceClient: %w", ErrMissingOrganizationID)
 	}
-	client, err := workspace.NewClient(c.iamClient, &ai.Config{
+	baseURL = c.endpoint(baseURL)
+	c.registerService("ai", baseURL)
+	client, err := workspace.NewClient(iamClient, &ai.Config{
 		BaseURL:        baseURL,
 		OrganizationID: tenantID,
 		DebugLog:       c.DebugLog,
@@ -470,13 +760,16 @@ func (c *Config) GetAIWorkspaceClient(baseURL, tenantID string) (*workspace.Clie
 }
 
 func (c *Config) GetAIWorkspaceClientFromEndpoint(endpointURL string) (*workspace.Client, error) {
-	if c.iamClientErr != nil {
-		return nil, c.iamClientErr
+	iamClient, err := c.IAMClient()
+	if err != nil {
+		return nil, err
 	}
 	if endpointURL == "" {
 		endpointURL = c.AIWorkspaceEndpoint
 	}
-	client, err := workspace.NewClient(c.iamClient, &ai.Config{
+	endpointURL = c.endpoint(endpointURL)
+	c.registerService("ai", endpointURL)
+	client, err := workspace.NewClient(iamClient, &ai.Config{
 		BaseURL:        "http://localhost",
 		OrganizationID: "not-set",
 		DebugLog:       c.DebugLog,
@@ -492,13 +785,16 @@ func (c *Config) GetAIWorkspaceClientFromEndpoint(endpointURL string) (*workspac
 
 // GetFHIRClient creates a HSDP CDR client
 func (c *Config) GetFHIRClient(baseURL, rootOrgID string) (*cdr.Client, error) {
-	if c.iamClientErr != nil {
-		return nil, fmt.Errorf("IAM client error in GetFHIRClient: %w", c.iamClientErr)
+	iamClient, err := c.IAMClient()
+	if err != nil {
+		return nil, fmt.Errorf("IAM client error in GetFHIRClient: %w", err)
 	}
 	if rootOrgID == "" {
 		return nil, fmt.Errorf("GetFHIRClient: %w", ErrMissingOrganizationID)
 	}
-	client, err := cdr.NewClient(c.iamClient, &cdr.Config{
+	baseURL = c.endpoint(baseURL)
+	c.registerService("cdr", baseURL)
+	client, err := cdr.NewClient(iamClient, &cdr.Config{
 		CDRURL:    baseURL,
 		RootOrgID: rootOrgID,
 		TimeZone:  c.TimeZone,
@@ -511,18 +807,24 @@ func (c *Config) GetFHIRClient(baseURL, rootOrgID string) (*cdr.Client, error) {
 }
 
 func (c *Config) Debug(format string, a ...interface{}) (int, error) {
+	output := fmt.Sprintf(format, a...)
+	if c.Tracer != nil {
+		c.Tracer.Record(TraceEvent{Message: pemBlock.ReplaceAllString(output, redacted)})
+	}
 	if c.DebugFile != nil {
-		output := fmt.Sprintf(format, a...)
 		return c.DebugFile.WriteString(output)
 	}
-	return 0, nil
+	return len(output), nil
 }
 
 func (c *Config) GetDICOMConfigClient(url string) (*dicom.Client, error) {
-	if c.iamClientErr != nil {
-		return nil, fmt.Errorf("DICM client error in GetDICOMConfigClient: %w", c.iamClientErr)
+	iamClient, err := c.IAMClient()
+	if err != nil {
+		return nil, fmt.Errorf("DICM client error in GetDICOMConfigClient: %w", err)
 	}
-	client, err := dicom.NewClient(c.iamClient, &dicom.Config{
+	url = c.endpoint(url)
+	c.registerService("dicom", url)
+	client, err := dicom.NewClient(iamClient, &dicom.Config{
 		DICOMConfigURL: url,
 		TimeZone:       c.TimeZone,
 		DebugLog:       c.DebugLog,
@@ -534,24 +836,23 @@ func (c *Config) GetDICOMConfigClient(url string) (*dicom.Client, error) {
 }
 
 func (c *Config) SetupPKIClient() {
-	if c.iamClientErr != nil {
-		c.pkiClientErr = fmt.Errorf("IAM client error in setupPKIClient: %w", c.iamClientErr)
-		return
+	c.pkiClient, c.pkiClientErr = c.newPKIClient(c.Region, c.Environment)
+}
+
+func (c *Config) newPKIClient(region, environment string) (*pki.Client, error) {
+	iamClient, err := c.IAMClient(region, environment)
+	if err != nil {
+		return nil, fmt.Errorf("IAM client error in setupPKIClient: %w", err)
 	}
-	if c.consoleClientErr != nil {
-		c.pkiClientErr = fmt.Errorf("console client error in setupPKIClient: %w", c.consoleClientErr)
-		return
+	consoleClient, err := c.ConsoleClient(region)
+	if err != nil {
+		return nil, fmt.Errorf("console client error in setupPKIClient: %w", err)
 	}
-	client, err := pki.NewClient(c.consoleClient, c.iamClient, &pki.Config{
-		Region:      c.Region,
-		Environment: c.Environment,
+	return pki.NewClient(consoleClient, iamClient, &pki.Config{
+		Region:      region,
+		Environment: environment,
+		PKIURL:      c.Discovery.Service(region, environment, "pki").URL,
+		UAAURL:      c.Discovery.Service(region, "", "uaa").URL,
 		DebugLog:    c.DebugLog,
 	})
-	if err != nil {
-		c.pkiClient = nil
-		c.pkiClientErr = err
-		return
-	}
-	c.pkiClient = client
-	c.pkiClientErr = nil
 }
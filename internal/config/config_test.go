@@ -22,3 +22,45 @@ func TestConfig(t *testing.T) {
 
 	assert.NotNil(t, c.iamClientErr)
 }
+
+func TestLazyCartelClient(t *testing.T) {
+	c := &Config{}
+
+	c.Region = "us-east"
+
+	assert.Nil(t, c.cartelClient)
+	assert.Nil(t, c.cartelClientErr)
+
+	client, err := c.CartelClient()
+	assert.Nil(t, client)
+	assert.NotNil(t, err)
+
+	c.CartelToken = "token"
+	c.CartelSecret = "secret"
+	_, again := c.CartelClient()
+	assert.Equal(t, err, again, "client errors should be remembered")
+}
+
+func TestRegionalCartelClients(t *testing.T) {
+	c := &Config{}
+
+	c.Region = "us-east"
+	c.CartelToken = "token"
+	c.CartelSecret = "secret"
+
+	east, err := c.CartelClient()
+	if !assert.Nil(t, err) {
+		return
+	}
+	west, err := c.CartelClient("eu-west")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.NotSame(t, east, west)
+
+	again, err := c.CartelClient("eu-west")
+	assert.Nil(t, err)
+	assert.Same(t, west, again, "regional clients should be cached")
+	defaultAgain, _ := c.CartelClient("us-east")
+	assert.Same(t, east, defaultAgain)
+}
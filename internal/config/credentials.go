@@ -0,0 +1,256 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// credentialProcessTimeout limits how long a credential_process may run
+const credentialProcessTimeout = 60 * time.Second
+
+// Credentials holds the secrets the provider authenticates with. The JSON
+// field names match the provider arguments.
+type Credentials struct {
+	ServiceID         string `json:"service_id,omitempty"`
+	ServicePrivateKey string `json:"service_private_key,omitempty"`
+	OrgAdminUsername  string `json:"org_admin_username,omitempty"`
+	OrgAdminPassword  string `json:"org_admin_password,omitempty"`
+	OAuth2ClientID    string `json:"oauth2_client_id,omitempty"`
+	OAuth2Secret      string `json:"oauth2_password,omitempty"`
+	UAAUsername       string `json:"uaa_username,omitempty"`
+	UAAPassword       string `json:"uaa_password,omitempty"`
+	CartelToken       string `json:"cartel_token,omitempty"`
+	CartelSecret      string `json:"cartel_secret,omitempty"`
+	SharedKey         string `json:"shared_key,omitempty"`
+	SecretKey         string `json:"secret_key,omitempty"`
+}
+
+// fields returns pointers to all credential fields, keyed by JSON name
+func (c *Credentials) fields() map[string]*string {
+	return map[string]*string{
+		"service_id":          &c.ServiceID,
+		"service_private_key": &c.ServicePrivateKey,
+		"org_admin_username":  &c.OrgAdminUsername,
+		"org_admin_password":  &c.OrgAdminPassword,
+		"oauth2_client_id":    &c.OAuth2ClientID,
+		"oauth2_password":     &c.OAuth2Secret,
+		"uaa_username":        &c.UAAUsername,
+		"uaa_password":        &c.UAAPassword,
+		"cartel_token":        &c.CartelToken,
+		"cartel_secret":       &c.CartelSecret,
+		"shared_key":          &c.SharedKey,
+		"secret_key":          &c.SecretKey,
+	}
+}
+
+// Merge returns c with its empty fields taken from other
+func (c Credentials) Merge(other Credentials) Credentials {
+	merged := c
+	from := other.fields()
+	for name, field := range merged.fields() {
+		if *field == "" {
+			*field = *from[name]
+		}
+	}
+	return merged
+}
+
+// CredentialSources lists the places credentials are read from when the
+// provider block does not set them. Earlier sources take precedence.
+type CredentialSources struct {
+	// File is a JSON file with credentials. Vault KV responses, which nest
+	// the values under "data", are accepted as well.
+	File string
+	// Dir is a directory with one file per credential, named after the
+	// argument, as written by a Vault agent or similar sidecar
+	Dir string
+	// Process is a command which prints the credentials as JSON
+	Process string
+}
+
+// Empty reports whether no source is configured
+func (s CredentialSources) Empty() bool {
+	return s.File == "" && s.Dir == "" && s.Process == ""
+}
+
+// Merge returns s with its empty sources taken from other
+func (s CredentialSources) Merge(other CredentialSources) CredentialSources {
+	if s.File == "" {
+		s.File = other.File
+	}
+	if s.Dir == "" {
+		s.Dir = other.Dir
+	}
+	if s.Process == "" {
+		s.Process = other.Process
+	}
+	return s
+}
+
+// Load reads the credentials from all configured sources
+func (s CredentialSources) Load() (Credentials, error) {
+	var creds Credentials
+	if s.File != "" {
+		fromFile, err := readCredentialsFile(s.File)
+		if err != nil {
+			return creds, err
+		}
+		creds = creds.Merge(fromFile)
+	}
+	if s.Dir != "" {
+		fromDir, err := readCredentialsDir(s.Dir)
+		if err != nil {
+			return creds, err
+		}
+		creds = creds.Merge(fromDir)
+	}
+	if s.Process != "" {
+		fromProcess, err := runCredentialProcess(s.Process)
+		if err != nil {
+			return creds, err
+		}
+		creds = creds.Merge(fromProcess)
+	}
+	return creds, nil
+}
+
+func readCredentialsFile(path string) (Credentials, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("credentials file: %w", err)
+	}
+	creds, err := parseCredentials(data)
+	if err != nil {
+		return creds, fmt.Errorf("credentials file %s: %w", path, err)
+	}
+	return creds, nil
+}
+
+func readCredentialsDir(dir string) (Credentials, error) {
+	var creds Credentials
+	if _, err := os.Stat(dir); err != nil {
+		return creds, fmt.Errorf("credentials directory: %w", err)
+	}
+	for name, field := range creds.fields() {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return creds, fmt.Errorf("credentials directory: %w", err)
+		}
+		*field = strings.TrimRight(string(data), "\r\n")
+	}
+	return creds, nil
+}
+
+func runCredentialProcess(command string) (Credentials, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialProcessTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd.exe", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return Credentials{}, fmt.Errorf("credential_process: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	creds, err := parseCredentials(stdout.Bytes())
+	if err != nil {
+		return creds, fmt.Errorf("credential_process output: %w", err)
+	}
+	return creds, nil
+}
+
+// parseCredentials decodes a flat JSON object of credentials, or a Vault KV
+// version 1 or 2 response containing one
+func parseCredentials(data []byte) (Credentials, error) {
+	var creds Credentials
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return creds, err
+	}
+	for i := 0; i < 2; i++ {
+		nested, ok := doc["data"]
+		if !ok {
+			break
+		}
+		var inner map[string]json.RawMessage
+		if err := json.Unmarshal(nested, &inner); err != nil {
+			break
+		}
+		doc = inner
+	}
+	flat, _ := json.Marshal(doc)
+	if err := json.Unmarshal(flat, &creds); err != nil {
+		return creds, err
+	}
+	return creds, nil
+}
+
+// LoadCredentials fills the credentials not set in the provider block from
+// sources. The sources are read again whenever IAM requires a new login, so
+// rotated secrets are picked up during long runs.
+func (c *Config) LoadCredentials(sources CredentialSources) error {
+	c.explicitCredentials = c.configCredentials()
+	c.credentialSources = sources
+	creds, err := c.credentials()
+	if err != nil {
+		return err
+	}
+	c.ServiceID = creds.ServiceID
+	c.ServicePrivateKey = creds.ServicePrivateKey
+	c.OrgAdminUsername = creds.OrgAdminUsername
+	c.OrgAdminPassword = creds.OrgAdminPassword
+	c.OAuth2ClientID = creds.OAuth2ClientID
+	c.OAuth2Secret = creds.OAuth2Secret
+	c.UAAUsername = creds.UAAUsername
+	c.UAAPassword = creds.UAAPassword
+	c.CartelToken = creds.CartelToken
+	c.CartelSecret = creds.CartelSecret
+	c.SharedKey = creds.SharedKey
+	c.SecretKey = creds.SecretKey
+	return nil
+}
+
+// credentials returns the current credentials, reading the sources again
+func (c *Config) credentials() (Credentials, error) {
+	if c.credentialSources.Empty() {
+		return c.configCredentials(), nil
+	}
+	loaded, err := c.credentialSources.Load()
+	if err != nil {
+		return Credentials{}, err
+	}
+	return c.explicitCredentials.Merge(loaded), nil
+}
+
+func (c *Config) configCredentials() Credentials {
+	return Credentials{
+		ServiceID:         c.ServiceID,
+		ServicePrivateKey: c.ServicePrivateKey,
+		OrgAdminUsername:  c.OrgAdminUsername,
+		OrgAdminPassword:  c.OrgAdminPassword,
+		OAuth2ClientID:    c.OAuth2ClientID,
+		OAuth2Secret:      c.OAuth2Secret,
+		UAAUsername:       c.UAAUsername,
+		UAAPassword:       c.UAAPassword,
+		CartelToken:       c.CartelToken,
+		CartelSecret:      c.CartelSecret,
+		SharedKey:         c.SharedKey,
+		SecretKey:         c.SecretKey,
+	}
+}
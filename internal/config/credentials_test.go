@@ -0,0 +1,102 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadCredentials(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "credentials.json")
+	_ = os.WriteFile(file, []byte(`{"data":{"data":{"org_admin_username":"file-user","org_admin_password":"file-password"}}}`), 0600)
+	secrets := filepath.Join(dir, "secrets")
+	_ = os.Mkdir(secrets, 0700)
+	_ = os.WriteFile(filepath.Join(secrets, "org_admin_password"), []byte("dir-password\n"), 0600)
+	_ = os.WriteFile(filepath.Join(secrets, "cartel_token"), []byte("dir-token\n"), 0600)
+
+	c := &Config{}
+	c.OAuth2ClientID = "explicit-client"
+	c.OrgAdminUsername = "explicit-user"
+	sources := CredentialSources{File: file, Dir: secrets}
+	if runtime.GOOS != "windows" {
+		sources.Process = `echo '{"cartel_secret":"process-secret","oauth2_client_id":"process-client"}'`
+	}
+	if !assert.Nil(t, c.LoadCredentials(sources)) {
+		return
+	}
+	assert.Equal(t, "explicit-user", c.OrgAdminUsername)
+	assert.Equal(t, "explicit-client", c.OAuth2ClientID)
+	assert.Equal(t, "file-password", c.OrgAdminPassword)
+	assert.Equal(t, "dir-token", c.CartelToken)
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, "process-secret", c.CartelSecret)
+	}
+
+	// Rotated secrets are picked up on the next login
+	_ = os.WriteFile(file, []byte(`{"org_admin_password":"rotated"}`), 0600)
+	creds, err := c.credentials()
+	if assert.Nil(t, err) {
+		assert.Equal(t, "rotated", creds.OrgAdminPassword)
+		assert.Equal(t, "explicit-user", creds.OrgAdminUsername)
+	}
+
+	err = c.LoadCredentials(CredentialSources{File: filepath.Join(dir, "missing.json")})
+	assert.NotNil(t, err)
+}
+
+func TestReauthTransport(t *testing.T) {
+	var posted []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body := make([]byte, r.ContentLength)
+		_, _ = r.Body.Read(body)
+		posted = append(posted, string(body))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	renewals := 0
+	transport := &reauthTransport{
+		base: http.DefaultTransport,
+		renew: func(rejected string) (string, error) {
+			assert.Equal(t, "expired", rejected)
+			renewals++
+			return "fresh", nil
+		},
+	}
+	client := &http.Client{Transport: transport}
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/authorize/identity/Group", nil)
+	req.Header.Set("Authorization", "Bearer expired")
+	resp, err := client.Do(req)
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, ts.URL+"/authorize/identity/Group", strings.NewReader("payload"))
+	req.Header.Set("Authorization", "Bearer expired")
+	resp, err = client.Do(req)
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.Equal(t, 2, renewals)
+	assert.Equal(t, []string{"", "payload"}, posted)
+
+	// Token requests are never replayed
+	req, _ = http.NewRequest(http.MethodPost, ts.URL+"/authorize/oauth2/token", nil)
+	resp, err = client.Do(req)
+	if assert.Nil(t, err) {
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+	assert.Equal(t, 2, renewals)
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/philips-software/go-hsdp-api/config"
+	"github.com/philips-software/terraform-provider-hsdp/internal/tools"
+	"gopkg.in/yaml.v3"
+)
+
+// standardEnvironments are the environments every HSDP region may offer
+var standardEnvironments = []string{"dev", "client-test", "prod"}
+
+// Discovery resolves the endpoints of HSDP services. It extends the catalog
+// embedded in go-hsdp-api with the regions, environments and services of a
+// service discovery file so private deployments can be targeted. A nil
+// Discovery resolves using the embedded catalog only.
+type Discovery struct {
+	File  string
+	world config.World
+}
+
+// LoadDiscovery reads the service discovery file at path. The file uses
+// the layout of the go-hsdp-api catalog and may be JSON or, when its name
+// ends in .yaml or .yml, YAML. An empty path loads no overrides.
+func LoadDiscovery(path string) (*Discovery, error) {
+	d := &Discovery{File: path}
+	if path == "" {
+		return d, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("service discovery file: %w", err)
+	}
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		// Round trip through JSON so the catalog json tags apply
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("service discovery file '%s': %w", path, err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return nil, fmt.Errorf("service discovery file '%s': %w", path, err)
+		}
+	}
+	if err := json.Unmarshal(data, &d.world); err != nil {
+		return nil, fmt.Errorf("service discovery file '%s': %w", path, err)
+	}
+	return d, nil
+}
+
+// Service returns the endpoint details of service in region and environment.
+// Entries of the discovery file override the embedded catalog field by
+// field, environment entries taking precedence over region entries.
+func (d *Discovery) Service(region, environment, service string) config.Service {
+	var found config.Service
+	if ac, err := config.New(config.WithRegion(region), config.WithEnv(environment)); err == nil {
+		found = *ac.Service(service)
+	}
+	if d == nil {
+		return found
+	}
+	r, ok := d.world.Regions[region]
+	if !ok {
+		return found
+	}
+	found = mergeService(found, r.Services[service])
+	if env, ok := r.Environments[environment]; ok {
+		found = mergeService(found, env.Services[service])
+	}
+	return found
+}
+
+// Services returns the names of the services known in region and environment
+func (d *Discovery) Services(region, environment string) []string {
+	var services []string
+	if ac, err := config.New(config.WithRegion(region), config.WithEnv(environment)); err == nil {
+		services = ac.Services()
+	}
+	if d != nil {
+		r := d.world.Regions[region]
+		for s := range r.Services {
+			services = append(services, s)
+		}
+		for s := range r.Environments[environment].Services {
+			services = append(services, s)
+		}
+	}
+	return uniqueSorted(services)
+}
+
+// Regions returns the names of all known regions
+func (d *Discovery) Regions() []string {
+	var regions []string
+	if ac, err := config.New(); err == nil {
+		regions = ac.Regions()
+	}
+	if d != nil {
+		for r := range d.world.Regions {
+			regions = append(regions, r)
+		}
+	}
+	return uniqueSorted(regions)
+}
+
+// ValidateRegion warns when region lacks Cloud foundry or production IAM
+func (d *Discovery) ValidateRegion(region string) (warns []string, es []error) {
+	if d.Service(region, "", "cf").URL == "" {
+		warns = append(warns, fmt.Sprintf("no Cloud foundry presence in region '%s'", region))
+	}
+	if d.Service(region, "prod", "iam").URL == "" {
+		warns = append(warns, fmt.Sprintf("no production IAM presence in region '%s'", region))
+	}
+	return
+}
+
+// ValidateEnvironment rejects environments which are neither a standard
+// HSDP one nor listed for region in the discovery file
+func (d *Discovery) ValidateEnvironment(region, environment string) (warns []string, es []error) {
+	if tools.ContainsString(standardEnvironments, environment) {
+		return
+	}
+	if d != nil {
+		if _, ok := d.world.Regions[region].Environments[environment]; ok {
+			return
+		}
+	}
+	es = append(es, fmt.Errorf("environment '%s' is not a supported one", environment))
+	return
+}
+
+func mergeService(base, override config.Service) config.Service {
+	if override.URL != "" {
+		base.URL = override.URL
+	}
+	if override.Host != "" {
+		base.Host = override.Host
+	}
+	if override.Domain != "" {
+		base.Domain = override.Domain
+	}
+	return base
+}
+
+func uniqueSorted(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	unique := make([]string, 0, len(list))
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
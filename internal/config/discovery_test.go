@@ -0,0 +1,59 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscovery(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "discovery.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+region:
+  tenant1:
+    service:
+      cartel:
+        host: cartel.tenant1.example.com
+    env:
+      acceptance:
+        service:
+          iam:
+            url: https://iam.tenant1.example.com
+          notification:
+            url: https://notification.tenant1.example.com
+  us-east:
+    service:
+      cartel:
+        host: cartel.private.example.com
+`), 0600))
+
+	d, err := LoadDiscovery(file)
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://iam.tenant1.example.com", d.Service("tenant1", "acceptance", "iam").URL)
+	assert.Equal(t, "cartel.private.example.com", d.Service("us-east", "prod", "cartel").Host)
+	assert.Equal(t, "https://iam-service.us-east.philips-healthsuite.com", d.Service("us-east", "prod", "iam").URL,
+		"services missing from the file come from the embedded catalog")
+	assert.Equal(t, []string{"cartel", "iam", "notification"}, d.Services("tenant1", "acceptance"))
+	assert.Contains(t, d.Regions(), "tenant1")
+	assert.Contains(t, d.Regions(), "eu-west")
+
+	_, errs := d.ValidateEnvironment("tenant1", "acceptance")
+	assert.Empty(t, errs)
+	_, errs = d.ValidateEnvironment("us-east", "acceptance")
+	assert.NotEmpty(t, errs)
+
+	c := &Config{Discovery: d}
+	assert.Equal(t, "https://notification.tenant1.example.com", c.serviceURL("notification", "", "tenant1", "acceptance"))
+
+	var builtin *Discovery
+	assert.Equal(t, "cartel-na1.cloud.phsdp.com", builtin.Service("us-east", "", "cartel").Host)
+	warns, _ := builtin.ValidateRegion("tenant1")
+	assert.Len(t, warns, 2)
+
+	_, err = LoadDiscovery(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
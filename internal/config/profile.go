@@ -0,0 +1,130 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SharedConfigFileEnv overrides the location of the shared config file
+const SharedConfigFileEnv = "HSDP_CONFIG_FILE"
+
+// Profile holds the settings of a named profile in the shared config file
+type Profile struct {
+	Name            string
+	Region          string
+	Environment     string
+	IAMURL          string
+	IDMURL          string
+	MDMURL          string
+	S3CredsURL      string
+	NotificationURL string
+	UAAURL          string
+	CartelHost      string
+	Credentials     CredentialSources
+}
+
+// fields returns pointers to the profile settings, keyed by file key
+func (p *Profile) fields() map[string]*string {
+	return map[string]*string{
+		"region":             &p.Region,
+		"environment":        &p.Environment,
+		"iam_url":            &p.IAMURL,
+		"idm_url":            &p.IDMURL,
+		"mdm_url":            &p.MDMURL,
+		"s3creds_url":        &p.S3CredsURL,
+		"notification_url":   &p.NotificationURL,
+		"uaa_url":            &p.UAAURL,
+		"cartel_host":        &p.CartelHost,
+		"credentials_file":   &p.Credentials.File,
+		"credentials_dir":    &p.Credentials.Dir,
+		"credential_process": &p.Credentials.Process,
+	}
+}
+
+// SharedConfigFile returns the path of the shared config file, which is
+// ~/.hsdp/config unless overridden through HSDP_CONFIG_FILE
+func SharedConfigFile() string {
+	if file := os.Getenv(SharedConfigFileEnv); file != "" {
+		return file
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".hsdp", "config")
+}
+
+// LoadProfile reads profile name from the INI style config file. Sections
+// are named either [name] or [profile name], like the AWS shared config.
+func LoadProfile(file, name string) (*Profile, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("shared config: %w", err)
+	}
+	defer f.Close()
+
+	var profile *Profile
+	var fields map[string]*string
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section = strings.TrimSpace(strings.TrimPrefix(strings.Trim(text, "[]"), "profile "))
+			if section == name && profile == nil {
+				profile = &Profile{Name: name}
+				fields = profile.fields()
+			}
+			continue
+		}
+		if section != name {
+			continue
+		}
+		parts := strings.SplitN(text, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected key = value", file, line)
+		}
+		key := strings.TrimSpace(parts[0])
+		field, ok := fields[key]
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: unknown setting '%s' in profile '%s'", file, line, key, name)
+		}
+		*field = strings.Trim(strings.TrimSpace(parts[1]), `"`)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("shared config: %w", err)
+	}
+	if profile == nil {
+		return nil, fmt.Errorf("profile '%s' not found in %s", name, file)
+	}
+	return profile, nil
+}
+
+// ApplyProfile fills the settings which are not set yet from profile
+func (c *Config) ApplyProfile(profile *Profile) {
+	settings := []struct {
+		field *string
+		value string
+	}{
+		{&c.Region, profile.Region},
+		{&c.Environment, profile.Environment},
+		{&c.IAMURL, profile.IAMURL},
+		{&c.IDMURL, profile.IDMURL},
+		{&c.MDMURL, profile.MDMURL},
+		{&c.S3CredsURL, profile.S3CredsURL},
+		{&c.NotificationURL, profile.NotificationURL},
+		{&c.UAAURL, profile.UAAURL},
+		{&c.CartelHost, profile.CartelHost},
+	}
+	for _, s := range settings {
+		if *s.field == "" {
+			*s.field = s.value
+		}
+	}
+}
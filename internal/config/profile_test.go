@@ -0,0 +1,49 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sharedConfig = `# Shared HSDP settings
+[default]
+region = us-east
+
+[profile eu]
+region      = eu-west
+environment = prod
+cartel_host = "cartel.example.com"
+credentials_dir = /vault/secrets/hsdp
+
+[broken]
+regoin = eu-west
+`
+
+func TestLoadProfile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config")
+	_ = os.WriteFile(file, []byte(sharedConfig), 0600)
+
+	profile, err := LoadProfile(file, "eu")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "eu-west", profile.Region)
+	assert.Equal(t, "prod", profile.Environment)
+	assert.Equal(t, "cartel.example.com", profile.CartelHost)
+	assert.Equal(t, "/vault/secrets/hsdp", profile.Credentials.Dir)
+
+	c := &Config{}
+	c.Environment = "client-test"
+	c.ApplyProfile(profile)
+	assert.Equal(t, "eu-west", c.Region)
+	assert.Equal(t, "client-test", c.Environment, "explicit settings take precedence")
+	assert.Equal(t, "cartel.example.com", c.CartelHost)
+
+	_, err = LoadProfile(file, "broken")
+	assert.NotNil(t, err)
+	_, err = LoadProfile(file, "missing")
+	assert.NotNil(t, err)
+}
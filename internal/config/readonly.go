@@ -0,0 +1,84 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// cartelReadEndpoints are the Cartel API calls which only read, even though
+// every Cartel call is a POST
+var cartelReadEndpoints = []string{
+	"instance_details", "deployment_status", "get_all_instances", "get_security_groups",
+	"security_group_details", "get_all_roles", "get_all_subnets",
+}
+
+// readOnlyTransport is an http.RoundTripper which refuses requests that may
+// change anything at HSDP. It backs up the checks in the provider so no
+// code path can mutate when read_only is set.
+type readOnlyTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *readOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return t.base.RoundTrip(req)
+	case http.MethodPost:
+		readOnly, err := isReadOnlyPost(req)
+		if err != nil {
+			return nil, err
+		}
+		if readOnly {
+			return t.base.RoundTrip(req)
+		}
+	}
+	path := req.URL.Opaque
+	if path == "" {
+		path = req.URL.Path
+	}
+	return nil, fmt.Errorf("%w: refusing %s %s://%s%s", ErrReadOnly, req.Method, req.URL.Scheme, req.URL.Host, path)
+}
+
+// isReadOnlyPost reports whether the POST req only reads: token requests,
+// searches, Cartel queries and GraphQL queries. The body of GraphQL requests
+// is inspected and restored.
+func isReadOnlyPost(req *http.Request) (bool, error) {
+	if isTokenRequest(req) {
+		return true, nil
+	}
+	path := req.URL.Opaque
+	if path == "" {
+		path = req.URL.Path
+	}
+	path = strings.TrimSuffix(path, "/")
+	if strings.HasSuffix(path, "/_search") {
+		return true, nil
+	}
+	for _, endpoint := range cartelReadEndpoints {
+		if strings.HasSuffix(path, "/v3/api/"+endpoint) {
+			return true, nil
+		}
+	}
+	if !strings.Contains(path, "graphql") || req.Body == nil {
+		return false, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return false, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	var gql struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(body, &gql); err != nil {
+		return false, nil
+	}
+	query := strings.TrimSpace(gql.Query)
+	return query != "" && !strings.HasPrefix(query, "mutation"), nil
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/philips-software/go-hsdp-api/iam"
+)
+
+// reauthTransport is an http.RoundTripper which renews the IAM access token
+// and replays a request once when it is rejected with a 401. This keeps long
+// runs going after their token or refresh token expired.
+type reauthTransport struct {
+	base http.RoundTripper
+
+	mu sync.Mutex
+	// renew returns a token different from the rejected one
+	renew func(rejected string) (string, error)
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *reauthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	auth := req.Header.Get("Authorization")
+	if t.renew == nil || isTokenRequest(req) || !(auth == "" || strings.HasPrefix(auth, "Bearer ")) {
+		return t.base.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	t.mu.Lock()
+	token, renewErr := t.renew(strings.TrimPrefix(auth, "Bearer "))
+	t.mu.Unlock()
+	if renewErr != nil || token == "" {
+		return resp, nil
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	retry.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		retry.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return t.base.RoundTrip(retry)
+}
+
+// isTokenRequest reports whether req obtains or revokes tokens itself
+func isTokenRequest(req *http.Request) bool {
+	path := req.URL.Opaque
+	if path == "" {
+		path = req.URL.Path
+	}
+	return strings.Contains(path, "oauth2/") || strings.HasSuffix(path, "oauth/token")
+}
+
+// renewToken returns a valid token of client other than rejected. It first
+// tries the refresh token and logs in again with the current credentials
+// when that fails.
+func (c *Config) renewToken(client *iam.Client, rejected string) (string, error) {
+	if token := client.Token(); token != "" && token != rejected {
+		return token, nil
+	}
+	if err := client.TokenRefresh(); err == nil {
+		if token := client.Token(); token != "" && token != rejected {
+			return token, nil
+		}
+	}
+	creds, err := c.credentials()
+	if err != nil {
+		return "", err
+	}
+	if err := login(client, creds); err != nil {
+		return "", err
+	}
+	return client.Token(), nil
+}
+
+// login logs client in with the service identity or org admin in creds
+func login(client *iam.Client, creds Credentials) error {
+	loggedIn := false
+	if creds.ServiceID != "" && creds.ServicePrivateKey != "" {
+		err := client.ServiceLogin(iam.Service{
+			ServiceID:  creds.ServiceID,
+			PrivateKey: creds.ServicePrivateKey,
+		})
+		if err != nil {
+			return fmt.Errorf("invalid IAM Service Identity credentials: %w", err)
+		}
+		loggedIn = true
+	}
+	if creds.OrgAdminUsername != "" && creds.OrgAdminPassword != "" {
+		if creds.OAuth2ClientID == "" {
+			return ErrMissingClientID
+		}
+		err := client.Login(creds.OrgAdminUsername, creds.OrgAdminPassword)
+		if err != nil {
+			return fmt.Errorf("invalid IAM Org Admin credentials: %w", err)
+		}
+		loggedIn = true
+	}
+	if !loggedIn {
+		return ErrMissingIAMCredentials
+	}
+	return nil
+}
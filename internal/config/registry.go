@@ -0,0 +1,57 @@
+package config
+
+import "sync"
+
+// clientKey identifies a cached client by service, region and environment
+type clientKey struct {
+	service     string
+	region      string
+	environment string
+}
+
+type clientEntry struct {
+	once   sync.Once
+	client interface{}
+	err    error
+}
+
+// clientRegistry caches clients for region and environment pairs other than
+// the provider defaults. Each client is created at most once, including
+// when creation fails, so repeated lookups do not trigger repeated logins.
+type clientRegistry struct {
+	mu      sync.Mutex
+	entries map[clientKey]*clientEntry
+}
+
+func (r *clientRegistry) get(key clientKey, create func() (interface{}, error)) (interface{}, error) {
+	r.mu.Lock()
+	if r.entries == nil {
+		r.entries = make(map[clientKey]*clientEntry)
+	}
+	entry, ok := r.entries[key]
+	if !ok {
+		entry = &clientEntry{}
+		r.entries[key] = entry
+	}
+	r.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.client, entry.err = create()
+	})
+	return entry.client, entry.err
+}
+
+// resolve returns the region and environment to use, falling back to the
+// provider defaults for missing or empty values. The last return value
+// reports whether the result differs from the provider defaults.
+func (c *Config) resolve(regionEnvironment ...string) (string, string, bool) {
+	region := c.Region
+	environment := c.Environment
+	if len(regionEnvironment) > 0 && regionEnvironment[0] != "" {
+		region = regionEnvironment[0]
+	}
+	if len(regionEnvironment) > 1 && regionEnvironment[1] != "" {
+		environment = regionEnvironment[1]
+	}
+	return region, environment, region != c.Region || environment != c.Environment
+}
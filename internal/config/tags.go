@@ -0,0 +1,81 @@
+package config
+
+import (
+	"sort"
+	"strings"
+)
+
+// MergeTags returns the provider default tags overridden by tags
+func (c *Config) MergeTags(tags map[string]string) map[string]string {
+	merged := make(map[string]string, len(c.DefaultTags)+len(tags))
+	for k, v := range c.DefaultTags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return merged
+}
+
+// ResourceTags returns the tags of all which do not come from the provider
+// default tags. Keys present in configured are always kept.
+func (c *Config) ResourceTags(all, configured map[string]string) map[string]string {
+	tags := make(map[string]string)
+	for k, v := range all {
+		if _, ok := configured[k]; !ok && c.DefaultTags[k] == v {
+			continue
+		}
+		tags[k] = v
+	}
+	return tags
+}
+
+// MergeLabels returns labels followed by the provider default tags rendered
+// as key=value. Default tags whose key labels already use are left out.
+func (c *Config) MergeLabels(labels []string) []string {
+	merged := append([]string{}, labels...)
+	used := make(map[string]bool, len(labels))
+	for _, l := range labels {
+		used[labelKey(l)] = true
+	}
+	for _, k := range c.defaultTagKeys() {
+		if !used[k] {
+			merged = append(merged, k+"="+c.DefaultTags[k])
+		}
+	}
+	return merged
+}
+
+// ResourceLabels returns the labels of all which do not come from the
+// provider default tags. Labels present in configured are always kept.
+func (c *Config) ResourceLabels(all, configured []string) []string {
+	defaults := make(map[string]bool, len(c.DefaultTags))
+	for k, v := range c.DefaultTags {
+		defaults[k+"="+v] = true
+	}
+	keep := make(map[string]bool, len(configured))
+	for _, l := range configured {
+		keep[l] = true
+	}
+	labels := make([]string, 0, len(all))
+	for _, l := range all {
+		if defaults[l] && !keep[l] {
+			continue
+		}
+		labels = append(labels, l)
+	}
+	return labels
+}
+
+func (c *Config) defaultTagKeys() []string {
+	keys := make([]string, 0, len(c.DefaultTags))
+	for k := range c.DefaultTags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func labelKey(label string) string {
+	return strings.SplitN(label, "=", 2)[0]
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultTags(t *testing.T) {
+	c := &Config{}
+	c.DefaultTags = map[string]string{"owner": "platform", "cost_center": "1234"}
+
+	all := c.MergeTags(map[string]string{"owner": "team", "app": "web"})
+	assert.Equal(t, map[string]string{"owner": "team", "cost_center": "1234", "app": "web"}, all)
+	assert.Equal(t, map[string]string{"owner": "team", "app": "web"}, c.ResourceTags(all, nil))
+	assert.Equal(t, map[string]string{"cost_center": "1234"},
+		c.ResourceTags(map[string]string{"cost_center": "1234"}, map[string]string{"cost_center": "1234"}),
+		"explicitly configured tags are kept even when equal to a default")
+
+	labels := c.MergeLabels([]string{"gpu", "owner=team"})
+	assert.Equal(t, []string{"gpu", "owner=team", "cost_center=1234"}, labels)
+	assert.Equal(t, []string{"gpu", "owner=team"}, c.ResourceLabels(labels, nil))
+
+	empty := &Config{}
+	assert.Equal(t, []string{"gpu"}, empty.MergeLabels([]string{"gpu"}))
+	assert.Equal(t, map[string]string{}, empty.MergeTags(nil))
+}
@@ -0,0 +1,286 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	TraceFormatJSON = "json"
+	TraceFormatText = "text"
+
+	// CorrelationIDHeader is added to requests which do not carry one yet
+	CorrelationIDHeader = "X-Correlation-ID"
+
+	redacted     = "[REDACTED]"
+	maxTraceBody = 64 * 1024
+)
+
+var (
+	sensitiveKeys = []string{
+		"password", "secret", "privatekey", "token", "passphrase", "apikey",
+		"authorization", "cookie", "signature", "credentials", "sharedkey",
+		"assertion", "clientassertion",
+	}
+	// sensitiveExactKeys only match whole keys, like the authorization
+	// code of an OAuth2 grant, and not keys such as country_code
+	sensitiveExactKeys = []string{"code"}
+	pemBlock           = regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`)
+)
+
+type resourceKey struct{}
+
+// WithResource returns a context which attributes HTTP exchanges to address
+func WithResource(ctx context.Context, address string) context.Context {
+	return context.WithValue(ctx, resourceKey{}, address)
+}
+
+// ResourceFromContext returns the resource address stored in ctx, if any
+func ResourceFromContext(ctx context.Context) string {
+	address, _ := ctx.Value(resourceKey{}).(string)
+	return address
+}
+
+// TraceEvent is a single entry in the trace file
+type TraceEvent struct {
+	Time            time.Time         `json:"time"`
+	Method          string            `json:"method,omitempty"`
+	URL             string            `json:"url,omitempty"`
+	Status          int               `json:"status,omitempty"`
+	LatencyMS       int64             `json:"latency_ms,omitempty"`
+	Resource        string            `json:"resource,omitempty"`
+	CorrelationID   string            `json:"correlation_id,omitempty"`
+	RequestHeaders  map[string]string `json:"request_headers,omitempty"`
+	RequestBody     interface{}       `json:"request_body,omitempty"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty"`
+	ResponseBody    interface{}       `json:"response_body,omitempty"`
+	Error           string            `json:"error,omitempty"`
+	Message         string            `json:"message,omitempty"`
+}
+
+// Tracer writes redacted trace events to a file
+type Tracer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+}
+
+// NewTracer opens path for appending and returns a Tracer writing events
+// in the given format
+func NewTracer(path, format string) (*Tracer, error) {
+	if format == "" {
+		format = TraceFormatJSON
+	}
+	if format != TraceFormatJSON && format != TraceFormatText {
+		return nil, fmt.Errorf("unsupported trace format '%s'", format)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening trace file: %w", err)
+	}
+	return &Tracer{w: f, format: format}, nil
+}
+
+// Record writes event to the trace file
+func (t *Tracer) Record(event TraceEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	var line []byte
+	if t.format == TraceFormatText {
+		line = []byte(event.text())
+	} else {
+		var err error
+		line, err = json.Marshal(event)
+		if err != nil {
+			return
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, _ = t.w.Write(append(line, '\n'))
+}
+
+func (e TraceEvent) text() string {
+	var b strings.Builder
+	b.WriteString(e.Time.Format(time.RFC3339Nano))
+	if e.Message != "" {
+		b.WriteString(" ")
+		b.WriteString(strings.TrimRight(e.Message, "\n"))
+	}
+	if e.Method != "" {
+		fmt.Fprintf(&b, " %s %s %d %dms", e.Method, e.URL, e.Status, e.LatencyMS)
+	}
+	if e.Resource != "" {
+		fmt.Fprintf(&b, " resource=%s", e.Resource)
+	}
+	if e.CorrelationID != "" {
+		fmt.Fprintf(&b, " correlation_id=%s", e.CorrelationID)
+	}
+	if e.Error != "" {
+		fmt.Fprintf(&b, " error=%q", e.Error)
+	}
+	return b.String()
+}
+
+// traceTransport records every exchange passing through it
+type traceTransport struct {
+	base   http.RoundTripper
+	tracer *Tracer
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	correlationID := r.Header.Get(CorrelationIDHeader)
+	if correlationID == "" {
+		correlationID = uuid.New().String()
+		r.Header.Set(CorrelationIDHeader, correlationID)
+	}
+	event := TraceEvent{
+		Method:         r.Method,
+		URL:            redactURL(r.URL),
+		Resource:       ResourceFromContext(r.Context()),
+		CorrelationID:  correlationID,
+		RequestHeaders: redactHeaders(r.Header),
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		event.RequestBody = redactBody(r.Header.Get("Content-Type"), body)
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(r)
+	event.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		event.Error = err.Error()
+		t.tracer.Record(event)
+		return resp, err
+	}
+	event.Status = resp.StatusCode
+	event.ResponseHeaders = redactHeaders(resp.Header)
+	if resp.Body != nil {
+		body, readErr := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		if readErr != nil {
+			event.Error = readErr.Error()
+		}
+		event.ResponseBody = redactBody(resp.Header.Get("Content-Type"), body)
+	}
+	t.tracer.Record(event)
+	return resp, nil
+}
+
+func isSensitive(key string) bool {
+	normalized := strings.ToLower(strings.NewReplacer("_", "", "-", "", ".", "").Replace(key))
+	for _, s := range sensitiveKeys {
+		if strings.Contains(normalized, s) {
+			return true
+		}
+	}
+	for _, s := range sensitiveExactKeys {
+		if normalized == s {
+			return true
+		}
+	}
+	return false
+}
+
+func redactHeaders(header http.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+	redactedHeaders := make(map[string]string, len(header))
+	for k, v := range header {
+		if isSensitive(k) {
+			redactedHeaders[k] = redacted
+			continue
+		}
+		redactedHeaders[k] = strings.Join(v, ", ")
+	}
+	return redactedHeaders
+}
+
+func redactURL(u *url.URL) string {
+	redactedURL := *u
+	redactedURL.User = nil
+	query := redactedURL.Query()
+	for k := range query {
+		if isSensitive(k) {
+			query.Set(k, redacted)
+		}
+	}
+	redactedURL.RawQuery = query.Encode()
+	return redactedURL.String()
+}
+
+// redactBody returns a representation of body which is safe to write
+// to the trace file. JSON and form bodies are kept with sensitive fields
+// redacted, other bodies are only described by their size.
+func redactBody(contentType string, body []byte) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+	if len(body) > maxTraceBody {
+		return fmt.Sprintf("[%d bytes]", len(body))
+	}
+	switch {
+	case strings.Contains(contentType, "json"):
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err == nil {
+			return redactValue(v)
+		}
+	case strings.Contains(contentType, "application/x-www-form-urlencoded"):
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			form := make(map[string]interface{}, len(values))
+			for k, v := range values {
+				if isSensitive(k) {
+					form[k] = redacted
+					continue
+				}
+				form[k] = strings.Join(v, ",")
+			}
+			return form
+		}
+	}
+	return fmt.Sprintf("[%d bytes]", len(body))
+}
+
+func redactValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, field := range value {
+			if isSensitive(k) {
+				value[k] = redacted
+				continue
+			}
+			value[k] = redactValue(field)
+		}
+		return value
+	case []interface{}:
+		for i := range value {
+			value[i] = redactValue(value[i])
+		}
+		return value
+	case string:
+		return pemBlock.ReplaceAllString(value, redacted)
+	}
+	return v
+}
@@ -0,0 +1,302 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryPolicy controls retries and rate limiting of API requests
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the initial attempt
+	MaxRetries int
+	// MinBackoff is the wait time before the first retry
+	MinBackoff time.Duration
+	// MaxBackoff caps the exponential backoff
+	MaxBackoff time.Duration
+	// RetryOn lists the HTTP status codes which are retried
+	RetryOn []int
+	// RequestsPerSecond limits requests per host, zero means unlimited
+	RequestsPerSecond float64
+	// Burst is the number of requests allowed to exceed RequestsPerSecond
+	Burst int
+}
+
+// DefaultRetryPolicy returns the policy used when no retry block is configured
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: 3,
+		MinBackoff: 1 * time.Second,
+		MaxBackoff: 30 * time.Second,
+		RetryOn:    []int{http.StatusTooManyRequests, http.StatusServiceUnavailable},
+	}
+}
+
+// RetryOverride holds the retry settings which were configured. Nil
+// fields keep the value of the policy they are merged into, so a configured
+// zero wins, e.g. MaxRetries 0 disables retries.
+type RetryOverride struct {
+	MaxRetries        *int
+	MinBackoff        *time.Duration
+	MaxBackoff        *time.Duration
+	RetryOn           []int
+	RequestsPerSecond *float64
+	Burst             *int
+}
+
+// Merge returns a copy of p with all values set in o applied
+func (p RetryPolicy) Merge(o RetryOverride) RetryPolicy {
+	if o.MaxRetries != nil {
+		p.MaxRetries = *o.MaxRetries
+	}
+	if o.MinBackoff != nil {
+		p.MinBackoff = *o.MinBackoff
+	}
+	if o.MaxBackoff != nil {
+		p.MaxBackoff = *o.MaxBackoff
+	}
+	if len(o.RetryOn) > 0 {
+		p.RetryOn = o.RetryOn
+	}
+	if o.RequestsPerSecond != nil {
+		p.RequestsPerSecond = *o.RequestsPerSecond
+	}
+	if o.Burst != nil {
+		p.Burst = *o.Burst
+	}
+	return p
+}
+
+func (p RetryPolicy) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		// Connection level errors are only safe to retry for idempotent requests
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+			return req.Context().Err() == nil
+		}
+		return false
+	}
+	for _, code := range p.RetryOn {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the time to wait before the next attempt. A Retry-After
+// header on a 429 or 503 response takes precedence over exponential backoff,
+// both are capped at MaxBackoff
+func (p RetryPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if wait, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			if wait > p.MaxBackoff {
+				return p.MaxBackoff
+			}
+			return wait
+		}
+	}
+	wait := float64(p.MinBackoff) * math.Pow(2, float64(attempt))
+	if wait > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(wait)
+}
+
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		wait := time.Until(at)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// HostPolicies holds the retry policy and rate limiter of each API host.
+// It is shared between all transports of a provider instance so that rate
+// limits apply across clients talking to the same host.
+type HostPolicies struct {
+	Default RetryPolicy
+
+	mu       sync.Mutex
+	policies map[string]RetryPolicy
+	buckets  map[string]*tokenBucket
+}
+
+// NewHostPolicies returns HostPolicies using p for unregistered hosts
+func NewHostPolicies(p RetryPolicy) *HostPolicies {
+	return &HostPolicies{
+		Default:  p,
+		policies: make(map[string]RetryPolicy),
+		buckets:  make(map[string]*tokenBucket),
+	}
+}
+
+// Register sets the policy of the host of rawURL
+func (h *HostPolicies) Register(rawURL string, p RetryPolicy) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.policies[u.Host] = p
+}
+
+func (h *HostPolicies) lookup(host string) (RetryPolicy, *tokenBucket) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p, ok := h.policies[host]
+	if !ok {
+		p = h.Default
+	}
+	if p.RequestsPerSecond <= 0 {
+		return p, nil
+	}
+	bucket, ok := h.buckets[host]
+	if !ok {
+		bucket = newTokenBucket(p.RequestsPerSecond, p.Burst)
+		h.buckets[host] = bucket
+	}
+	return p, bucket
+}
+
+// Transport is an http.RoundTripper which rate limits requests per host and
+// retries throttled or failed requests according to the host policy
+type Transport struct {
+	Base     http.RoundTripper
+	Policies *HostPolicies
+	// NoRetries sends every request once, for requests which are not
+	// idempotent. Rate limits still apply.
+	NoRetries bool
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	policy, bucket := t.Policies.lookup(req.URL.Host)
+	if t.NoRetries {
+		policy.MaxRetries = 0
+	}
+	ctx := req.Context()
+
+	// Buffer the body so it can be replayed on retries
+	getBody := req.GetBody
+	buffered := false
+	if req.Body != nil && req.Body != http.NoBody && getBody == nil && policy.MaxRetries > 0 {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		getBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		buffered = true
+	}
+
+	for attempt := 0; ; attempt++ {
+		if bucket != nil {
+			if err := bucket.wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		r := req
+		if attempt > 0 || buffered {
+			r = req.Clone(ctx)
+			if getBody != nil {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+				r.Body = body
+			}
+		}
+		resp, err := t.base().RoundTrip(r)
+		if attempt >= policy.MaxRetries || !policy.shouldRetry(r, resp, err) {
+			return resp, err
+		}
+		wait := policy.backoff(attempt, resp)
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// tokenBucket is a minimal token bucket rate limiter
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long the caller must wait for it
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) wait(ctx context.Context) error {
+	wait := b.reserve()
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransportRetries(t *testing.T) {
+	var calls int
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if calls < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	policy := DefaultRetryPolicy()
+	policy.MinBackoff = time.Millisecond
+	client := &http.Client{Transport: &Transport{Policies: NewHostPolicies(policy)}}
+
+	resp, err := client.Post(ts.URL, "application/json", strings.NewReader(`{"name":"foo"}`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []string{`{"name":"foo"}`, `{"name":"foo"}`, `{"name":"foo"}`}, bodies)
+}
+
+func TestTransportGivesUp(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	policies := NewHostPolicies(DefaultRetryPolicy())
+	policies.Register(ts.URL, RetryPolicy{
+		MaxRetries: 2,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		RetryOn:    []int{http.StatusServiceUnavailable},
+	})
+	client := &http.Client{Transport: &Transport{Policies: policies}}
+
+	resp, err := client.Get(ts.URL)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryAfter(t *testing.T) {
+	wait, ok := retryAfter("5")
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, wait)
+
+	_, ok = retryAfter("soon")
+	assert.False(t, ok)
+}
+
+func TestReadOnlyTransport(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	c := &Config{ReadOnly: true}
+	client := c.httpClient("test", nil)
+
+	allowed := []*http.Request{
+		httptest.NewRequest(http.MethodGet, ts.URL+"/authorize/identity/Group", nil),
+		httptest.NewRequest(http.MethodPost, ts.URL+"/authorize/oauth2/token", strings.NewReader("grant_type=password")),
+		httptest.NewRequest(http.MethodPost, ts.URL+"/v3/api/instance_details", strings.NewReader(`{}`)),
+		httptest.NewRequest(http.MethodPost, ts.URL+"/store/fhir/org/Patient/_search", nil),
+		httptest.NewRequest(http.MethodPost, ts.URL+"/graphql", strings.NewReader(`{"query":"query { namespaces { id } }"}`)),
+	}
+	for _, req := range allowed {
+		req.RequestURI = ""
+		resp, err := client.Do(req)
+		if assert.Nil(t, err, req.URL.Path) {
+			_ = resp.Body.Close()
+		}
+	}
+	assert.Equal(t, len(allowed), calls)
+
+	blocked := []*http.Request{
+		httptest.NewRequest(http.MethodPost, ts.URL+"/authorize/identity/Group", strings.NewReader(`{}`)),
+		httptest.NewRequest(http.MethodDelete, ts.URL+"/authorize/identity/Group/1", nil),
+		httptest.NewRequest(http.MethodPost, ts.URL+"/v3/api/destroy", strings.NewReader(`{}`)),
+		httptest.NewRequest(http.MethodPost, ts.URL+"/graphql", strings.NewReader(`{"query":"mutation { deleteNamespace }"}`)),
+	}
+	for _, req := range blocked {
+		req.RequestURI = ""
+		_, err := client.Do(req)
+		assert.ErrorIs(t, err, ErrReadOnly, req.URL.Path)
+	}
+	assert.Equal(t, len(allowed), calls)
+}
+
+func TestRetryPolicyMerge(t *testing.T) {
+	zero := 0
+	rate := 5.0
+	policy := DefaultRetryPolicy().Merge(RetryOverride{
+		MaxRetries:        &zero,
+		RequestsPerSecond: &rate,
+	})
+	assert.Equal(t, 0, policy.MaxRetries, "an explicit zero disables retries")
+	assert.Equal(t, 5.0, policy.RequestsPerSecond)
+	assert.Equal(t, 30*time.Second, policy.MaxBackoff, "unset values are kept")
+
+	unlimited := 0.0
+	policy = policy.Merge(RetryOverride{RequestsPerSecond: &unlimited})
+	assert.Equal(t, 0.0, policy.RequestsPerSecond)
+	assert.Equal(t, 0, policy.MaxRetries)
+}
+
+func TestBackoffCapsRetryAfter(t *testing.T) {
+	policy := DefaultRetryPolicy()
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	resp.Header.Set("Retry-After", "3600")
+	assert.Equal(t, policy.MaxBackoff, policy.backoff(0, resp))
+
+	resp.Header.Set("Retry-After", "2")
+	assert.Equal(t, 2*time.Second, policy.backoff(0, resp))
+}
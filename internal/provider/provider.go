@@ -2,11 +2,14 @@ package provider
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/google/fhir/go/jsonformat"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/philips-software/terraform-provider-hsdp/internal/config"
 	"github.com/philips-software/terraform-provider-hsdp/internal/services/ai/inference"
 	"github.com/philips-software/terraform-provider-hsdp/internal/services/ai/workspace"
@@ -47,25 +50,39 @@ const (
 	SecretKey        = "HSDP_SECRET_KEY"
 	UAAUsername      = "HSDP_UAA_USERNAME"
 	UAAPassword      = "HSDP_UAA_PASSWORD"
+	TraceFile        = "HSDP_TRACE_FILE"
+	SimulatorURL     = "HSDP_SIMULATOR_URL"
+	CredentialsFile  = "HSDP_CREDENTIALS_FILE"
+	CredentialsDir   = "HSDP_CREDENTIALS_DIR"
+	CredentialProc   = "HSDP_CREDENTIAL_PROCESS"
+	Profile          = "HSDP_PROFILE"
+	ReadOnly         = "HSDP_READ_ONLY"
+	DiscoveryFile    = "HSDP_SERVICE_DISCOVERY_FILE"
+	KnownHostsFile   = "HSDP_KNOWN_HOSTS_FILE"
+	StrictHostKey    = "HSDP_STRICT_HOST_KEY_CHECKING"
 )
 
 // Provider returns an instance of the HSDP provider
 func Provider(build string) *schema.Provider {
-	return &schema.Provider{
+	p := &schema.Provider{
 		Schema: map[string]*schema.Schema{
 			"region": {
-				Type:         schema.TypeString,
-				Optional:     true,
-				DefaultFunc:  schema.EnvDefaultFunc(Region, "us-east"),
-				Description:  descriptions["region"],
-				ValidateFunc: tools.ValidateRegion,
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(Region, nil),
+				Description: descriptions["region"],
 			},
 			"environment": {
-				Type:         schema.TypeString,
-				Optional:     true,
-				DefaultFunc:  schema.EnvDefaultFunc(Environment, "client-test"),
-				Description:  descriptions["environment"],
-				ValidateFunc: tools.ValidateEnvironment,
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(Environment, nil),
+				Description: descriptions["environment"],
+			},
+			"profile": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(Profile, nil),
+				Description: descriptions["profile"],
 			},
 			"iam_url": {
 				Type:        schema.TypeString,
@@ -92,6 +109,37 @@ func Provider(build string) *schema.Provider {
 				Optional:    true,
 				Description: descriptions["mdm_url"],
 			},
+			"simulator_url": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc(SimulatorURL, nil),
+				Description:  descriptions["simulator_url"],
+				ValidateFunc: validation.IsURLWithHTTPorHTTPS,
+			},
+			"service_discovery_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(DiscoveryFile, nil),
+				Description: descriptions["service_discovery_file"],
+			},
+			"credentials_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(CredentialsFile, nil),
+				Description: descriptions["credentials_file"],
+			},
+			"credentials_dir": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(CredentialsDir, nil),
+				Description: descriptions["credentials_dir"],
+			},
+			"credential_process": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(CredentialProc, nil),
+				Description: descriptions["credential_process"],
+			},
 			"service_id": {
 				Type:          schema.TypeString,
 				Optional:      true,
@@ -210,10 +258,69 @@ func Provider(build string) *schema.Provider {
 				Default:     0,
 				Description: descriptions["retry_max"],
 			},
+			"retry": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: descriptions["retry"],
+				Elem: &schema.Resource{
+					Schema: retrySchema(true),
+				},
+			},
+			"known_hosts_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(KnownHostsFile, nil),
+				Description: descriptions["known_hosts_file"],
+			},
+			"strict_host_key_checking": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(StrictHostKey, config.HostKeyCheckingAcceptNew),
+				Description: descriptions["strict_host_key_checking"],
+				ValidateFunc: validation.StringInSlice([]string{
+					config.HostKeyCheckingYes, config.HostKeyCheckingAcceptNew, config.HostKeyCheckingNo,
+				}, false),
+			},
+			"read_only": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(ReadOnly, false),
+				Description: descriptions["read_only"],
+			},
+			"default_tags": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: descriptions["default_tags"],
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"tags": {
+							Type:     schema.TypeMap,
+							Optional: true,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+						},
+					},
+				},
+			},
 			"debug_log": {
 				Type:        schema.TypeString,
 				Optional:    true,
 				Description: descriptions["debug_log"],
+				Deprecated:  "debug_log output may contain credentials, use trace_file instead",
+			},
+			"trace_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(TraceFile, nil),
+				Description: descriptions["trace_file"],
+			},
+			"trace_format": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      config.TraceFormatJSON,
+				Description:  descriptions["trace_format"],
+				ValidateFunc: validation.StringInSlice([]string{config.TraceFormatJSON, config.TraceFormatText}, false),
 			},
 			"ai_inference_endpoint": {
 				Type:     schema.TypeString,
@@ -235,6 +342,7 @@ func Provider(build string) *schema.Provider {
 			"hsdp_s3creds_policy":                            s3creds.ResourceS3CredsPolicy(),
 			"hsdp_container_host":                            ch.ResourceContainerHost(),
 			"hsdp_container_host_exec":                       ch.ResourceContainerHostExec(),
+			"hsdp_container_host_group":                      ch.ResourceContainerHostGroup(),
 			"hsdp_metrics_autoscaler":                        metrics.ResourceMetricsAutoscaler(),
 			"hsdp_cdr_org":                                   org.ResourceCDROrg(),
 			"hsdp_cdr_subscription":                          subscription.ResourceCDRSubscription(),
@@ -248,6 +356,7 @@ func Provider(build string) *schema.Provider {
 			"hsdp_edge_custom_cert":                          edge.ResourceEdgeCustomCert(),
 			"hsdp_edge_sync":                                 edge.ResourceEdgeSync(),
 			"hsdp_function":                                  function.ResourceFunction(),
+			"hsdp_function_invocation":                       function.ResourceFunctionInvocation(),
 			"hsdp_notification_producer":                     notification.ResourceNotificationProducer(),
 			"hsdp_notification_subscriber":                   notification.ResourceNotificationSubscriber(),
 			"hsdp_notification_topic":                        notification.ResourceNotificationTopic(),
@@ -319,6 +428,8 @@ func Provider(build string) *schema.Provider {
 			"hsdp_cdl_research_study":                cdl.DataSourceCDLResearchStudy(),
 			"hsdp_cdl_research_studies":              cdl.DataSourceCDLResearchStudies(),
 			"hsdp_container_host_instances":          ch.DataSourceContainerHostInstances(),
+			"hsdp_container_host_security_groups":    ch.DataSourceContainerHostSecurityGroups(),
+			"hsdp_function_tasks":                    function.DataSourceFunctionTasks(),
 			"hsdp_cdl_data_type_definitions":         cdl.DataSourceCDLDataTypeDefinitions(),
 			"hsdp_cdl_data_type_definition":          cdl.DataSourceCDLDataTypeDefinition(),
 			"hsdp_cdl_label_definition":              cdl.DataSourceCDLLabelDefinition(),
@@ -349,37 +460,57 @@ func Provider(build string) *schema.Provider {
 		},
 		ConfigureContextFunc: providerConfigure(build),
 	}
+	for name, r := range p.ResourcesMap {
+		wrapResource(name, r)
+	}
+	for name, r := range p.DataSourcesMap {
+		wrapResource(name, r)
+	}
+	return p
 }
 
 var descriptions map[string]string
 
 func init() {
 	descriptions = map[string]string{
-		"region":              "The HSDP region to configure for",
-		"environment":         "The HSDP environment to configure for",
-		"iam_url":             "The HSDP IAM instance URL",
-		"idm_url":             "The HSDP IDM instance URL",
-		"s3creds_url":         "The HSDP S3 Credentials instance URL",
-		"notification_url":    "The HSDP Notification service base URL to use",
-		"mdm_url":             "The Connect MDM URL to use",
-		"oauth2_client_id":    "The OAuth2 client id",
-		"oauth2_password":     "The OAuth2 password",
-		"service_id":          "The service ID to use as Organization Admin",
-		"service_private_key": "The private key of the service ID",
-		"org_admin_username":  "The username of the Organization Admin",
-		"org_admin_password":  "The password of the Organization Admin",
-		"shared_key":          "The shared key",
-		"secret_key":          "The secret key",
-		"debug_log":           "The log file to write debugging output to",
-		"cartel_host":         "The Cartel host",
-		"cartel_token":        "The Cartel token key",
-		"cartel_secret":       "The Cartel secret key",
-		"cartel_no_tls":       "Disable TLS for Cartel",
-		"cartel_skip_verify":  "Skip certificate verification",
-		"retry_max":           "Maximum number of retries for API requests",
-		"uaa_username":        "The username of the Cloudfoundry account to use",
-		"uaa_password":        "The password of the Cloudfoundry account to use",
-		"uaa_url":             "The URL of the UAA server",
+		"region":                   "The HSDP region to configure for",
+		"environment":              "The HSDP environment to configure for",
+		"profile":                  "The profile in the shared config file to read settings from",
+		"iam_url":                  "The HSDP IAM instance URL",
+		"idm_url":                  "The HSDP IDM instance URL",
+		"s3creds_url":              "The HSDP S3 Credentials instance URL",
+		"notification_url":         "The HSDP Notification service base URL to use",
+		"mdm_url":                  "The Connect MDM URL to use",
+		"simulator_url":            "Send all API requests to the HSDP simulator at this URL, for offline testing",
+		"service_discovery_file":   "JSON or YAML file adding or overriding regions, environments and service endpoints",
+		"credentials_file":         "JSON file with credentials not set in the provider block",
+		"credentials_dir":          "Directory with one file per credential, e.g. written by a Vault agent",
+		"credential_process":       "Command which prints credentials not set in the provider block as JSON",
+		"oauth2_client_id":         "The OAuth2 client id",
+		"oauth2_password":          "The OAuth2 password",
+		"service_id":               "The service ID to use as Organization Admin",
+		"service_private_key":      "The private key of the service ID",
+		"org_admin_username":       "The username of the Organization Admin",
+		"org_admin_password":       "The password of the Organization Admin",
+		"shared_key":               "The shared key",
+		"secret_key":               "The secret key",
+		"debug_log":                "The log file to write debugging output to",
+		"trace_file":               "The file to write redacted HTTP request traces to",
+		"trace_format":             "The format of trace entries, either json or text",
+		"cartel_host":              "The Cartel host",
+		"cartel_token":             "The Cartel token key",
+		"cartel_secret":            "The Cartel secret key",
+		"cartel_no_tls":            "Disable TLS for Cartel",
+		"cartel_skip_verify":       "Skip certificate verification",
+		"retry_max":                "Maximum number of retries for API requests",
+		"retry":                    "Retry and rate limit settings for API requests",
+		"uaa_username":             "The username of the Cloudfoundry account to use",
+		"uaa_password":             "The password of the Cloudfoundry account to use",
+		"uaa_url":                  "The URL of the UAA server",
+		"default_tags":             "Tags which are added to all resources supporting tags or labels",
+		"read_only":                "Refuse all operations which could change resources, for safe plans and drift detection",
+		"known_hosts_file":         "The known_hosts file to verify the SSH host keys of bastion hosts against",
+		"strict_host_key_checking": "How unknown bastion host keys are handled: yes, accept-new or no",
 	}
 }
 
@@ -410,6 +541,9 @@ func providerConfigure(build string) schema.ConfigureContextFunc {
 		c.CartelNoTLS = d.Get("cartel_no_tls").(bool)
 		c.CartelSkipVerify = d.Get("cartel_skip_verify").(bool)
 		c.RetryMax = d.Get("retry_max").(int)
+		if err := expandRetry(d, c); err != nil {
+			return nil, diag.FromErr(err)
+		}
 		c.UAAUsername = d.Get("uaa_username").(string)
 		c.UAAPassword = d.Get("uaa_password").(string)
 		c.UAAURL = d.Get("uaa_url").(string)
@@ -417,15 +551,65 @@ func providerConfigure(build string) schema.ConfigureContextFunc {
 		c.TimeZone = "UTC"
 		c.AIInferenceEndpoint = d.Get("ai_inference_endpoint").(string)
 		c.MDMURL = d.Get("mdm_url").(string)
+		c.SimulatorURL = d.Get("simulator_url").(string)
+		c.ReadOnly = d.Get("read_only").(bool)
+		c.KnownHostsFile = d.Get("known_hosts_file").(string)
+		c.StrictHostKeyChecking = d.Get("strict_host_key_checking").(string)
+		discovery, err := config.LoadDiscovery(d.Get("service_discovery_file").(string))
+		if err != nil {
+			return nil, diag.FromErr(err)
+		}
+		c.Discovery = discovery
+		if list := d.Get("default_tags").([]interface{}); len(list) > 0 && list[0] != nil {
+			defaultTags := list[0].(map[string]interface{})["tags"].(map[string]interface{})
+			c.DefaultTags = tools.ExpandStringMap(defaultTags)
+		}
 
-		c.SetupIAMClient()
-		c.SetupS3CredsClient()
-		c.SetupCartelClient()
-		c.SetupConsoleClient()
-		c.SetupPKIClient()
-		c.SetupSTLClient()
-		c.SetupNotificationClient()
-		c.SetupMDMClient()
+		sources := config.CredentialSources{
+			File:    d.Get("credentials_file").(string),
+			Dir:     d.Get("credentials_dir").(string),
+			Process: d.Get("credential_process").(string),
+		}
+		// Explicit arguments and environment variables win over the profile
+		if name := d.Get("profile").(string); name != "" {
+			profile, err := config.LoadProfile(config.SharedConfigFile(), name)
+			if err != nil {
+				return nil, diag.FromErr(err)
+			}
+			if err := validateProfile(profile, c.Discovery); err != nil {
+				return nil, diag.FromErr(err)
+			}
+			c.ApplyProfile(profile)
+			sources = sources.Merge(profile.Credentials)
+		}
+		if c.Region == "" {
+			c.Region = "us-east"
+		}
+		if c.Environment == "" {
+			c.Environment = "client-test"
+		}
+		warns, errs := c.Discovery.ValidateRegion(c.Region)
+		for _, warn := range warns {
+			diags = append(diags, diag.Diagnostic{Severity: diag.Warning, Summary: warn})
+		}
+		if _, es := c.Discovery.ValidateEnvironment(c.Region, c.Environment); len(es) > 0 {
+			errs = append(errs, es...)
+		}
+		if len(errs) > 0 {
+			return nil, diag.FromErr(errs[0])
+		}
+		err = c.LoadCredentials(sources)
+		if err != nil {
+			return nil, diag.FromErr(err)
+		}
+
+		if traceFile := d.Get("trace_file").(string); traceFile != "" {
+			tracer, err := config.NewTracer(traceFile, d.Get("trace_format").(string))
+			if err != nil {
+				return nil, diag.FromErr(err)
+			}
+			c.Tracer = tracer
+		}
 
 		if c.DebugLog != "" {
 			debugFile, err := os.OpenFile(c.DebugLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
@@ -463,3 +647,195 @@ func providerConfigure(build string) schema.ConfigureContextFunc {
 		return c, diags
 	}
 }
+
+// validateProfile applies the provider validations to the settings of profile
+func validateProfile(profile *config.Profile, discovery *config.Discovery) error {
+	var errs []error
+	if profile.Region != "" {
+		_, es := discovery.ValidateRegion(profile.Region)
+		errs = append(errs, es...)
+	}
+	if profile.Environment != "" {
+		_, es := discovery.ValidateEnvironment(profile.Region, profile.Environment)
+		errs = append(errs, es...)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("profile '%s': %w", profile.Name, errs[0])
+	}
+	return nil
+}
+
+// retryServices lists the services which support retry overrides
+var retryServices = []string{
+	"iam", "cartel", "console", "s3creds", "notification", "mdm",
+	"cdr", "cdl", "dicom", "ai", "function",
+}
+
+func retrySchema(withServices bool) map[string]*schema.Schema {
+	s := map[string]*schema.Schema{
+		"max_retries": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			ValidateFunc: validation.IntAtLeast(0),
+		},
+		"min_backoff": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			ValidateFunc: validateDuration,
+		},
+		"max_backoff": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			ValidateFunc: validateDuration,
+		},
+		"retry_on": {
+			Type:     schema.TypeList,
+			Optional: true,
+			Elem: &schema.Schema{
+				Type:         schema.TypeInt,
+				ValidateFunc: validation.IntBetween(400, 599),
+			},
+		},
+		"requests_per_second": {
+			Type:         schema.TypeFloat,
+			Optional:     true,
+			ValidateFunc: validation.FloatAtLeast(0),
+		},
+		"burst": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			ValidateFunc: validation.IntAtLeast(0),
+		},
+	}
+	if withServices {
+		s["service"] = &schema.Schema{
+			Type:     schema.TypeList,
+			Optional: true,
+			Elem: &schema.Resource{
+				Schema: func() map[string]*schema.Schema {
+					service := retrySchema(false)
+					service["name"] = &schema.Schema{
+						Type:         schema.TypeString,
+						Required:     true,
+						ValidateFunc: validation.StringInSlice(retryServices, false),
+					}
+					return service
+				}(),
+			},
+		}
+	}
+	return s
+}
+
+func validateDuration(i interface{}, k string) (warns []string, es []error) {
+	if _, err := time.ParseDuration(i.(string)); err != nil {
+		es = append(es, fmt.Errorf("%q: invalid duration: %w", k, err))
+	}
+	return
+}
+
+// expandRetryPolicy returns the retry settings of the block at prefix.
+// Only arguments present in the configuration are set, so an explicit zero
+// overrides the defaults.
+func expandRetryPolicy(d *schema.ResourceData, prefix string) config.RetryOverride {
+	var p config.RetryOverride
+
+	if v, ok := d.GetOkExists(prefix + "max_retries"); ok { //nolint:staticcheck
+		maxRetries := v.(int)
+		p.MaxRetries = &maxRetries
+	}
+	if v, ok := d.GetOk(prefix + "min_backoff"); ok {
+		minBackoff, _ := time.ParseDuration(v.(string))
+		p.MinBackoff = &minBackoff
+	}
+	if v, ok := d.GetOk(prefix + "max_backoff"); ok {
+		maxBackoff, _ := time.ParseDuration(v.(string))
+		p.MaxBackoff = &maxBackoff
+	}
+	for _, code := range d.Get(prefix + "retry_on").([]interface{}) {
+		p.RetryOn = append(p.RetryOn, code.(int))
+	}
+	if v, ok := d.GetOkExists(prefix + "requests_per_second"); ok { //nolint:staticcheck
+		requestsPerSecond := v.(float64)
+		p.RequestsPerSecond = &requestsPerSecond
+	}
+	if v, ok := d.GetOkExists(prefix + "burst"); ok { //nolint:staticcheck
+		burst := v.(int)
+		p.Burst = &burst
+	}
+	return p
+}
+
+func expandRetry(d *schema.ResourceData, c *config.Config) error {
+	list := d.Get("retry").([]interface{})
+	if len(list) == 0 || list[0] == nil {
+		return nil
+	}
+	retry := list[0].(map[string]interface{})
+	c.Retry = expandRetryPolicy(d, "retry.0.")
+	c.RetryServices = make(map[string]config.RetryOverride)
+	for i, s := range retry["service"].([]interface{}) {
+		service := s.(map[string]interface{})
+		name := service["name"].(string)
+		if _, ok := c.RetryServices[name]; ok {
+			return fmt.Errorf("duplicate retry block for service '%s'", name)
+		}
+		c.RetryServices[name] = expandRetryPolicy(d, fmt.Sprintf("retry.0.service.%d.", i))
+	}
+	return nil
+}
+
+// wrapResource passes the resource address to the CRUD functions of r on
+// their context, so the requests made with it are attributed in traces.
+// Create, Update and Delete fail when the provider is in read_only mode.
+func wrapResource(name string, r *schema.Resource) {
+	wrap := func(operation string, fn func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics) func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics {
+		if fn == nil {
+			return nil
+		}
+		return func(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+			address := name
+			if id := d.Id(); id != "" {
+				address = fmt.Sprintf("%s[%s]", name, id)
+			}
+			if operation != "read" {
+				if diags := checkReadOnly(operation, address, m); diags != nil {
+					return diags
+				}
+			}
+			return fn(config.WithResource(ctx, address), d, m)
+		}
+	}
+	wrapLegacy := func(operation string, fn func(*schema.ResourceData, interface{}) error) func(*schema.ResourceData, interface{}) error {
+		if fn == nil {
+			return nil
+		}
+		return func(d *schema.ResourceData, m interface{}) error {
+			if c, ok := m.(*config.Config); ok && c.ReadOnly && operation != "read" {
+				return fmt.Errorf("%w: %s of %s is not allowed", config.ErrReadOnly, operation, name)
+			}
+			return fn(d, m)
+		}
+	}
+	r.CreateContext = wrap("create", r.CreateContext)
+	r.ReadContext = wrap("read", r.ReadContext)
+	r.UpdateContext = wrap("update", r.UpdateContext)
+	r.DeleteContext = wrap("delete", r.DeleteContext)
+	r.Create = wrapLegacy("create", r.Create) //nolint:staticcheck
+	r.Read = wrapLegacy("read", r.Read)       //nolint:staticcheck
+	r.Update = wrapLegacy("update", r.Update) //nolint:staticcheck
+	r.Delete = wrapLegacy("delete", r.Delete) //nolint:staticcheck
+}
+
+// checkReadOnly returns an error diagnostic when the provider is in read_only mode
+func checkReadOnly(operation, address string, m interface{}) diag.Diagnostics {
+	c, ok := m.(*config.Config)
+	if !ok || !c.ReadOnly {
+		return nil
+	}
+	return diag.Diagnostics{{
+		Severity: diag.Error,
+		Summary:  config.ErrReadOnly.Error(),
+		Detail:   fmt.Sprintf("%s of %s is not allowed while read_only is set in the provider block", operation, address),
+	}}
+}
@@ -1,9 +1,22 @@
 package provider
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+	"github.com/philips-software/terraform-provider-hsdp/internal/simulator"
+	"github.com/stretchr/testify/assert"
 )
 
 var testAccProviders map[string]*schema.Provider
@@ -25,3 +38,152 @@ func TestProvider(t *testing.T) {
 func TestProvider_impl(t *testing.T) {
 	var _ *schema.Provider = Provider("v0.0.0")
 }
+
+func TestReadOnly(t *testing.T) {
+	p := Provider("v0.0.0")
+	c := &config.Config{ReadOnly: true}
+
+	group := p.ResourcesMap["hsdp_iam_group"]
+	d := group.TestResourceData()
+	diags := group.CreateContext(context.Background(), d, c)
+	if assert.True(t, diags.HasError()) {
+		assert.Equal(t, config.ErrReadOnly.Error(), diags[0].Summary)
+	}
+
+	exec := p.ResourcesMap["hsdp_container_host_exec"]
+	d = exec.TestResourceData()
+	diags = exec.CreateContext(context.Background(), d, c)
+	assert.True(t, diags.HasError())
+	diags = exec.DeleteContext(context.Background(), d, c)
+	if assert.True(t, diags.HasError()) {
+		assert.Equal(t, config.ErrReadOnly.Error(), diags[0].Summary)
+	}
+}
+
+func TestTraceAttribution(t *testing.T) {
+	sim := simulator.New()
+	defer sim.Close()
+	traceFile := filepath.Join(t.TempDir(), "trace.jsonl")
+	tracer, err := config.NewTracer(traceFile, config.TraceFormatJSON)
+	if !assert.Nil(t, err) {
+		return
+	}
+	c := &config.Config{Tracer: tracer}
+	c.Region = "us-east"
+	c.Environment = "client-test"
+	c.OrgAdminUsername = "admin"
+	c.OrgAdminPassword = "password"
+	c.OAuth2ClientID = "client"
+	c.OAuth2Secret = "secret"
+	c.SimulatorURL = sim.URL()
+
+	group := Provider("v0.0.0").ResourcesMap["hsdp_iam_group"]
+	newGroup := func(name string) *schema.ResourceData {
+		return schema.TestResourceDataRaw(t, group.Schema, map[string]interface{}{
+			"name":                  name,
+			"managing_organization": simulator.RootOrganizationID,
+		})
+	}
+	existing := newGroup("existing")
+	if diags := group.CreateContext(context.Background(), existing, c); !assert.False(t, diags.HasError(), diags) {
+		return
+	}
+	info, err := os.Stat(traceFile)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	// A resource which passes its context on to the requests it makes
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	call := &schema.Resource{
+		Schema: map[string]*schema.Schema{},
+		CreateContext: func(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/call", nil)
+			resp, err := m.(*config.Config).FunctionHTTPClient(ts.URL).Do(req)
+			if err != nil {
+				return diag.FromErr(err)
+			}
+			_ = resp.Body.Close()
+			d.SetId("call")
+			return nil
+		},
+	}
+	wrapResource("hsdp_test_call", call)
+
+	// Operations on other resources run meanwhile
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		assert.False(t, group.ReadContext(context.Background(), existing, c).HasError())
+	}()
+	go func() {
+		defer wg.Done()
+		assert.False(t, group.CreateContext(context.Background(), newGroup("created"), c).HasError())
+	}()
+	go func() {
+		defer wg.Done()
+		d := schema.TestResourceDataRaw(t, call.Schema, map[string]interface{}{})
+		assert.False(t, call.CreateContext(context.Background(), d, c).HasError())
+	}()
+	wg.Wait()
+
+	f, err := os.Open(traceFile)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer f.Close()
+	if _, err := f.Seek(info.Size(), 0); !assert.Nil(t, err) {
+		return
+	}
+	calls, reads, creates := 0, 0, 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event config.TraceEvent
+		if !assert.Nil(t, json.Unmarshal(scanner.Bytes(), &event)) {
+			return
+		}
+		switch {
+		case strings.HasPrefix(event.URL, ts.URL):
+			calls++
+			assert.Equal(t, "hsdp_test_call", event.Resource)
+		case strings.Contains(event.URL, existing.Id()):
+			reads++
+		case event.Method == "POST" && strings.Contains(event.URL, "/Group"):
+			creates++
+		}
+		// The IAM client does not pass the context on, its requests are
+		// traced without a resource rather than with the wrong one
+		if !strings.HasPrefix(event.URL, ts.URL) {
+			assert.Empty(t, event.Resource, "%s %s", event.Method, event.URL)
+		}
+	}
+	assert.Equal(t, 1, calls)
+	assert.Greater(t, reads, 0)
+	assert.Equal(t, 1, creates)
+}
+
+func TestExpandRetry(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provider("v0.0.0").Schema, map[string]interface{}{
+		"retry": []interface{}{map[string]interface{}{
+			"max_retries":         5,
+			"requests_per_second": 20.0,
+			"service": []interface{}{
+				map[string]interface{}{"name": "function", "max_retries": 0},
+				map[string]interface{}{"name": "iam", "requests_per_second": 5.0},
+			},
+		}},
+	})
+	c := &config.Config{}
+	if !assert.Nil(t, expandRetry(d, c)) {
+		return
+	}
+	assert.Equal(t, 5, c.RetryPolicy("cartel").MaxRetries)
+	assert.Equal(t, 0, c.RetryPolicy("function").MaxRetries, "an explicit zero disables retries")
+	assert.Equal(t, 20.0, c.RetryPolicy("function").RequestsPerSecond)
+	assert.Equal(t, 5.0, c.RetryPolicy("iam").RequestsPerSecond)
+	assert.Equal(t, 5, c.RetryPolicy("iam").MaxRetries)
+}
@@ -0,0 +1,327 @@
+package ch
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/philips-software/terraform-provider-hsdp/internal/tools"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	commandField        = "command"
+	commandResultsField = "command_results"
+
+	onFailureFail     = "fail"
+	onFailureContinue = "continue"
+
+	defaultCommandTimeout = 5 * time.Minute
+)
+
+// remoteCommand is a command to run on a host, either from a command block
+// or from the commands list
+type remoteCommand struct {
+	Inline      string
+	Timeout     time.Duration
+	Environment map[string]string
+	Sudo        bool
+	Retries     int
+	WorkingDir  string
+	OnFailure   string
+}
+
+// commandResult is the outcome of running a remoteCommand
+type commandResult struct {
+	Inline   string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+}
+
+// streamer runs a command on a remote host, see easyssh.MakeConfig
+type streamer interface {
+	Stream(command string, timeout ...time.Duration) (<-chan string, <-chan string, <-chan bool, <-chan error, error)
+}
+
+func commandSchema(forceNew bool) *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		ForceNew: forceNew,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"inline": {
+					Type:     schema.TypeString,
+					Required: true,
+					ForceNew: forceNew,
+				},
+				"timeout": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					ForceNew:     forceNew,
+					Default:      defaultCommandTimeout.String(),
+					ValidateFunc: validateDuration,
+				},
+				"environment": {
+					Type:     schema.TypeMap,
+					Optional: true,
+					ForceNew: forceNew,
+					Elem:     &schema.Schema{Type: schema.TypeString},
+				},
+				"sudo": {
+					Type:     schema.TypeBool,
+					Optional: true,
+					ForceNew: forceNew,
+					Default:  false,
+				},
+				"retries": {
+					Type:         schema.TypeInt,
+					Optional:     true,
+					ForceNew:     forceNew,
+					Default:      0,
+					ValidateFunc: validation.IntBetween(0, 10),
+				},
+				"working_dir": {
+					Type:     schema.TypeString,
+					Optional: true,
+					ForceNew: forceNew,
+				},
+				"on_failure": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					ForceNew:     forceNew,
+					Default:      onFailureFail,
+					ValidateFunc: validation.StringInSlice([]string{onFailureFail, onFailureContinue}, false),
+				},
+			},
+		},
+	}
+}
+
+func commandResultsSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Computed: true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"inline": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"stdout": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"stderr": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"exit_code": {
+					Type:     schema.TypeInt,
+					Computed: true,
+				},
+				"duration": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+			},
+		},
+	}
+}
+
+func validateDuration(v interface{}, k string) (warns []string, errs []error) {
+	if _, err := time.ParseDuration(v.(string)); err != nil {
+		errs = append(errs, fmt.Errorf("%q: %w", k, err))
+	}
+	return
+}
+
+// collectCommands returns the entries of the commands list followed by the
+// command blocks
+func collectCommands(d *schema.ResourceData) ([]remoteCommand, diag.Diagnostics) {
+	var commands []remoteCommand
+	list, diags := tools.CollectList(commandsField, d)
+	for _, inline := range list {
+		commands = append(commands, remoteCommand{
+			Inline:    inline,
+			Timeout:   defaultCommandTimeout,
+			OnFailure: onFailureFail,
+		})
+	}
+	for _, raw := range d.Get(commandField).([]interface{}) {
+		block := raw.(map[string]interface{})
+		timeout, err := time.ParseDuration(block["timeout"].(string))
+		if err != nil {
+			diags = append(diags, diag.FromErr(fmt.Errorf("command '%s': %w", block["inline"], err))...)
+			continue
+		}
+		env := make(map[string]string)
+		for k, v := range block["environment"].(map[string]interface{}) {
+			env[k] = v.(string)
+		}
+		commands = append(commands, remoteCommand{
+			Inline:      block["inline"].(string),
+			Timeout:     timeout,
+			Environment: env,
+			Sudo:        block["sudo"].(bool),
+			Retries:     block["retries"].(int),
+			WorkingDir:  block["working_dir"].(string),
+			OnFailure:   block["on_failure"].(string),
+		})
+	}
+	return commands, diags
+}
+
+// script returns the shell command line running rc with its environment,
+// working directory and sudo settings applied
+func (rc remoteCommand) script() string {
+	if len(rc.Environment) == 0 && rc.WorkingDir == "" && !rc.Sudo {
+		return rc.Inline
+	}
+	var b strings.Builder
+	keys := make([]string, 0, len(rc.Environment))
+	for k := range rc.Environment {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "export %s=%s; ", k, shellQuote(rc.Environment[k]))
+	}
+	if rc.WorkingDir != "" {
+		fmt.Fprintf(&b, "cd %s && ", shellQuote(rc.WorkingDir))
+	}
+	b.WriteString(rc.Inline)
+	script := "sh -c " + shellQuote(b.String())
+	if rc.Sudo {
+		script = "sudo -n " + script
+	}
+	return script
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// runRemoteCommands runs commands in order on host. A failing command stops
+// the run unless its on_failure is continue, in which case a warning is
+// added. The results of all commands which ran are returned.
+func runRemoteCommands(s streamer, host string, commands []remoteCommand) ([]commandResult, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	results := make([]commandResult, 0, len(commands))
+
+	for _, rc := range commands {
+		var result commandResult
+		operation := func() error {
+			var err error
+			result, err = runRemoteCommand(s, host, rc)
+			return err
+		}
+		err := backoff.Retry(operation, backoff.WithMaxRetries(backoff.NewExponentialBackOff(), uint64(rc.Retries)))
+		results = append(results, result)
+		if err == nil {
+			continue
+		}
+		severity := diag.Error
+		if rc.OnFailure == onFailureContinue {
+			severity = diag.Warning
+		}
+		diags = append(diags, diag.Diagnostic{
+			Severity: severity,
+			Summary:  fmt.Sprintf("execution of command '%s' failed: %v", rc.Inline, err),
+			Detail:   fmt.Sprintf("stdout:\n%s\nstderr:\n%s", result.Stdout, result.Stderr),
+		})
+		if severity == diag.Error {
+			return results, diags
+		}
+	}
+	return results, diags
+}
+
+// runRemoteCommand runs rc once, logging its output while it runs
+func runRemoteCommand(s streamer, host string, rc remoteCommand) (commandResult, error) {
+	result := commandResult{Inline: rc.Inline, ExitCode: -1}
+	start := time.Now()
+
+	timeout := rc.Timeout
+	if timeout == 0 {
+		timeout = defaultCommandTimeout
+	}
+	stdoutChan, stderrChan, doneChan, errChan, err := s.Stream(rc.script(), timeout)
+	if err != nil {
+		return result, err
+	}
+	var stdout, stderr strings.Builder
+	var runErr error
+	done := false
+	for doneChan != nil {
+		select {
+		case line, ok := <-stdoutChan:
+			if !ok {
+				stdoutChan = nil
+				continue
+			}
+			log.Printf("[INFO] %s: %s", host, line)
+			stdout.WriteString(line + "\n")
+		case line, ok := <-stderrChan:
+			if !ok {
+				stderrChan = nil
+				continue
+			}
+			log.Printf("[WARN] %s: %s", host, line)
+			stderr.WriteString(line + "\n")
+		case e, ok := <-errChan:
+			if !ok {
+				errChan = nil
+				continue
+			}
+			runErr = e
+		case done = <-doneChan:
+			doneChan = nil
+		}
+	}
+	result.Duration = time.Since(start)
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	if !done {
+		return result, fmt.Errorf("timed out after %s", timeout)
+	}
+	var exitErr *ssh.ExitError
+	switch {
+	case runErr == nil:
+		result.ExitCode = 0
+	case errors.As(runErr, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+	}
+	return result, runErr
+}
+
+// lastStdout returns the stdout of the last command which ran
+func lastStdout(results []commandResult) string {
+	if len(results) == 0 {
+		return ""
+	}
+	return results[len(results)-1].Stdout
+}
+
+func flattenCommandResults(results []commandResult) []interface{} {
+	flattened := make([]interface{}, 0, len(results))
+	for _, r := range results {
+		flattened = append(flattened, map[string]interface{}{
+			"inline":    r.Inline,
+			"stdout":    r.Stdout,
+			"stderr":    r.Stderr,
+			"exit_code": r.ExitCode,
+			"duration":  r.Duration.String(),
+		})
+	}
+	return flattened
+}
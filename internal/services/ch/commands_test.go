@@ -0,0 +1,77 @@
+package ch
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStreamer answers each command with the next of its canned outcomes
+type fakeStreamer struct {
+	scripts  []string
+	outcomes []error
+}
+
+func (f *fakeStreamer) Stream(command string, _ ...time.Duration) (<-chan string, <-chan string, <-chan bool, <-chan error, error) {
+	f.scripts = append(f.scripts, command)
+	var outcome error
+	if len(f.outcomes) > 0 {
+		outcome, f.outcomes = f.outcomes[0], f.outcomes[1:]
+	}
+	stdoutChan := make(chan string)
+	stderrChan := make(chan string)
+	doneChan := make(chan bool)
+	errChan := make(chan error)
+	go func() {
+		stdoutChan <- "out " + command
+		close(stdoutChan)
+		stderrChan <- "err"
+		close(stderrChan)
+		errChan <- outcome
+		doneChan <- true
+	}()
+	return stdoutChan, stderrChan, doneChan, errChan, nil
+}
+
+func TestRemoteCommandScript(t *testing.T) {
+	assert.Equal(t, "docker ps", remoteCommand{Inline: "docker ps"}.script())
+	assert.Equal(t, `sudo -n sh -c 'export A='"'"'x y'"'"'; export B='"'"'1'"'"'; cd '"'"'/opt/app'"'"' && docker compose pull'`,
+		remoteCommand{
+			Inline:      "docker compose pull",
+			Environment: map[string]string{"B": "1", "A": "x y"},
+			WorkingDir:  "/opt/app",
+			Sudo:        true,
+		}.script())
+}
+
+func TestRunRemoteCommands(t *testing.T) {
+	failure := errors.New("Process exited with status 1")
+	s := &fakeStreamer{outcomes: []error{nil, failure, failure, nil, failure}}
+
+	results, diags := runRemoteCommands(s, "host", []remoteCommand{
+		{Inline: "first", OnFailure: onFailureFail},
+		{Inline: "flaky", Retries: 2, OnFailure: onFailureFail},
+		{Inline: "optional", OnFailure: onFailureContinue},
+		{Inline: "last", OnFailure: onFailureFail},
+	})
+	assert.False(t, diags.HasError(), diags)
+	assert.Len(t, diags, 1, "the failure of optional is a warning")
+	assert.Equal(t, []string{"first", "flaky", "flaky", "flaky", "optional", "last"}, s.scripts)
+	if assert.Len(t, results, 4) {
+		assert.Equal(t, "out flaky\n", results[1].Stdout)
+		assert.Equal(t, "err\n", results[1].Stderr)
+		assert.Equal(t, 0, results[1].ExitCode)
+		assert.Equal(t, -1, results[2].ExitCode)
+		assert.Equal(t, "out last\n", lastStdout(results))
+	}
+
+	s = &fakeStreamer{outcomes: []error{failure}}
+	results, diags = runRemoteCommands(s, "host", []remoteCommand{
+		{Inline: "broken", OnFailure: onFailureFail},
+		{Inline: "never", OnFailure: onFailureFail},
+	})
+	assert.True(t, diags.HasError())
+	assert.Len(t, results, 1)
+}
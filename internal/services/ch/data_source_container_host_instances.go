@@ -2,16 +2,58 @@ package ch
 
 import (
 	"context"
+	"fmt"
+	"regexp"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/philips-software/go-hsdp-api/cartel"
 	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+	"github.com/philips-software/terraform-provider-hsdp/internal/tools"
 )
 
+// instanceFilter selects instances, empty fields match any instance
+type instanceFilter struct {
+	Tags         map[string]string
+	Role         string
+	InstanceType string
+	NameRegex    *regexp.Regexp
+	Subnet       string
+	State        string
+}
+
 func DataSourceContainerHostInstances() *schema.Resource {
 	return &schema.Resource{
 		ReadContext: dataSourceContainerHostInstancesRead,
 		Schema: map[string]*schema.Schema{
+			"tags": {
+				Description: "Only return instances which have all of these tags with the given values.",
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"role": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"instance_type": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"name_regex": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringIsValidRegExp,
+			},
+			"subnet": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"state": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
 			"names": {
 				Type:     schema.TypeList,
 				Computed: true,
@@ -32,16 +74,106 @@ func DataSourceContainerHostInstances() *schema.Resource {
 				Computed: true,
 				Elem:     &schema.Schema{Type: schema.TypeString},
 			},
-			"private_addresses": {
+			"private_ips": {
 				Type:     schema.TypeList,
 				Computed: true,
 				Elem:     &schema.Schema{Type: schema.TypeString},
 			},
+			"private_addresses": {
+				Type:       schema.TypeList,
+				Computed:   true,
+				Elem:       &schema.Schema{Type: schema.TypeString},
+				Deprecated: "use private_ips",
+			},
+			"instances": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem:     instanceDetailsSchema(),
+			},
 		},
 	}
 
 }
 
+func instanceDetailsSchema() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"id": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"name": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"instance_type": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"role": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"state": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"owner": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"private_ip": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"public_ip": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"launch_time": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"subnet": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"vpc": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"zone": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"security_groups": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"user_groups": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"block_devices": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"protection": {
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+			"tags": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+		},
+	}
+}
+
 func dataSourceContainerHostInstancesRead(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	var diags diag.Diagnostics
 
@@ -51,6 +183,20 @@ func dataSourceContainerHostInstancesRead(_ context.Context, d *schema.ResourceD
 		return diag.FromErr(err)
 	}
 
+	filter := instanceFilter{
+		Tags:         tools.ExpandStringMap(d.Get("tags").(map[string]interface{})),
+		Role:         d.Get("role").(string),
+		InstanceType: d.Get("instance_type").(string),
+		Subnet:       d.Get("subnet").(string),
+		State:        d.Get("state").(string),
+	}
+	if nameRegex := d.Get("name_regex").(string); nameRegex != "" {
+		filter.NameRegex, err = regexp.Compile(nameRegex)
+		if err != nil {
+			return diag.FromErr(fmt.Errorf("name_regex: %w", err))
+		}
+	}
+
 	instances, _, err := client.GetAllInstances()
 	if err != nil {
 		return diag.FromErr(err)
@@ -58,24 +204,72 @@ func dataSourceContainerHostInstancesRead(_ context.Context, d *schema.ResourceD
 
 	d.SetId("cartel_instances")
 
-	var names []string
-	var ids []string
-	var types []string
-	var privateIPs []string
-	var owners []string
+	names := make([]string, 0)
+	ids := make([]string, 0)
+	types := make([]string, 0)
+	privateIPs := make([]string, 0)
+	owners := make([]string, 0)
+	details := make([]interface{}, 0)
 
 	for _, instance := range *instances {
+		if !filter.matches(instance) {
+			continue
+		}
 		names = append(names, instance.NameTag)
 		ids = append(ids, instance.InstanceID)
 		types = append(types, instance.InstanceType)
 		privateIPs = append(privateIPs, instance.PrivateAddress)
 		owners = append(owners, instance.Owner)
+		details = append(details, flattenInstanceDetails(instance))
 	}
 	_ = d.Set("names", names)
 	_ = d.Set("ids", ids)
 	_ = d.Set("types", types)
 	_ = d.Set("owners", owners)
 	_ = d.Set("private_ips", privateIPs)
+	_ = d.Set("private_addresses", privateIPs)
+	_ = d.Set("instances", details)
 
 	return diags
 }
+
+func (f instanceFilter) matches(instance cartel.InstanceDetails) bool {
+	for k, v := range f.Tags {
+		if value, ok := instance.Tags[k]; !ok || value != v {
+			return false
+		}
+	}
+	if f.NameRegex != nil && !f.NameRegex.MatchString(instance.NameTag) {
+		return false
+	}
+	return (f.Role == "" || f.Role == instance.Role) &&
+		(f.InstanceType == "" || f.InstanceType == instance.InstanceType) &&
+		(f.Subnet == "" || f.Subnet == instance.Subnet) &&
+		(f.State == "" || f.State == instance.State)
+}
+
+func flattenInstanceDetails(instance cartel.InstanceDetails) map[string]interface{} {
+	tags := make(map[string]interface{}, len(instance.Tags))
+	for k, v := range instance.Tags {
+		tags[k] = v
+	}
+	return map[string]interface{}{
+		"id":              instance.InstanceID,
+		"name":            instance.NameTag,
+		"instance_type":   instance.InstanceType,
+		"role":            instance.Role,
+		"state":           instance.State,
+		"owner":           instance.Owner,
+		"private_ip":      instance.PrivateAddress,
+		"public_ip":       instance.PublicAddress,
+		"launch_time":     instance.LaunchTime,
+		"subnet":          instance.Subnet,
+		"vpc":             instance.Vpc,
+		"zone":            instance.Zone,
+		"security_groups": []string(instance.SecurityGroups),
+		"user_groups":     []string(instance.LdapGroups),
+		"block_devices":   instance.BlockDevices,
+		"protection":      instance.Protection,
+		"tags":            tags,
+	}
+}
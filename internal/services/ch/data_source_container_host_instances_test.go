@@ -0,0 +1,65 @@
+package ch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/philips-software/go-hsdp-api/cartel"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataSourceContainerHostInstancesFilter(t *testing.T) {
+	c, _ := newSimulatedConfig(t)
+
+	client, err := c.CartelClient()
+	if !assert.Nil(t, err) {
+		return
+	}
+	for _, host := range []struct {
+		name, instanceType, env string
+	}{
+		{"web-1", "m5.large", "prod"},
+		{"web-2", "m5.xlarge", "prod"},
+		{"db-1", "m5.large", "prod"},
+		{"web-3", "m5.large", "test"},
+	} {
+		_, _, err := client.Create(host.name,
+			cartel.InstanceType(host.instanceType),
+			cartel.UserGroups("ops"),
+			cartel.Tags(map[string]string{"env": host.env}))
+		if !assert.Nil(t, err) {
+			return
+		}
+	}
+	_, _, err = client.Stop("web-2")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	d := schema.TestResourceDataRaw(t, DataSourceContainerHostInstances().Schema, map[string]interface{}{
+		"tags":       map[string]interface{}{"env": "prod"},
+		"name_regex": "^web-",
+		"state":      "running",
+	})
+	diags := dataSourceContainerHostInstancesRead(ctx, d, c)
+	if !assert.False(t, diags.HasError(), diags) {
+		return
+	}
+	assert.Equal(t, []interface{}{"web-1"}, d.Get("names"))
+	instances := d.Get("instances").([]interface{})
+	if !assert.Len(t, instances, 1) {
+		return
+	}
+	web := instances[0].(map[string]interface{})
+	assert.Equal(t, "m5.large", web["instance_type"])
+	assert.Equal(t, "prod", web["tags"].(map[string]interface{})["env"])
+	assert.Equal(t, []interface{}{"ops"}, web["user_groups"])
+	assert.Equal(t, d.Get("private_ips"), []interface{}{web["private_ip"]})
+
+	d = schema.TestResourceDataRaw(t, DataSourceContainerHostInstances().Schema, map[string]interface{}{
+		"instance_type": "m5.large",
+	})
+	diags = dataSourceContainerHostInstancesRead(ctx, d, c)
+	assert.False(t, diags.HasError(), diags)
+	assert.ElementsMatch(t, []interface{}{"web-1", "db-1", "web-3"}, d.Get("names"))
+}
@@ -0,0 +1,105 @@
+package ch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+	"github.com/philips-software/terraform-provider-hsdp/internal/tools"
+)
+
+func DataSourceContainerHostSecurityGroups() *schema.Resource {
+	return &schema.Resource{
+		ReadContext: dataSourceContainerHostSecurityGroupsRead,
+		Schema: map[string]*schema.Schema{
+			"names": {
+				Description: "The security groups to check. Reading fails when any of them does not exist. When not set all security groups are returned.",
+				Type:        schema.TypeList,
+				Optional:    true,
+				Computed:    true,
+				Elem:        tools.StringSchema(),
+			},
+		},
+	}
+}
+
+func dataSourceContainerHostSecurityGroupsRead(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	config := m.(*config.Config)
+	client, err := config.CartelClient()
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	groups, _, err := client.GetSecurityGroups()
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	names := tools.ExpandStringList(d.Get("names").([]interface{}))
+	if len(names) == 0 {
+		names = *groups
+	} else if err := checkGroupNames("security group", names, *groups); err != nil {
+		return diag.FromErr(err)
+	}
+	d.SetId("security_groups")
+	_ = d.Set("names", names)
+
+	return diags
+}
+
+// checkGroupNames returns an error listing the names which are not
+// available, each with the closest available name as a suggestion
+func checkGroupNames(kind string, names, available []string) error {
+	var unknown []string
+	for _, name := range names {
+		if tools.ContainsString(available, name) {
+			continue
+		}
+		entry := fmt.Sprintf("%q", name)
+		if suggestion := closestName(name, available); suggestion != "" {
+			entry += fmt.Sprintf(" (did you mean %q?)", suggestion)
+		}
+		unknown = append(unknown, entry)
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown %s: %s", kind, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// closestName returns the candidate within an edit distance of a third of
+// the length of name, or an empty string
+func closestName(name string, candidates []string) string {
+	best := ""
+	bestDistance := len(name)/3 + 1
+	for _, candidate := range candidates {
+		if distance := editDistance(name, candidate); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,369 @@
+package ch
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+	"github.com/philips-software/terraform-provider-hsdp/internal/tools"
+)
+
+const (
+	fileHashesField         = "file_hashes"
+	deleteRemovedFilesField = "delete_removed_files"
+	detectFileDriftField    = "detect_file_drift"
+)
+
+// syncFile is a single file to place on a host. A file block expands to
+// several when its source is a directory.
+type syncFile struct {
+	Destination string
+	Content     []byte
+	Hash        string
+	Permissions string
+	Owner       string
+	Group       string
+	// Block is the destination of the file block the file came from
+	Block string
+}
+
+// remoteHost is the part of easyssh.MakeConfig used to sync files
+type remoteHost interface {
+	Run(command string, timeout ...time.Duration) (string, string, bool, error)
+	WriteFile(reader io.Reader, size int64, target string) error
+}
+
+func fileFieldSchema(forceNew bool) *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"source": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: forceNew,
+			},
+			"content": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: forceNew,
+			},
+			"destination": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: forceNew,
+			},
+			"permissions": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: forceNew,
+			},
+			"owner": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: forceNew,
+			},
+			"group": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: forceNew,
+			},
+			"include": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: forceNew,
+				Elem:     tools.StringSchema(),
+			},
+			"exclude": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: forceNew,
+				Elem:     tools.StringSchema(),
+			},
+			"template_vars": {
+				Type:     schema.TypeMap,
+				Optional: true,
+				ForceNew: forceNew,
+				Elem:     tools.StringSchema(),
+			},
+		},
+	}
+}
+
+// expandFileBlocks reads the content of all file blocks. Directory sources
+// are walked and filtered through the include and exclude globs, content
+// is rendered as a Go template when template_vars are set.
+func expandFileBlocks(blocks []interface{}) ([]syncFile, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	files := make([]syncFile, 0, len(blocks))
+	for _, raw := range blocks {
+		block := raw.(map[string]interface{})
+		source := block["source"].(string)
+		content := block["content"].(string)
+		destination := block["destination"].(string)
+		base := syncFile{
+			Destination: destination,
+			Permissions: block["permissions"].(string),
+			Owner:       block["owner"].(string),
+			Group:       block["group"].(string),
+			Block:       destination,
+		}
+		if source == "" && content == "" {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  "conflict in file block",
+				Detail:   fmt.Sprintf("file %s has neither 'source' or 'content', set one", destination),
+			})
+			continue
+		}
+		if source != "" && content != "" {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  "conflict in file block",
+				Detail:   fmt.Sprintf("file %s has conflicting 'source' and 'content', choose only one", destination),
+			})
+			continue
+		}
+		vars := tools.ExpandStringMap(block["template_vars"].(map[string]interface{}))
+		include := tools.ExpandStringList(block["include"].([]interface{}))
+		exclude := tools.ExpandStringList(block["exclude"].([]interface{}))
+
+		var expanded []syncFile
+		var err error
+		if content != "" {
+			base.Content = []byte(content)
+			expanded = []syncFile{base}
+		} else {
+			expanded, err = readSource(source, base, include, exclude)
+		}
+		if err == nil && len(vars) > 0 {
+			for i := range expanded {
+				if expanded[i].Content, err = renderTemplate(expanded[i].Destination, expanded[i].Content, vars); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Error,
+				Summary:  "issue with source",
+				Detail:   fmt.Sprintf("file %s: %v", destination, err),
+			})
+			continue
+		}
+		for i := range expanded {
+			sum := sha256.Sum256(expanded[i].Content)
+			expanded[i].Hash = hex.EncodeToString(sum[:])
+		}
+		files = append(files, expanded...)
+	}
+	return files, diags
+}
+
+// readSource reads the file at source, or when source is a directory all
+// files below it which match include and do not match exclude
+func readSource(source string, base syncFile, include, exclude []string) ([]syncFile, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		base.Content, err = os.ReadFile(source)
+		return []syncFile{base}, err
+	}
+	var files []syncFile
+	err = filepath.WalkDir(source, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(source, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if (len(include) > 0 && !matchesAny(include, rel)) || matchesAny(exclude, rel) {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		file := base
+		file.Destination = path.Join(base.Destination, rel)
+		file.Content = data
+		files = append(files, file)
+		return nil
+	})
+	return files, err
+}
+
+// matchesAny reports whether the slash separated path rel or its base name
+// matches one of the globs
+func matchesAny(globs []string, rel string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(glob, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func renderTemplate(name string, content []byte, vars map[string]string) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fileHashes returns the SHA-256 hashes of files by destination
+func fileHashes(files []syncFile) map[string]interface{} {
+	hashes := make(map[string]interface{}, len(files))
+	for _, f := range files {
+		hashes[f.Destination] = f.Hash
+	}
+	return hashes
+}
+
+// changedFileBlocks returns the destinations of file blocks whose
+// permissions or ownership differ between old and new
+func changedFileBlocks(old, new []interface{}) map[string]bool {
+	settings := func(block map[string]interface{}) string {
+		return fmt.Sprintf("%s:%s:%s", block["permissions"], block["owner"], block["group"])
+	}
+	previous := make(map[string]string, len(old))
+	for _, raw := range old {
+		block := raw.(map[string]interface{})
+		previous[block["destination"].(string)] = settings(block)
+	}
+	changed := make(map[string]bool)
+	for _, raw := range new {
+		block := raw.(map[string]interface{})
+		destination := block["destination"].(string)
+		if prev, ok := previous[destination]; !ok || prev != settings(block) {
+			changed[destination] = true
+		}
+	}
+	return changed
+}
+
+// pushFiles uploads the files whose hash differs from the one in remote or
+// whose block is in changed. Each file is written to a temporary file next
+// to its destination, which gets its mode and ownership before it is
+// renamed into place.
+func pushFiles(host remoteHost, c *config.Config, files []syncFile, remote map[string]string, changed map[string]bool) error {
+	dirs := make(map[string]bool)
+	for _, f := range files {
+		if remote[f.Destination] == f.Hash && !changed[f.Block] {
+			_, _ = c.Debug("unchanged remote file %s\n", f.Destination)
+			continue
+		}
+		if dir := path.Dir(f.Destination); !dirs[dir] {
+			if _, errStr, _, err := host.Run("mkdir -p " + shellQuote(dir)); err != nil {
+				return fmt.Errorf("creating directory %s: %w: %s", dir, err, errStr)
+			}
+			dirs[dir] = true
+		}
+		if err := pushFile(host, f); err != nil {
+			_, _ = c.Debug("Error copying to remote file %s: %v\n", f.Destination, err)
+			return fmt.Errorf("copyFiles: %w", err)
+		}
+		_, _ = c.Debug("Created remote file %s: %d bytes\n", f.Destination, len(f.Content))
+	}
+	return nil
+}
+
+func pushFile(host remoteHost, f syncFile) error {
+	tmp := f.Destination + ".tf-" + f.Hash[:12]
+	if err := host.WriteFile(bytes.NewReader(f.Content), int64(len(f.Content)), tmp); err != nil {
+		return err
+	}
+	steps := make([]string, 0, 4)
+	if f.Permissions != "" {
+		steps = append(steps, "chmod "+shellQuote(f.Permissions)+" "+shellQuote(tmp))
+	}
+	if f.Owner != "" {
+		steps = append(steps, "chown "+shellQuote(f.Owner)+" "+shellQuote(tmp))
+	}
+	if f.Group != "" {
+		steps = append(steps, "chgrp "+shellQuote(f.Group)+" "+shellQuote(tmp))
+	}
+	steps = append(steps, "mv -f "+shellQuote(tmp)+" "+shellQuote(f.Destination))
+	if _, errStr, _, err := host.Run(strings.Join(steps, " && ")); err != nil {
+		_, _, _, _ = host.Run("rm -f " + shellQuote(tmp))
+		return fmt.Errorf("%s: %w: %s", f.Destination, err, errStr)
+	}
+	return nil
+}
+
+// removedFiles returns the destinations in old which are not in files
+func removedFiles(old map[string]interface{}, files []syncFile) []string {
+	current := make(map[string]bool, len(files))
+	for _, f := range files {
+		current[f.Destination] = true
+	}
+	var removed []string
+	for destination := range old {
+		if !current[destination] {
+			removed = append(removed, destination)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+func removeFiles(host remoteHost, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	quoted := make([]string, 0, len(paths))
+	for _, p := range paths {
+		quoted = append(quoted, shellQuote(p))
+	}
+	if _, errStr, _, err := host.Run("rm -f -- " + strings.Join(quoted, " ")); err != nil {
+		return fmt.Errorf("removing files: %w: %s", err, errStr)
+	}
+	return nil
+}
+
+// remoteFileHashes returns the SHA-256 hashes of the paths present on host
+func remoteFileHashes(host remoteHost, paths []string) (map[string]string, error) {
+	hashes := make(map[string]string, len(paths))
+	if len(paths) == 0 {
+		return hashes, nil
+	}
+	quoted := make([]string, 0, len(paths))
+	for _, p := range paths {
+		quoted = append(quoted, shellQuote(p))
+	}
+	// sha256sum fails when a file is missing, its output is still usable
+	stdout, _, _, err := host.Run("sha256sum -- " + strings.Join(quoted, " ") + " 2>/dev/null || true")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), "  ", 2)
+		if len(fields) == 2 {
+			hashes[fields[1]] = fields[0]
+		}
+	}
+	return hashes, nil
+}
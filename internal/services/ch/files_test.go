@@ -0,0 +1,115 @@
+package ch
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHost records the commands run and files written
+type fakeHost struct {
+	commands []string
+	written  map[string]string
+}
+
+func (f *fakeHost) Run(command string, _ ...time.Duration) (string, string, bool, error) {
+	f.commands = append(f.commands, command)
+	return "", "", true, nil
+}
+
+func (f *fakeHost) WriteFile(reader io.Reader, _ int64, target string) error {
+	data, err := io.ReadAll(reader)
+	if f.written == nil {
+		f.written = make(map[string]string)
+	}
+	f.written[target] = string(data)
+	return err
+}
+
+func fileBlock(values map[string]interface{}) map[string]interface{} {
+	block := map[string]interface{}{
+		"source": "", "content": "", "destination": "", "permissions": "", "owner": "", "group": "",
+		"include": []interface{}{}, "exclude": []interface{}{}, "template_vars": map[string]interface{}{},
+	}
+	for k, v := range values {
+		block[k] = v
+	}
+	return block
+}
+
+func TestExpandFileBlocks(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "conf.d"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "compose.yml"), []byte("image: {{ .image }}"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "conf.d", "app.conf"), []byte("port 80"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.md"), []byte("skip"), 0600))
+
+	files, diags := expandFileBlocks([]interface{}{
+		fileBlock(map[string]interface{}{
+			"source":        dir,
+			"destination":   "/opt/app",
+			"exclude":       []interface{}{"*.md"},
+			"template_vars": map[string]interface{}{"image": "nginx:1.21"},
+		}),
+		fileBlock(map[string]interface{}{"content": "hello", "destination": "/tmp/hello"}),
+	})
+	require.False(t, diags.HasError(), diags)
+	byDestination := make(map[string]syncFile)
+	for _, f := range files {
+		byDestination[f.Destination] = f
+	}
+	assert.Len(t, byDestination, 3)
+	assert.Equal(t, "image: nginx:1.21", string(byDestination["/opt/app/compose.yml"].Content))
+	assert.Equal(t, "/opt/app", byDestination["/opt/app/conf.d/app.conf"].Block)
+	assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", byDestination["/tmp/hello"].Hash)
+
+	_, diags = expandFileBlocks([]interface{}{
+		fileBlock(map[string]interface{}{"content": "{{ .missing }}", "destination": "/tmp/x", "template_vars": map[string]interface{}{"a": "b"}}),
+	})
+	assert.True(t, diags.HasError())
+}
+
+func TestPushFiles(t *testing.T) {
+	files, diags := expandFileBlocks([]interface{}{
+		fileBlock(map[string]interface{}{"content": "same", "destination": "/etc/same"}),
+		fileBlock(map[string]interface{}{"content": "new", "destination": "/etc/app/new conf", "owner": "app'user", "permissions": "0600"}),
+	})
+	require.False(t, diags.HasError(), diags)
+	remote := map[string]string{"/etc/same": files[0].Hash, "/etc/gone": "abc"}
+
+	host := &fakeHost{}
+	err := pushFiles(host, &config.Config{}, files, remote, nil)
+	require.NoError(t, err)
+	assert.Len(t, host.written, 1, "unchanged files are not uploaded")
+	for target, content := range host.written {
+		assert.Equal(t, "new", content)
+		assert.Contains(t, target, "/etc/app/new conf.tf-")
+	}
+	assert.Equal(t, "mkdir -p '/etc/app'", host.commands[0])
+	assert.Regexp(t, `^chmod '0600' '/etc/app/new conf\.tf-[0-9a-f]{12}' && chown 'app'"'"'user' '/etc/app/new conf\.tf-[0-9a-f]{12}' && mv -f '/etc/app/new conf\.tf-[0-9a-f]{12}' '/etc/app/new conf'$`, host.commands[1])
+
+	hashes := make(map[string]interface{})
+	for k, v := range remote {
+		hashes[k] = v
+	}
+	removed := removedFiles(hashes, files)
+	assert.Equal(t, []string{"/etc/gone"}, removed)
+	require.NoError(t, removeFiles(host, removed))
+	assert.Equal(t, "rm -f -- '/etc/gone'", host.commands[len(host.commands)-1])
+}
+
+func TestChangedFileBlocks(t *testing.T) {
+	old := []interface{}{fileBlock(map[string]interface{}{"destination": "/a", "permissions": "0644"})}
+	changed := changedFileBlocks(old, []interface{}{
+		fileBlock(map[string]interface{}{"destination": "/a", "permissions": "0600"}),
+	})
+	assert.True(t, changed["/a"])
+	changed = changedFileBlocks(old, old)
+	assert.Empty(t, changed)
+}
@@ -0,0 +1,287 @@
+package ch
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/loafoe/easyssh-proxy/v2"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	hostKeyField  = "host_key"
+	hostKeysField = "host_keys"
+
+	sshDialTimeout = 30 * time.Second
+)
+
+var errHostKeyCaptured = errors.New("host key captured")
+
+var (
+	// bastionKeys caches the verified host key fingerprints by bastion
+	bastionKeys sync.Map
+	// bastionMu serializes bastion verification, which may update the
+	// known_hosts file
+	bastionMu sync.Mutex
+)
+
+// pinHostKeys makes later connections of sshConfig verify the host keys of
+// the bastion and the target. The bastion key is checked against the
+// known_hosts file according to the provider settings. The target must
+// present hostKey, a SHA256 fingerprint. When hostKey is empty the key the
+// target presents is captured and its fingerprint returned. Connecting is
+// retried up to retries times, key mismatches are not retried.
+func pinHostKeys(sshConfig *easyssh.MakeConfig, c *config.Config, hostKey string, retries uint64) (string, error) {
+	checking := c.StrictHostKeyChecking
+	if checking == "" {
+		checking = config.HostKeyCheckingAcceptNew
+	}
+	bastion := sshConfig.Bastion.Server
+	if hostKey != "" {
+		sshConfig.Fingerprint = hostKey
+		if bastion == "" || checking == config.HostKeyCheckingNo {
+			return hostKey, nil
+		}
+		if fingerprint, ok := bastionKeys.Load(bastion); ok {
+			sshConfig.Bastion.Fingerprint = fingerprint.(string)
+			return hostKey, nil
+		}
+	}
+
+	var captured string
+	operation := func() error {
+		var err error
+		captured, err = dialAndCapture(sshConfig, c, checking, hostKey == "")
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}
+	if err := backoff.Retry(operation, backoff.WithMaxRetries(backoff.NewExponentialBackOff(), retries)); err != nil {
+		return "", fmt.Errorf("verifying host keys of %s: %w", sshConfig.Server, err)
+	}
+	if hostKey == "" {
+		hostKey = captured
+		log.Printf("[INFO] captured host key %s of %s", hostKey, sshConfig.Server)
+	}
+	sshConfig.Fingerprint = hostKey
+	if fingerprint, ok := bastionKeys.Load(bastion); ok && checking != config.HostKeyCheckingNo {
+		sshConfig.Bastion.Fingerprint = fingerprint.(string)
+	}
+	return hostKey, nil
+}
+
+// dialAndCapture connects to the bastion, verifying its host key, and when
+// capture is set returns the fingerprint of the target host key
+func dialAndCapture(sshConfig *easyssh.MakeConfig, c *config.Config, checking string, capture bool) (string, error) {
+	target := net.JoinHostPort(sshConfig.Server, sshConfig.Port)
+	if sshConfig.Bastion.Server == "" {
+		if !capture {
+			return "", nil
+		}
+		conn, err := net.DialTimeout("tcp", target, sshDialTimeout)
+		if err != nil {
+			return "", err
+		}
+		return captureHostKey(conn, target)
+	}
+
+	bastionMu.Lock()
+	client, err := dialBastion(sshConfig, c, checking)
+	bastionMu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	if !capture {
+		return "", nil
+	}
+	conn, err := client.Dial("tcp", target)
+	if err != nil {
+		return "", err
+	}
+	return captureHostKey(conn, target)
+}
+
+// captureHostKey runs the SSH handshake on conn up to the host key check
+// and returns the SHA256 fingerprint of the key the server presented
+func captureHostKey(conn net.Conn, addr string) (string, error) {
+	defer conn.Close()
+	var fingerprint string
+	_, _, _, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User: "hostkey",
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			fingerprint = ssh.FingerprintSHA256(key)
+			return errHostKeyCaptured
+		},
+		Timeout: sshDialTimeout,
+	})
+	if fingerprint != "" {
+		return fingerprint, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("no host key presented by %s", addr)
+	}
+	return "", err
+}
+
+// dialBastion connects and authenticates to the bastion of sshConfig. The
+// fingerprint of the verified bastion host key is cached in bastionKeys.
+func dialBastion(sshConfig *easyssh.MakeConfig, c *config.Config, checking string) (*ssh.Client, error) {
+	bastion := sshConfig.Bastion
+	callback, err := knownHostsCallback(c.KnownHostsFile, checking)
+	if err != nil {
+		return nil, err
+	}
+	var auths []ssh.AuthMethod
+	if bastion.Key != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(bastion.Key))
+		if err != nil {
+			return nil, err
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+	if sock, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK")); err == nil {
+		defer sock.Close()
+		auths = append(auths, ssh.PublicKeysCallback(agent.NewClient(sock).Signers))
+	}
+	clientConfig := &ssh.ClientConfig{
+		User: bastion.User,
+		Auth: auths,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if err := callback(hostname, remote, key); err != nil {
+				return err
+			}
+			bastionKeys.Store(bastion.Server, ssh.FingerprintSHA256(key))
+			return nil
+		},
+		Timeout: sshDialTimeout,
+	}
+	addr := net.JoinHostPort(bastion.Server, bastion.Port)
+	conn, err := dialThroughProxy(sshConfig.Proxy, addr)
+	if err != nil {
+		return nil, err
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// knownHostsCallback verifies host keys against file, ~/.ssh/known_hosts
+// when empty. Following ssh_config(5), unknown hosts are refused when
+// checking is yes and added to file when it is accept-new.
+func knownHostsCallback(file, checking string) (ssh.HostKeyCallback, error) {
+	if checking == config.HostKeyCheckingNo {
+		return ssh.InsecureIgnoreHostKey(), nil //nolint:gosec
+	}
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	if _, err := os.Stat(file); os.IsNotExist(err) && checking == config.HostKeyCheckingAcceptNew {
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(file, nil, 0600); err != nil {
+			return nil, err
+		}
+	}
+	callback, err := knownhosts.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading known_hosts_file: %w", err)
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 || checking != config.HostKeyCheckingAcceptNew {
+			return err
+		}
+		log.Printf("[WARN] adding host key %s of %s to %s", ssh.FingerprintSHA256(key), hostname, file)
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+		return err
+	}, nil
+}
+
+// dialThroughProxy connects to addr, through an HTTP CONNECT proxy when
+// proxy returns one
+func dialThroughProxy(proxy func(*http.Request) (*url.URL, error), addr string) (net.Conn, error) {
+	var proxyURL *url.URL
+	if proxy != nil {
+		for _, scheme := range []string{"https", "http"} {
+			req, _ := http.NewRequest(http.MethodConnect, scheme+"://"+addr, nil)
+			if u, err := proxy(req); err == nil && u != nil {
+				proxyURL = u
+				break
+			}
+		}
+	}
+	if proxyURL == nil {
+		return net.DialTimeout("tcp", addr, sshDialTimeout)
+	}
+	conn, err := net.DialTimeout("tcp", proxyURL.Host, sshDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT to %s: %s", addr, resp.Status)
+	}
+	// The SSH server may already have sent its banner
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.reader.Read(p)
+}
@@ -0,0 +1,74 @@
+package ch
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newHostKey(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	return signer
+}
+
+func TestCaptureHostKey(t *testing.T) {
+	hostKey := newHostKey(t)
+	server := &ssh.ServerConfig{NoClientAuth: true}
+	server.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, _, _, _ = ssh.NewServerConn(conn, server)
+		_ = conn.Close()
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	fingerprint, err := captureHostKey(conn, listener.Addr().String())
+	require.NoError(t, err)
+	assert.Equal(t, ssh.FingerprintSHA256(hostKey.PublicKey()), fingerprint)
+}
+
+func TestKnownHostsCallback(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+	key := newHostKey(t).PublicKey()
+	other := newHostKey(t).PublicKey()
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 22}
+
+	_, err := knownHostsCallback(file, config.HostKeyCheckingYes)
+	assert.Error(t, err, "strict checking needs an existing known_hosts file")
+
+	callback, err := knownHostsCallback(file, config.HostKeyCheckingAcceptNew)
+	require.NoError(t, err)
+	require.NoError(t, callback("bastion.example.com:22", remote, key))
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "bastion.example.com ssh-ed25519 ")
+
+	callback, err = knownHostsCallback(file, config.HostKeyCheckingYes)
+	require.NoError(t, err)
+	assert.NoError(t, callback("bastion.example.com:22", remote, key))
+	assert.Error(t, callback("bastion.example.com:22", remote, other), "changed keys are refused")
+	assert.Error(t, callback("unknown.example.com:22", remote, key), "unknown hosts are refused")
+
+	callback, err = knownHostsCallback(file, config.HostKeyCheckingAcceptNew)
+	require.NoError(t, err)
+	assert.Error(t, callback("bastion.example.com:22", remote, other), "changed keys are refused")
+}
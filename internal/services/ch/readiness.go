@@ -0,0 +1,113 @@
+package ch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+const (
+	readinessCheckField     = "readiness_check"
+	defaultReadinessTimeout = 10 * time.Minute
+)
+
+// readinessCheck replaces the docker check after create
+type readinessCheck struct {
+	MarkerFile     string
+	HTTPURL        string
+	ExpectedStatus int
+	Timeout        time.Duration
+}
+
+func readinessCheckSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"marker_file": {
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				"http_url": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					ValidateFunc: validation.IsURLWithHTTPorHTTPS,
+				},
+				"expected_status": {
+					Type:         schema.TypeInt,
+					Optional:     true,
+					Default:      http.StatusOK,
+					ValidateFunc: validation.IntBetween(100, 599),
+				},
+				"timeout": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      defaultReadinessTimeout.String(),
+					ValidateFunc: validateDuration,
+				},
+			},
+		},
+	}
+}
+
+// expandReadinessCheck returns the readiness_check block, or nil when none
+// is set
+func expandReadinessCheck(d *schema.ResourceData) (*readinessCheck, error) {
+	list := d.Get(readinessCheckField).([]interface{})
+	if len(list) == 0 || list[0] == nil {
+		return nil, nil
+	}
+	block := list[0].(map[string]interface{})
+	timeout, err := time.ParseDuration(block["timeout"].(string))
+	if err != nil {
+		return nil, fmt.Errorf("readiness_check: %w", err)
+	}
+	check := &readinessCheck{
+		MarkerFile:     block["marker_file"].(string),
+		HTTPURL:        block["http_url"].(string),
+		ExpectedStatus: block["expected_status"].(int),
+		Timeout:        timeout,
+	}
+	if (check.MarkerFile == "") == (check.HTTPURL == "") {
+		return nil, fmt.Errorf("readiness_check: set exactly one of 'marker_file' or 'http_url'")
+	}
+	return check, nil
+}
+
+// waitForReadiness polls the marker file over SSH or the HTTP endpoint
+// until it is ready or the check times out
+func waitForReadiness(ctx context.Context, host remoteHost, check *readinessCheck) error {
+	operation := func() error {
+		if check.MarkerFile != "" {
+			_, _, _, err := host.Run("test -f " + shellQuote(check.MarkerFile))
+			if err != nil {
+				return fmt.Errorf("marker file %s not present: %w", check.MarkerFile, err)
+			}
+			return nil
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, check.HTTPURL, nil)
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != check.ExpectedStatus {
+			return fmt.Errorf("%s returned status %d, expected %d", check.HTTPURL, resp.StatusCode, check.ExpectedStatus)
+		}
+		return nil
+	}
+	b := backoff.NewExponentialBackOff()
+	b.MaxInterval = 30 * time.Second
+	b.MaxElapsedTime = check.Timeout
+	return backoff.Retry(operation, backoff.WithContext(b, ctx))
+}
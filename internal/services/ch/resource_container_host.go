@@ -1,10 +1,8 @@
 package ch
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"os"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/hashicorp/go-cty/cty"
@@ -26,6 +24,9 @@ import (
 const (
 	fileField     = "file"
 	commandsField = "commands"
+
+	powerStateRunning = "running"
+	powerStateStopped = "stopped"
 )
 
 func tagsSchema() *schema.Schema {
@@ -81,6 +82,7 @@ func ResourceContainerHost() *schema.Resource {
 		ReadContext:   resourceContainerHostRead,
 		UpdateContext: resourceContainerHostUpdate,
 		DeleteContext: resourceContainerHostDelete,
+		CustomizeDiff: customizeContainerHostDiff,
 
 		Timeouts: &schema.ResourceTimeout{
 			Create: schema.DefaultTimeout(30 * time.Minute),
@@ -193,11 +195,33 @@ func ResourceContainerHost() *schema.Resource {
 				Optional: true,
 				Elem:     &schema.Schema{Type: schema.TypeString},
 			},
+			commandField:        commandSchema(false),
+			commandResultsField: commandResultsSchema(),
 			fileField: {
 				Type:     schema.TypeSet,
 				Optional: true,
-				Elem:     fileFieldSchema(),
+				Elem:     fileFieldSchema(false),
+			},
+			fileHashesField: {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			deleteRemovedFilesField: {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			detectFileDriftField: {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
 			},
+			hostKeyField: {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			readinessCheckField: readinessCheckSchema(),
 			"subnet_type": {
 				Type:          schema.TypeString,
 				Optional:      true,
@@ -244,42 +268,28 @@ func ResourceContainerHost() *schema.Resource {
 				Type:     schema.TypeString,
 				Computed: true,
 			},
-			"tags": tagsSchema(),
-		},
-		SchemaVersion: 5,
-	}
-}
-
-func fileFieldSchema() *schema.Resource {
-	return &schema.Resource{
-		Schema: map[string]*schema.Schema{
-			"source": {
-				Type:     schema.TypeString,
-				Optional: true,
-			},
-			"content": {
-				Type:     schema.TypeString,
-				Optional: true,
-			},
-			"destination": {
-				Type:     schema.TypeString,
-				Required: true,
-			},
-			"permissions": {
-				Type:     schema.TypeString,
-				Optional: true,
+			"power_state": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				ValidateFunc: validation.StringInSlice([]string{powerStateRunning, powerStateStopped}, false),
 			},
-			"owner": {
-				Type:     schema.TypeString,
+			"reboot_trigger": {
+				Type:     schema.TypeMap,
 				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
 			},
-			"group": {
-				Type:     schema.TypeString,
-				Optional: true,
+			"tags": tagsSchema(),
+			"tags_all": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
 			},
 		},
+		SchemaVersion: 5,
 	}
 }
+
 func InstanceStateRefreshFunc(client *cartel.Client, nameTag string, failStates []string) resource.StateRefreshFunc {
 	return func() (interface{}, string, error) {
 		state, resp, err := client.GetDeploymentState(nameTag)
@@ -331,30 +341,31 @@ func resourceContainerHostCreate(ctx context.Context, d *schema.ResourceData, m
 		subnetType = "private"
 	}
 	subnet := d.Get("subnet").(string)
-	tagList := d.Get("tags").(map[string]interface{})
-	tags := make(map[string]string)
-	for t, v := range tagList {
-		if val, ok := v.(string); ok {
-			tags[t] = val
-		}
-	}
+	tags := c.MergeTags(tools.ExpandStringMap(d.Get("tags").(map[string]interface{})))
 	// Validation
 	if diags := validateContainerHostSchema(d); len(diags) > 0 {
 		return diags
 	}
 
 	// Fetch files first before starting provisioning
-	createFiles, diags := collectFilesToCreate(d)
+	createFiles, diags := expandFileBlocks(d.Get(fileField).(*schema.Set).List())
 	if len(diags) > 0 {
 		return diags
 	}
 	// And commands
-	commands, diags := tools.CollectList(commandsField, d)
+	commands, diags := collectCommands(d)
 	if len(diags) > 0 {
 		return diags
 	}
 
-	if len(commands) > 0 || len(createFiles) > 0 {
+	// And the readiness check
+	readiness, err := expandReadinessCheck(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	needsSSH := len(commands) > 0 || len(createFiles) > 0 || (readiness != nil && readiness.MarkerFile != "")
+
+	if needsSSH {
 		if user == "" && !agent {
 			return diag.FromErr(fmt.Errorf("'user' must be set when 'agent = false' and '%s' are set or 'file' blocks are present", commandsField))
 		}
@@ -443,25 +454,34 @@ func resourceContainerHostCreate(ctx context.Context, d *schema.ResourceData, m
 		"host": ipAddress,
 	})
 	// Collect SSH details
-	privateIP := ipAddress
-	ssh := &easyssh.MakeConfig{
-		User:   user,
-		Server: privateIP,
-		Port:   "22",
-		Proxy:  http.ProxyFromEnvironment,
-		Bastion: easyssh.DefaultConfig{
-			User:   user,
-			Server: bastionHost,
-			Port:   "22",
-		},
-	}
-	if privateKey != "" {
-		ssh.Key = privateKey
-		ssh.Bastion.Key = privateKey
+	ssh := sshConfig(user, privateKey, ipAddress, bastionHost)
+
+	// Capture the host key on first connect, later connections verify it
+	if needsSSH {
+		hostKey, err := pinHostKeys(ssh, c, "", 10)
+		if err != nil {
+			if !keepFailedInstances {
+				_, _, _ = client.Destroy(tagName)
+				d.SetId("")
+			}
+			return diag.FromErr(err)
+		}
+		_ = d.Set(hostKeyField, hostKey)
 	}
 
-	// Check health of Docker daemon in case of 'container-host' role and file or commands are set
-	if (len(commands) > 0 || len(createFiles) > 0) && instanceRole == "container-host" {
+	// Wait for the readiness check, or check health of Docker daemon in case of
+	// 'container-host' role and file or commands are set
+	if readiness != nil {
+		if err := waitForReadiness(ctx, ssh, readiness); err != nil {
+			if !keepFailedInstances {
+				_, _, _ = client.Destroy(tagName)
+				d.SetId("")
+			}
+			return diag.FromErr(fmt.Errorf(
+				"container host instance '%s' did not become ready: %v",
+				instanceID, err))
+		}
+	} else if (len(commands) > 0 || len(createFiles) > 0) && instanceRole == "container-host" {
 		if err := ensureContainerHostReady(ssh, c); err != nil {
 			if !keepFailedInstances {
 				_, _, _ = client.Destroy(tagName)
@@ -475,21 +495,30 @@ func resourceContainerHostCreate(ctx context.Context, d *schema.ResourceData, m
 
 	// Create files
 	_, _ = c.Debug("about to copy %d files to remote\n", len(createFiles))
-	if err := copyFiles(ssh, c, createFiles); err != nil {
+	if err := pushFiles(ssh, c, createFiles, nil, nil); err != nil {
 		diags = append(diags, diag.Diagnostic{
 			Severity: diag.Warning,
 			Summary:  "failed to copy all files",
 			Detail:   fmt.Sprintf("One or more files failed to copy: %v", err),
 		})
+	} else {
+		_ = d.Set(fileHashesField, fileHashes(createFiles))
 	}
 
 	// Run commands
-	stdout, errDiags, err := runCommands(commands, ssh, m)
-	if err != nil {
-		return errDiags
+	results, runDiags := runRemoteCommands(ssh, ipAddress, commands)
+	diags = append(diags, runDiags...)
+	if runDiags.HasError() {
+		return diags
 	}
-	_ = d.Set("result", stdout)
+	_ = d.Set("result", lastStdout(results))
+	_ = d.Set(commandResultsField, flattenCommandResults(results))
 	d.SetId(instanceID)
+	if d.Get("power_state").(string) == powerStateStopped {
+		if err := stopInstance(ctx, client, tagName, d.Timeout(schema.TimeoutCreate)); err != nil {
+			diags = append(diags, diag.FromErr(err)...)
+		}
+	}
 	readDiags := resourceContainerHostRead(ctx, d, m)
 	return append(diags, readDiags...)
 }
@@ -537,134 +566,7 @@ func findInstanceByName(client *cartel.Client, name string) *cartel.InstanceDeta
 	return nil
 }
 
-func copyFiles(ssh *easyssh.MakeConfig, config *config.Config, createFiles []provisionFile) error {
-	for _, f := range createFiles {
-		if f.Source != "" {
-			src, srcErr := os.Open(f.Source)
-			if srcErr != nil {
-				_, _ = config.Debug("Failed to open source file %s: %v\n", f.Source, srcErr)
-				return srcErr
-			}
-			srcStat, statErr := src.Stat()
-			if statErr != nil {
-				_, _ = config.Debug("Failed to stat source file %s: %v\n", f.Source, statErr)
-				_ = src.Close()
-				return fmt.Errorf("copyFiles: %w", statErr)
-			}
-			err := ssh.WriteFile(src, srcStat.Size(), f.Destination)
-			if err != nil {
-				_, _ = config.Debug("Error copying %s to remote file %s:%s: %v\n", f.Source, ssh.Server, f.Destination, err)
-				return fmt.Errorf("copyFiles: %w", err)
-			}
-			_, _ = config.Debug("Copied %s to remote file %s:%s: %d bytes\n", f.Source, ssh.Server, f.Destination, srcStat.Size())
-			_ = src.Close()
-		} else {
-			buffer := bytes.NewBufferString(f.Content)
-			// Should we fail the complete provision on errors here?
-			err := ssh.WriteFile(buffer, int64(buffer.Len()), f.Destination)
-			if err != nil {
-				_, _ = config.Debug("Error copying content to remote file %s:%s: %v\n", ssh.Server, f.Destination, err)
-				return fmt.Errorf("copyFiles: %w", err)
-			}
-			_, _ = config.Debug("Created remote file %s:%s: %d bytes\n", ssh.Server, f.Destination, len(f.Content))
-		}
-		// Permissions change
-		if f.Permissions != "" {
-			outStr, errStr, _, err := ssh.Run(fmt.Sprintf("chmod %s \"%s\"", f.Permissions, f.Destination))
-			_, _ = config.Debug("Permissions file %s:%s: %v %v\n", f.Destination, f.Permissions, outStr, errStr)
-			if err != nil {
-				return err
-				// Owner
-			}
-		}
-		if f.Owner != "" {
-			outStr, errStr, _, err := ssh.Run(fmt.Sprintf("chown %s \"%s\"", f.Owner, f.Destination))
-			_, _ = config.Debug("Owner file %s:%s: %v %v\n", f.Destination, f.Owner, outStr, errStr)
-			if err != nil {
-				return err
-			}
-		}
-		// Group
-		if f.Group != "" {
-			outStr, errStr, _, err := ssh.Run(fmt.Sprintf("chgrp %s \"%s\"", f.Group, f.Destination))
-			_, _ = config.Debug("Group file %s:%s: %v %v\n", f.Destination, f.Group, outStr, errStr)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	return nil
-}
-
-type provisionFile struct {
-	Source      string
-	Content     string
-	Destination string
-	Permissions string
-	Owner       string
-	Group       string
-}
-
-func collectFilesToCreate(d *schema.ResourceData) ([]provisionFile, diag.Diagnostics) {
-	var diags diag.Diagnostics
-	files := make([]provisionFile, 0)
-	if v, ok := d.GetOk(fileField); ok {
-		vL := v.(*schema.Set).List()
-		for _, vi := range vL {
-			mVi := vi.(map[string]interface{})
-			file := provisionFile{
-				Source:      mVi["source"].(string),
-				Content:     mVi["content"].(string),
-				Destination: mVi["destination"].(string),
-				Permissions: mVi["permissions"].(string),
-				Owner:       mVi["owner"].(string),
-				Group:       mVi["group"].(string),
-			}
-			if file.Source == "" && file.Content == "" {
-				diags = append(diags, diag.Diagnostic{
-					Severity: diag.Error,
-					Summary:  "conflict in file block",
-					Detail:   fmt.Sprintf("file %s has neither 'source' or 'content', set one", file.Destination),
-				})
-				continue
-			}
-			if file.Source != "" && file.Content != "" {
-				diags = append(diags, diag.Diagnostic{
-					Severity: diag.Error,
-					Summary:  "conflict in file block",
-					Detail:   fmt.Sprintf("file %s has conflicting 'source' and 'content', choose only one", file.Destination),
-				})
-				continue
-			}
-			if file.Source != "" {
-				src, srcErr := os.Open(file.Source)
-				if srcErr != nil {
-					diags = append(diags, diag.Diagnostic{
-						Severity: diag.Error,
-						Summary:  "issue with source",
-						Detail:   fmt.Sprintf("file %s: %v", file.Source, srcErr),
-					})
-					continue
-				}
-				_, statErr := src.Stat()
-				if statErr != nil {
-					diags = append(diags, diag.Diagnostic{
-						Severity: diag.Error,
-						Summary:  "issue with source stat",
-						Detail:   fmt.Sprintf("file %s: %v", file.Source, statErr),
-					})
-					_ = src.Close()
-					continue
-				}
-				_ = src.Close()
-			}
-			files = append(files, file)
-		}
-	}
-	return files, diags
-}
-
-func resourceContainerHostUpdate(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+func resourceContainerHostUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	c := m.(*config.Config)
 
 	var diags diag.Diagnostics
@@ -688,7 +590,6 @@ func resourceContainerHostUpdate(_ context.Context, d *schema.ResourceData, m in
 		return diags
 	}
 	bastionHost := d.Get("bastion_host").(string)
-	user := d.Get("user").(string)
 	privateKey := d.Get("private_key").(string)
 	commandsAfterFileChanges := d.Get("commands_after_file_changes").(bool)
 	agent := d.Get("agent").(bool)
@@ -696,8 +597,27 @@ func resourceContainerHostUpdate(_ context.Context, d *schema.ResourceData, m in
 		bastionHost = client.BastionHost()
 	}
 
-	if d.HasChange("tags") {
-		o, n := d.GetChange("tags")
+	startedNow := false
+	if d.HasChange("power_state") {
+		var err error
+		if d.Get("power_state").(string) == powerStateStopped {
+			err = stopInstance(ctx, client, tagName, d.Timeout(schema.TimeoutUpdate))
+		} else {
+			err = startInstance(ctx, client, tagName, d.Timeout(schema.TimeoutUpdate))
+			startedNow = true
+		}
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+	// Starting the instance already restarted it
+	if d.HasChange("reboot_trigger") && !startedNow && d.Get("power_state").(string) != powerStateStopped {
+		if err := rebootInstance(ctx, client, tagName, d.Timeout(schema.TimeoutUpdate)); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+	if d.HasChange("tags_all") {
+		o, n := d.GetChange("tags_all")
 		change := generateTagChange(o, n)
 		log.Printf("[o:%v] [n:%v] [c:%v]\n", o, n, change)
 		_, _, err := client.AddTags([]string{tagName}, change)
@@ -760,10 +680,63 @@ func resourceContainerHostUpdate(_ context.Context, d *schema.ResourceData, m in
 		}
 	}
 	// Collect SSH details
+	if privateKey != "" && agent {
+		return diag.FromErr(fmt.Errorf("'agent' is enabled so not expecting a private key to be set"))
+	}
 	privateIP := d.Get("private_ip").(string)
+	ssh := containerHostSSH(d, bastionHost)
+	if d.HasChange(fileField) || d.HasChange(fileHashesField) {
+		files, fileDiags := expandFileBlocks(d.Get(fileField).(*schema.Set).List())
+		if len(fileDiags) > 0 {
+			return fileDiags
+		}
+		hostKey, err := pinHostKeys(ssh, c, d.Get(hostKeyField).(string), 3)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		_ = d.Set(hostKeyField, hostKey)
+		oldBlocks, newBlocks := d.GetChange(fileField)
+		oldHashes, _ := d.GetChange(fileHashesField)
+		changed := changedFileBlocks(oldBlocks.(*schema.Set).List(), newBlocks.(*schema.Set).List())
+		_, _ = c.Debug("about to sync %d files to remote\n", len(files))
+		if err := pushFiles(ssh, c, files, tools.ExpandStringMap(oldHashes.(map[string]interface{})), changed); err != nil {
+			return diag.FromErr(fmt.Errorf("copying files to remote: %w", err))
+		}
+		if d.Get(deleteRemovedFilesField).(bool) {
+			if err := removeFiles(ssh, removedFiles(oldHashes.(map[string]interface{}), files)); err != nil {
+				return diag.FromErr(err)
+			}
+		}
+		_ = d.Set(fileHashesField, fileHashes(files))
+		if commandsAfterFileChanges {
+			commands, cmdDiags := collectCommands(d)
+			if len(cmdDiags) > 0 {
+				return cmdDiags
+			}
+			// Run commands
+			results, runDiags := runRemoteCommands(ssh, privateIP, commands)
+			diags = append(diags, runDiags...)
+			if runDiags.HasError() {
+				return diags
+			}
+			_ = d.Set("result", lastStdout(results))
+			_ = d.Set(commandResultsField, flattenCommandResults(results))
+		}
+	}
+	return diags
+}
+
+// containerHostSSH returns the SSH settings to reach the instance
+func containerHostSSH(d *schema.ResourceData, bastionHost string) *easyssh.MakeConfig {
+	return sshConfig(d.Get("user").(string), d.Get("private_key").(string), d.Get("private_ip").(string), bastionHost)
+}
+
+// sshConfig returns the SSH settings to reach server through bastionHost.
+// When privateKey is empty the SSH agent is used.
+func sshConfig(user, privateKey, server, bastionHost string) *easyssh.MakeConfig {
 	ssh := &easyssh.MakeConfig{
 		User:   user,
-		Server: privateIP,
+		Server: server,
 		Port:   "22",
 		Proxy:  http.ProxyFromEnvironment,
 		Bastion: easyssh.DefaultConfig{
@@ -773,35 +746,130 @@ func resourceContainerHostUpdate(_ context.Context, d *schema.ResourceData, m in
 		},
 	}
 	if privateKey != "" {
-		if agent {
-			return diag.FromErr(fmt.Errorf("'agent' is enabled so not expecting a private key to be set"))
-		}
 		ssh.Key = privateKey
 		ssh.Bastion.Key = privateKey
 	}
-	if d.HasChange("file") {
-		createFiles, diags := collectFilesToCreate(d)
-		if len(diags) > 0 {
-			return diags
-		}
-		_, _ = c.Debug("about to copy %d files to remote\n", len(createFiles))
-		if err := copyFiles(ssh, c, createFiles); err != nil {
-			return diag.FromErr(fmt.Errorf("copying files to remote: %w", err))
-		}
-		if commandsAfterFileChanges {
-			commands, diags := tools.CollectList(commandsField, d)
-			if len(diags) > 0 {
-				return diags
-			}
-			// Run commands
-			stdout, errDiags, err := runCommands(commands, ssh, m)
-			if err != nil {
-				return errDiags
+	return ssh
+}
+
+// refreshFileHashes replaces file_hashes with the hashes of the files on
+// the instance, so changes made outside Terraform show up in the plan. It
+// is skipped when the instance cannot be reached.
+func refreshFileHashes(d *schema.ResourceData, c *config.Config, bastionHost string) {
+	known := d.Get(fileHashesField).(map[string]interface{})
+	files, diags := expandFileBlocks(d.Get(fileField).(*schema.Set).List())
+	if len(known) == 0 && len(files) == 0 {
+		return
+	}
+	if d.Get("user").(string) == "" || (d.Get("private_key").(string) == "" && !d.Get("agent").(bool)) {
+		return
+	}
+	paths := make([]string, 0, len(known)+len(files))
+	for p := range known {
+		paths = append(paths, p)
+	}
+	if len(diags) == 0 {
+		for _, f := range files {
+			if _, ok := known[f.Destination]; !ok {
+				paths = append(paths, f.Destination)
 			}
-			_ = d.Set("result", stdout)
 		}
 	}
-	return diags
+	ssh := containerHostSSH(d, bastionHost)
+	hostKey, err := pinHostKeys(ssh, c, d.Get(hostKeyField).(string), 0)
+	if err != nil {
+		log.Printf("[WARN] unable to check remote files: %v", err)
+		return
+	}
+	_ = d.Set(hostKeyField, hostKey)
+	remote, err := remoteFileHashes(ssh, paths)
+	if err != nil {
+		log.Printf("[WARN] unable to check remote files: %v", err)
+		return
+	}
+	hashes := make(map[string]interface{}, len(remote))
+	for p, h := range remote {
+		hashes[p] = h
+	}
+	_ = d.Set(fileHashesField, hashes)
+}
+
+// stopInstance stops the instance and waits until it is stopped
+func stopInstance(ctx context.Context, client *cartel.Client, tagName string, timeout time.Duration) error {
+	if _, _, err := client.Stop(tagName); err != nil {
+		return fmt.Errorf("stopping '%s': %w", tagName, err)
+	}
+	stopConf := &resource.StateChangeConf{
+		Pending:    []string{"running", "pending", "stopping"},
+		Target:     []string{"stopped"},
+		Refresh:    instanceRunStateRefreshFunc(client, tagName),
+		Timeout:    timeout,
+		MinTimeout: 3 * time.Second,
+	}
+	if _, err := stopConf.WaitForStateContext(ctx); err != nil {
+		return fmt.Errorf("error waiting for instance '%s' to stop: %w", tagName, err)
+	}
+	return nil
+}
+
+// startInstance starts the instance and waits until it runs and its
+// deployment is back at succeeded
+func startInstance(ctx context.Context, client *cartel.Client, tagName string, timeout time.Duration) error {
+	if _, _, err := client.Start(tagName); err != nil {
+		return fmt.Errorf("starting '%s': %w", tagName, err)
+	}
+	return waitForRunning(ctx, client, tagName, timeout)
+}
+
+// rebootInstance reboots the running instance by stopping and starting it,
+// as Cartel has no reboot call, and waits until it is back
+func rebootInstance(ctx context.Context, client *cartel.Client, tagName string, timeout time.Duration) error {
+	if err := stopInstance(ctx, client, tagName, timeout); err != nil {
+		return fmt.Errorf("rebooting '%s': %w", tagName, err)
+	}
+	return startInstance(ctx, client, tagName, timeout)
+}
+
+func waitForRunning(ctx context.Context, client *cartel.Client, tagName string, timeout time.Duration) error {
+	startConf := &resource.StateChangeConf{
+		Pending:    []string{"stopped", "pending", "stopping"},
+		Target:     []string{"running"},
+		Refresh:    instanceRunStateRefreshFunc(client, tagName),
+		Timeout:    timeout,
+		MinTimeout: 3 * time.Second,
+	}
+	if _, err := startConf.WaitForStateContext(ctx); err != nil {
+		return fmt.Errorf("error waiting for instance '%s' to start: %w", tagName, err)
+	}
+	deployConf := &resource.StateChangeConf{
+		Pending:    []string{"provisioning", "indeterminate"},
+		Target:     []string{"succeeded"},
+		Refresh:    InstanceStateRefreshFunc(client, tagName, []string{"failed", "terminated", "shutting-down"}),
+		Timeout:    timeout,
+		MinTimeout: 3 * time.Second,
+	}
+	if _, err := deployConf.WaitForStateContext(ctx); err != nil {
+		return fmt.Errorf("error waiting for instance '%s' to become ready: %w", tagName, err)
+	}
+	return nil
+}
+
+// instanceRunStateRefreshFunc reports the EC2 state of the instance, e.g.
+// running or stopped, unlike InstanceStateRefreshFunc which reports the
+// deployment state
+func instanceRunStateRefreshFunc(client *cartel.Client, nameTag string) resource.StateRefreshFunc {
+	return func() (interface{}, string, error) {
+		details, _, err := client.GetDetails(nameTag)
+		if err != nil {
+			log.Printf("Error on instanceRunStateRefresh: %s", err)
+			return nil, "", err
+		}
+		return details, details.State, nil
+	}
+}
+
+func isStopped(state string) bool {
+	return state == "stopped" || state == "stopping"
 }
 
 func resourceContainerHostRead(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -842,18 +910,23 @@ func resourceContainerHostRead(_ context.Context, d *schema.ResourceData, m inte
 		}
 		return diag.FromErr(err)
 	}
-	if state != "succeeded" {
-		// Unless we have a succeeded deploy, taint the resource
+	ch, _, err := client.GetDetails(tagName)
+	if state != "succeeded" && (err != nil || !isStopped(ch.State)) {
+		// Unless we have a succeeded deploy or a stopped instance, taint the resource
 		d.SetId("")
 		return diags
 	}
-	ch, _, err := client.GetDetails(tagName)
 	if err != nil {
 		return diag.FromErr(err)
 	}
 	if ch.InstanceID != d.Id() {
 		return diag.FromErr(config.ErrInstanceIDMismatch)
 	}
+	powerState := powerStateRunning
+	if isStopped(ch.State) {
+		powerState = powerStateStopped
+	}
+	_ = d.Set("power_state", powerState)
 	_ = d.Set("protect", ch.Protection)
 	_ = d.Set("volumes", len(ch.BlockDevices)-1) // -1 for the root volume
 	_ = d.Set("role", ch.Role)
@@ -874,7 +947,18 @@ func resourceContainerHostRead(_ context.Context, d *schema.ResourceData, m inte
 		subnetType = "public"
 	}
 	_ = d.Set("subnet_type", subnetType)
-	_ = d.Set("tags", normalizeTags(ch.Tags))
+	allTags := normalizeTags(ch.Tags)
+	configuredTags := tools.ExpandStringMap(d.Get("tags").(map[string]interface{}))
+	_ = d.Set("tags", c.ResourceTags(allTags, configuredTags))
+	_ = d.Set("tags_all", allTags)
+	// Checking the files takes an SSH session per refresh, so it is opt-in
+	if powerState == powerStateRunning && d.Get(detectFileDriftField).(bool) {
+		bastionHost := d.Get("bastion_host").(string)
+		if bastionHost == "" {
+			bastionHost = client.BastionHost()
+		}
+		refreshFileHashes(d, c, bastionHost)
+	}
 
 	return diags
 }
@@ -907,6 +991,80 @@ func resourceContainerHostDelete(_ context.Context, d *schema.ResourceData, m in
 
 }
 
+// customizeContainerHostDiff plans tags_all, the tags of the resource merged
+// with the provider default tags, and file_hashes
+func customizeContainerHostDiff(_ context.Context, d *schema.ResourceDiff, m interface{}) error {
+	c := m.(*config.Config)
+
+	if configKnown(d, "tags") {
+		if err := planTagsAll(d, c); err != nil {
+			return err
+		}
+	} else if err := d.SetNewComputed("tags_all"); err != nil {
+		return err
+	}
+	if err := checkSecurityGroups(d, c); err != nil {
+		return err
+	}
+	return customizeFileHashes(d)
+}
+
+// configKnown reports whether key is wholly known during plan. The SDK reads
+// an unknown map as an empty one, so the raw config is checked first.
+func configKnown(d *schema.ResourceDiff, key string) bool {
+	if raw := d.GetRawConfig(); !raw.IsNull() && raw.IsKnown() && raw.Type().HasAttribute(key) {
+		if !raw.GetAttr(key).IsWhollyKnown() {
+			return false
+		}
+	}
+	return d.NewValueKnown(key)
+}
+
+// checkSecurityGroups fails the plan when a changed security_groups refers
+// to a group which does not exist, instead of after provisioning
+func checkSecurityGroups(d *schema.ResourceDiff, c *config.Config) error {
+	if !d.HasChange("security_groups") || !configKnown(d, "security_groups") {
+		return nil
+	}
+	names := tools.ExpandStringList(d.Get("security_groups").(*schema.Set).List())
+	if len(names) == 0 {
+		return nil
+	}
+	groups, err := c.CartelSecurityGroups()
+	if err != nil {
+		return fmt.Errorf("checking security_groups: %w", err)
+	}
+	return checkGroupNames("security group", names, groups)
+}
+
+// planTagsAll plans tags_all from the known tags and the provider default
+// tags. Cartel supports at most 8 tags.
+func planTagsAll(d *schema.ResourceDiff, c *config.Config) error {
+	tags := normalizeTags(c.MergeTags(tools.ExpandStringMap(d.Get("tags").(map[string]interface{}))))
+	if len(tags) > 8 {
+		return fmt.Errorf("maximum of 8 tags are supported, including %d default tags", len(c.DefaultTags))
+	}
+	allTags := make(map[string]interface{}, len(tags))
+	for k, v := range tags {
+		allTags[k] = v
+	}
+	return d.SetNew("tags_all", allTags)
+}
+
+// customizeFileHashes plans file_hashes from the local files, so files which
+// changed on either side are synced
+func customizeFileHashes(d *schema.ResourceDiff) error {
+	if !d.NewValueKnown(fileField) {
+		return d.SetNewComputed(fileHashesField)
+	}
+	files, diags := expandFileBlocks(d.Get(fileField).(*schema.Set).List())
+	if len(diags) > 0 {
+		// Sources may be created during apply
+		return d.SetNewComputed(fileHashesField)
+	}
+	return d.SetNew(fileHashesField, fileHashes(files))
+}
+
 func normalizeTags(tags map[string]string) map[string]string {
 	normalized := make(map[string]string)
 	for k, v := range tags {
@@ -937,33 +1095,3 @@ func generateTagChange(old, new interface{}) map[string]string {
 	}
 	return change
 }
-
-func runCommands(commands []string, ssh *easyssh.MakeConfig, m interface{}) (string, diag.Diagnostics, error) {
-	var diags diag.Diagnostics
-	var stdout, stderr string
-	var done bool
-	var err error
-	c := m.(*config.Config)
-
-	for i := 0; i < len(commands); i++ {
-		stdout, stderr, done, err = ssh.Run(commands[i], 5*time.Minute)
-		_, _ = c.Debug("command: %s\ndone: %t\nstdout:\n%s\nstderr:\n%s\n", commands[i], done, stdout, stderr)
-		if err != nil {
-			_, _ = c.Debug("error: %v\n", err)
-			diags = append(diags, diag.Diagnostic{
-				Severity: diag.Error,
-				Summary:  fmt.Sprintf("execution of command '%s' failed. stdout output", commands[i]),
-				Detail:   stdout,
-			})
-			if stderr != "" {
-				diags = append(diags, diag.Diagnostic{
-					Severity: diag.Error,
-					Summary:  "stderr output",
-					Detail:   stderr,
-				})
-			}
-			return stdout, diags, err
-		}
-	}
-	return stdout, diags, nil
-}
@@ -4,23 +4,37 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
-	"net/http"
+	"sort"
+	"sync"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	"github.com/loafoe/easyssh-proxy/v2"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"github.com/philips-software/terraform-provider-hsdp/internal/config"
 	"github.com/philips-software/terraform-provider-hsdp/internal/tools"
 )
 
+const (
+	whenCreate  = "create"
+	whenDestroy = "destroy"
+	whenAlways  = "always"
+
+	hostResultsField = "host_results"
+	failedHostsField = "failed_hosts"
+
+	defaultExecParallelism = 10
+)
+
 func ResourceContainerHostExec() *schema.Resource {
 	return &schema.Resource{
-		Description: `The ` + "`hsdp_container_host_exec`" + ` resource implements the standard resource lifecycle but takes no further action.
+		Description: `The ` + "`hsdp_container_host_exec`" + ` resource copies files and runs commands on one or more container hosts.
 The ` + "`triggers`" + ` argument allows specifying an arbitrary set of values that, when changed, will cause the resource to be replaced.`,
 
 		CreateContext: resourceContainerHostExecCreate,
 		Read:          resourceContainerHostExecRead,
-		Delete:        resourceContainerHostExecDelete,
+		UpdateContext: resourceContainerHostExecUpdate,
+		DeleteContext: resourceContainerHostExecDelete,
+		CustomizeDiff: customizeContainerHostExecDiff,
 		SchemaVersion: 2,
 
 		Schema: map[string]*schema.Schema{
@@ -31,9 +45,35 @@ The ` + "`triggers`" + ` argument allows specifying an arbitrary set of values t
 				ForceNew:    true,
 			},
 			"host": {
-				Type:     schema.TypeString,
-				Required: true,
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				ExactlyOneOf: []string{"host", "hosts"},
+			},
+			"hosts": {
+				Type:     schema.TypeList,
+				Optional: true,
 				ForceNew: true,
+				MinItems: 1,
+				Elem:     tools.StringSchema(),
+			},
+			"when": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      whenCreate,
+				ValidateFunc: validation.StringInSlice([]string{whenCreate, whenDestroy, whenAlways}, false),
+			},
+			"parallelism": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      defaultExecParallelism,
+				ValidateFunc: validation.IntBetween(1, 50),
+			},
+			"max_failures": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
 			},
 			"bastion_host": {
 				Type:     schema.TypeString,
@@ -68,75 +108,123 @@ The ` + "`triggers`" + ` argument allows specifying an arbitrary set of values t
 				Elem:     &schema.Schema{Type: schema.TypeString},
 				ForceNew: true,
 			},
+			commandField:        commandSchema(true),
+			commandResultsField: commandResultsSchema(),
+			hostResultsField: {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     tools.StringSchema(),
+			},
+			hostKeysField: {
+				Description: "The SHA256 host key fingerprints of the hosts. Keys of hosts missing from the map are captured on first connect.",
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Computed:    true,
+				Elem:        tools.StringSchema(),
+			},
+			failedHostsField: {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem:     tools.StringSchema(),
+			},
 			fileField: {
 				Type:     schema.TypeSet,
 				Optional: true,
 				ForceNew: true,
-				Elem: &schema.Resource{
-					Schema: map[string]*schema.Schema{
-						"source": {
-							Type:     schema.TypeString,
-							Optional: true,
-							ForceNew: true,
-						},
-						"content": {
-							Type:     schema.TypeString,
-							Optional: true,
-							ForceNew: true,
-						},
-						"destination": {
-							Type:     schema.TypeString,
-							Required: true,
-							ForceNew: true,
-						},
-						"permissions": {
-							Type:     schema.TypeString,
-							Optional: true,
-						},
-						"owner": {
-							Type:     schema.TypeString,
-							Optional: true,
-						},
-						"group": {
-							Type:     schema.TypeString,
-							Optional: true,
-						},
-					},
-				},
+				Elem:     fileFieldSchema(true),
 			},
 		},
 	}
 }
 
+// hostRun is the outcome of provisioning a single host
+type hostRun struct {
+	HostKey string
+	Results []commandResult
+	Diags   diag.Diagnostics
+}
+
+// customizeContainerHostExecDiff plans a new run on every apply when 'when'
+// is always, by marking the results as unknown
+func customizeContainerHostExecDiff(_ context.Context, d *schema.ResourceDiff, _ interface{}) error {
+	if d.Id() == "" || d.Get("when").(string) != whenAlways {
+		return nil
+	}
+	for _, field := range []string{"result", commandResultsField, hostResultsField, failedHostsField} {
+		if err := d.SetNewComputed(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func resourceContainerHostExecCreate(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	c := m.(*config.Config)
-	client, err := c.CartelClient()
-	if err != nil {
-		return diag.FromErr(err)
+
+	var diags diag.Diagnostics
+	if d.Get("when").(string) != whenDestroy {
+		diags = execOnContainerHosts(d, c)
+		if diags.HasError() {
+			return diags
+		}
+	}
+	d.SetId(fmt.Sprintf("%d", rand.Int()))
+	return diags
+}
+
+func resourceContainerHostExecRead(_ *schema.ResourceData, _ interface{}) error {
+	return nil
+}
+
+func resourceContainerHostExecUpdate(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	c := m.(*config.Config)
+
+	if d.Get("when").(string) != whenAlways {
+		return nil
 	}
+	return execOnContainerHosts(d, c)
+}
+
+func resourceContainerHostExecDelete(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	c := m.(*config.Config)
 
 	var diags diag.Diagnostics
+	if d.Get("when").(string) == whenDestroy {
+		diags = execOnContainerHosts(d, c)
+		if diags.HasError() {
+			return diags
+		}
+	}
+	d.SetId("")
+	return diags
+}
 
+// execOnContainerHosts provisions the files and runs the commands on all
+// configured hosts and records the outcome in d
+func execOnContainerHosts(d *schema.ResourceData, c *config.Config) diag.Diagnostics {
+	client, err := c.CartelClient()
+	if err != nil {
+		return diag.FromErr(err)
+	}
 	bastionHost := d.Get("bastion_host").(string)
 	if bastionHost == "" {
 		bastionHost = client.BastionHost()
 	}
 	user := d.Get("user").(string)
 	privateKey := d.Get("private_key").(string)
-	host := d.Get("host").(string)
 	agent := d.Get("agent").(bool)
 
 	// Fetch files first before starting provisioning
-	createFiles, diags := collectFilesToCreate(d)
+	files, diags := expandFileBlocks(d.Get(fileField).(*schema.Set).List())
 	if len(diags) > 0 {
 		return diags
 	}
 	// And commands
-	commands, diags := tools.CollectList(commandsField, d)
+	commands, diags := collectCommands(d)
 	if len(diags) > 0 {
 		return diags
 	}
-	if len(commands) > 0 || len(createFiles) > 0 {
+	if len(commands) > 0 || len(files) > 0 {
 		if user == "" {
 			return diag.FromErr(fmt.Errorf("user must be set when '%s' is specified", commandsField))
 		}
@@ -144,52 +232,118 @@ func resourceContainerHostExecCreate(_ context.Context, d *schema.ResourceData,
 			return diag.FromErr(fmt.Errorf("no SSH 'private_key' was set and 'agent' is 'false', authentication will fail after provisioning step"))
 		}
 	}
-	// Collect SSH details
-	privateIP := host
-	ssh := &easyssh.MakeConfig{
-		User:   user,
-		Server: privateIP,
-		Port:   "22",
-		Proxy:  http.ProxyFromEnvironment,
-		Bastion: easyssh.DefaultConfig{
-			User:   user,
-			Server: bastionHost,
-			Port:   "22",
-		},
-	}
-	if privateKey != "" {
-		if agent {
-			return diag.FromErr(fmt.Errorf("'agent' is enabled so not expecting a private key to be set"))
-		}
-		ssh.Key = privateKey
-		ssh.Bastion.Key = privateKey
+	if privateKey != "" && agent {
+		return diag.FromErr(fmt.Errorf("'agent' is enabled so not expecting a private key to be set"))
 	}
 
-	// Provision files
-	if err := copyFiles(ssh, c, createFiles); err != nil {
-		return diag.FromErr(fmt.Errorf("copying files to remote: %w", err))
+	hosts := tools.ExpandStringList(d.Get("hosts").([]interface{}))
+	if host := d.Get("host").(string); host != "" {
+		hosts = []string{host}
 	}
+	knownKeys := tools.ExpandStringMap(d.Get(hostKeysField).(map[string]interface{}))
+	runs := execOnHosts(hosts, d.Get("parallelism").(int), func(host string) hostRun {
+		ssh := sshConfig(user, privateKey, host, bastionHost)
 
-	// Ensure ready-ness
-	if err := ensureContainerHostReady(ssh, c); err != nil {
-		return diag.FromErr(fmt.Errorf("container host ready-ness check failed: %w", err))
-	}
+		// Verify the host key, or capture it on first connect
+		hostKey, err := pinHostKeys(ssh, c, knownKeys[host], 10)
+		if err != nil {
+			return hostRun{Diags: diag.FromErr(err)}
+		}
+		// Provision files
+		if err := pushFiles(ssh, c, files, nil, nil); err != nil {
+			return hostRun{HostKey: hostKey, Diags: diag.FromErr(fmt.Errorf("copying files to remote: %w", err))}
+		}
+		// Ensure ready-ness
+		if err := ensureContainerHostReady(ssh, c); err != nil {
+			return hostRun{HostKey: hostKey, Diags: diag.FromErr(fmt.Errorf("container host ready-ness check failed: %w", err))}
+		}
+		// Run commands
+		results, runDiags := runRemoteCommands(ssh, host, commands)
+		return hostRun{HostKey: hostKey, Results: results, Diags: runDiags}
+	})
 
-	// Run commands
-	stdout, errDiags, err := runCommands(commands, ssh, m)
-	if err != nil {
-		return errDiags
+	hostResults := make(map[string]interface{}, len(runs))
+	hostKeys := make(map[string]interface{}, len(runs))
+	for host, key := range knownKeys {
+		hostKeys[host] = key
+	}
+	for host, run := range runs {
+		hostResults[host] = lastStdout(run.Results)
+		if run.HostKey != "" {
+			hostKeys[host] = run.HostKey
+		}
+	}
+	failed, diags := summarizeHostRuns(runs, d.Get("max_failures").(int))
+	_ = d.Set(hostResultsField, hostResults)
+	_ = d.Set(hostKeysField, hostKeys)
+	_ = d.Set(failedHostsField, failed)
+	if len(hosts) == 1 {
+		results := runs[hosts[0]].Results
+		_ = d.Set("result", lastStdout(results))
+		_ = d.Set(commandResultsField, flattenCommandResults(results))
 	}
-	_ = d.Set("result", stdout)
-	d.SetId(fmt.Sprintf("%d", rand.Int()))
 	return diags
 }
 
-func resourceContainerHostExecRead(_ *schema.ResourceData, _ interface{}) error {
-	return nil
+// execOnHosts calls run for each host, with at most parallelism calls
+// running at the same time
+func execOnHosts(hosts []string, parallelism int, run func(host string) hostRun) map[string]hostRun {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	runs := make(map[string]hostRun, len(hosts))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallelism)
+	for _, host := range hosts {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(host string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			outcome := run(host)
+			mu.Lock()
+			runs[host] = outcome
+			mu.Unlock()
+		}(host)
+	}
+	wg.Wait()
+	return runs
 }
 
-func resourceContainerHostExecDelete(d *schema.ResourceData, _ interface{}) error {
-	d.SetId("")
-	return nil
+// summarizeHostRuns returns the sorted list of hosts which failed and the
+// diagnostics of all runs, prefixed with their host. When no more than
+// maxFailures hosts failed their errors are reported as warnings.
+func summarizeHostRuns(runs map[string]hostRun, maxFailures int) ([]string, diag.Diagnostics) {
+	hosts := make([]string, 0, len(runs))
+	failed := make([]string, 0)
+	for host, run := range runs {
+		hosts = append(hosts, host)
+		if run.Diags.HasError() {
+			failed = append(failed, host)
+		}
+	}
+	sort.Strings(hosts)
+	sort.Strings(failed)
+
+	tolerated := len(failed) <= maxFailures
+	var diags diag.Diagnostics
+	for _, host := range hosts {
+		for _, d := range runs[host].Diags {
+			d.Summary = fmt.Sprintf("%s: %s", host, d.Summary)
+			if tolerated {
+				d.Severity = diag.Warning
+			}
+			diags = append(diags, d)
+		}
+	}
+	if !tolerated && len(runs) > 1 {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("%d of %d hosts failed, at most %d allowed", len(failed), len(runs), maxFailures),
+		})
+	}
+	return failed, diags
 }
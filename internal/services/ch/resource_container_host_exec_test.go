@@ -0,0 +1,50 @@
+package ch
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecOnHosts(t *testing.T) {
+	var running, peak int32
+	hosts := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"}
+
+	runs := execOnHosts(hosts, 2, func(host string) hostRun {
+		now := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if now <= old || atomic.CompareAndSwapInt32(&peak, old, now) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return hostRun{Results: []commandResult{{Stdout: host}}}
+	})
+	assert.Len(t, runs, len(hosts))
+	assert.LessOrEqual(t, peak, int32(2))
+	assert.Equal(t, "10.0.0.3", lastStdout(runs["10.0.0.3"].Results))
+}
+
+func TestSummarizeHostRuns(t *testing.T) {
+	runs := map[string]hostRun{
+		"a": {},
+		"b": {Diags: diag.FromErr(errors.New("boom"))},
+		"c": {Diags: diag.FromErr(errors.New("bang"))},
+	}
+	failed, diags := summarizeHostRuns(runs, 2)
+	assert.Equal(t, []string{"b", "c"}, failed)
+	assert.False(t, diags.HasError())
+	if assert.Len(t, diags, 2) {
+		assert.Equal(t, "b: boom", diags[0].Summary)
+	}
+
+	_, diags = summarizeHostRuns(runs, 1)
+	assert.True(t, diags.HasError())
+	assert.Len(t, diags, 3)
+}
@@ -0,0 +1,667 @@
+package ch
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/philips-software/go-hsdp-api/cartel"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+	"github.com/philips-software/terraform-provider-hsdp/internal/tools"
+)
+
+const (
+	launchConfigHashField = "launch_config_hash"
+	healthCheckField      = "health_check"
+
+	privateIPPlaceholder = "{private_ip}"
+)
+
+// groupPollDelay is the delay before the deployment state of a new group
+// member is first checked
+var groupPollDelay = 10 * time.Second
+
+// launchSettings are the settings of hsdp_container_host_group which only
+// apply when an instance is created. Changing them replaces the members.
+var launchSettings = []string{
+	"instance_role", "image", "instance_type", "volume_type", "iops",
+	"encrypt_volumes", "volumes", "volume_size", "subnet_type",
+}
+
+// groupMember is an instance of a container host group
+type groupMember struct {
+	ID               string
+	Name             string
+	PrivateIP        string
+	PublicIP         string
+	LaunchConfigHash string
+}
+
+func ResourceContainerHostGroup() *schema.Resource {
+	return &schema.Resource{
+		Description: `The ` + "`hsdp_container_host_group`" + ` resource manages a group of identical container hosts.
+Changes to the launch settings replace the members in rolling fashion.`,
+
+		CreateContext: resourceContainerHostGroupCreate,
+		ReadContext:   resourceContainerHostGroupRead,
+		UpdateContext: resourceContainerHostGroupUpdate,
+		DeleteContext: resourceContainerHostGroupDelete,
+		CustomizeDiff: customizeContainerHostGroupDiff,
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(60 * time.Minute),
+			Update: schema.DefaultTimeout(60 * time.Minute),
+			Delete: schema.DefaultTimeout(30 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name_prefix": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"size": {
+				Type:         schema.TypeInt,
+				Required:     true,
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"max_unavailable": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      1,
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"max_surge": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"instance_role": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "container-host",
+			},
+			"image": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"instance_type": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "m5.large",
+			},
+			"volume_type": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"iops"},
+			},
+			"iops": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ValidateFunc: validation.IntBetween(1, 4000),
+			},
+			"encrypt_volumes": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"volumes": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntBetween(0, 6),
+			},
+			"volume_size": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntBetween(0, 16000),
+			},
+			"subnet_type": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "private",
+				ValidateFunc: validation.StringInSlice([]string{"private", "public"}, false),
+			},
+			"security_groups": {
+				Type:     schema.TypeSet,
+				MaxItems: 4,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"user_groups": {
+				Type:     schema.TypeSet,
+				MaxItems: 50,
+				Optional: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			healthCheckField: {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"http_url": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"expected_status": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      200,
+							ValidateFunc: validation.IntBetween(100, 599),
+						},
+						"timeout": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      defaultReadinessTimeout.String(),
+							ValidateFunc: validateDuration,
+						},
+					},
+				},
+			},
+			"tags": tagsSchema(),
+			"tags_all": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			launchConfigHashField: {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"instances": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"name": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"private_ip": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"public_ip": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						launchConfigHashField: {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+			},
+			"instance_ids": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"private_ips": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+		},
+	}
+}
+
+// launchConfigHash returns the hash of the launch settings, which members
+// are compared against to find those in need of replacement
+func launchConfigHash(get func(string) interface{}) string {
+	settings := make(map[string]interface{}, len(launchSettings))
+	for _, k := range launchSettings {
+		settings[k] = get(k)
+	}
+	data, _ := json.Marshal(settings)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func customizeContainerHostGroupDiff(_ context.Context, d *schema.ResourceDiff, m interface{}) error {
+	c := m.(*config.Config)
+
+	if d.Get("max_unavailable").(int)+d.Get("max_surge").(int) < 1 {
+		return fmt.Errorf("at least one of 'max_unavailable' or 'max_surge' must be greater than zero")
+	}
+	if configKnown(d, "tags") {
+		if err := planTagsAll(d, c); err != nil {
+			return err
+		}
+	} else if err := d.SetNewComputed("tags_all"); err != nil {
+		return err
+	}
+	known := true
+	for _, k := range launchSettings {
+		known = known && d.NewValueKnown(k)
+	}
+	if known {
+		hash := launchConfigHash(d.Get)
+		members := d.Get("instances").([]interface{})
+		inSync := len(members) == d.Get("size").(int)
+		for _, raw := range members {
+			if raw.(map[string]interface{})[launchConfigHashField] != hash {
+				inSync = false
+			}
+		}
+		if inSync {
+			return nil
+		}
+		if err := d.SetNew(launchConfigHashField, hash); err != nil {
+			return err
+		}
+	} else if err := d.SetNewComputed(launchConfigHashField); err != nil {
+		return err
+	}
+	// Members are added, removed or replaced
+	for _, k := range []string{"instances", "instance_ids", "private_ips"} {
+		if err := d.SetNewComputed(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func resourceContainerHostGroupCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	c := m.(*config.Config)
+	client, err := c.CartelClient()
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.SetId(d.Get("name_prefix").(string))
+	_ = d.Set(launchConfigHashField, launchConfigHash(d.Get))
+	_ = d.Set("instances", []interface{}{})
+
+	diags := reconcileContainerHostGroup(ctx, d, c, client, d.Timeout(schema.TimeoutCreate))
+	if diags.HasError() {
+		return diags
+	}
+	return append(diags, resourceContainerHostGroupRead(ctx, d, m)...)
+}
+
+func resourceContainerHostGroupRead(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	c := m.(*config.Config)
+	client, err := c.CartelClient()
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	members := expandGroupMembers(d)
+	present := make([]groupMember, 0, len(members))
+	var allTags map[string]string
+	for _, member := range members {
+		details, _, err := client.GetDetails(member.Name)
+		if err != nil {
+			if findInstanceByName(client, member.Name) == nil {
+				log.Printf("[WARN] container host group %s: member %s is gone", d.Id(), member.Name)
+				continue
+			}
+			return diag.FromErr(err)
+		}
+		member.ID = details.InstanceID
+		member.PrivateIP = details.PrivateAddress
+		member.PublicIP = details.PublicAddress
+		present = append(present, member)
+		if allTags == nil {
+			allTags = normalizeTags(details.Tags)
+		}
+	}
+	setGroupMembers(d, present)
+	if allTags != nil {
+		configuredTags := tools.ExpandStringMap(d.Get("tags").(map[string]interface{}))
+		_ = d.Set("tags", c.ResourceTags(allTags, configuredTags))
+		_ = d.Set("tags_all", allTags)
+	}
+	return nil
+}
+
+func resourceContainerHostGroupUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	c := m.(*config.Config)
+	client, err := c.CartelClient()
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// Settings which are changed in place on all current members
+	names := make([]string, 0)
+	for _, member := range expandGroupMembers(d) {
+		names = append(names, member.Name)
+	}
+	if len(names) > 0 {
+		if err := updateGroupMembers(d, client, names); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	diags := reconcileContainerHostGroup(ctx, d, c, client, d.Timeout(schema.TimeoutUpdate))
+	if diags.HasError() {
+		return diags
+	}
+	return append(diags, resourceContainerHostGroupRead(ctx, d, m)...)
+}
+
+func resourceContainerHostGroupDelete(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	c := m.(*config.Config)
+	client, err := c.CartelClient()
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	members := expandGroupMembers(d)
+	remaining, err := destroyGroupMembers(client, members, members)
+	setGroupMembers(d, remaining)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.SetId("")
+	return nil
+}
+
+// updateGroupMembers applies changes to the security groups, user groups
+// and tags to the named members
+func updateGroupMembers(d *schema.ResourceData, client *cartel.Client, names []string) error {
+	if d.HasChange("security_groups") {
+		o, n := d.GetChange("security_groups")
+		old := tools.ExpandStringList(o.(*schema.Set).List())
+		newEntries := tools.ExpandStringList(n.(*schema.Set).List())
+		if toRemove := tools.Difference(old, newEntries); len(toRemove) > 0 {
+			if _, _, err := client.RemoveSecurityGroups(names, toRemove); err != nil {
+				return err
+			}
+		}
+		if toAdd := tools.Difference(newEntries, old); len(toAdd) > 0 {
+			if _, _, err := client.AddSecurityGroups(names, toAdd); err != nil {
+				return err
+			}
+		}
+	}
+	if d.HasChange("user_groups") {
+		o, n := d.GetChange("user_groups")
+		old := tools.ExpandStringList(o.(*schema.Set).List())
+		newEntries := tools.ExpandStringList(n.(*schema.Set).List())
+		if toRemove := tools.Difference(old, newEntries); len(toRemove) > 0 {
+			if _, _, err := client.RemoveUserGroups(names, toRemove); err != nil {
+				return err
+			}
+		}
+		if toAdd := tools.Difference(newEntries, old); len(toAdd) > 0 {
+			if _, _, err := client.AddUserGroups(names, toAdd); err != nil {
+				return err
+			}
+		}
+	}
+	if d.HasChange("tags_all") {
+		o, n := d.GetChange("tags_all")
+		if _, _, err := client.AddTags(names, generateTagChange(o, n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconcileContainerHostGroup scales the group to its size and replaces
+// members with an outdated launch configuration. At most max_unavailable
+// members are missing and at most max_surge members are added while
+// replacing. Replacement stops at the first member which does not become
+// healthy. The members are saved in d after every step.
+func reconcileContainerHostGroup(ctx context.Context, d *schema.ResourceData, c *config.Config, client *cartel.Client, timeout time.Duration) diag.Diagnostics {
+	hash := launchConfigHash(d.Get)
+	_ = d.Set(launchConfigHashField, hash)
+	size := d.Get("size").(int)
+	maxUnavailable := d.Get("max_unavailable").(int)
+	maxSurge := d.Get("max_surge").(int)
+	if maxUnavailable+maxSurge < 1 {
+		return diag.FromErr(fmt.Errorf("at least one of 'max_unavailable' or 'max_surge' must be greater than zero"))
+	}
+	deadline := time.Now().Add(timeout)
+	members := expandGroupMembers(d)
+
+	// Scale down, removing outdated members first
+	if len(members) > size {
+		ordered := append(filterMembers(members, hash, false), filterMembers(members, hash, true)...)
+		remaining, err := destroyGroupMembers(client, members, ordered[size:])
+		members = remaining
+		setGroupMembers(d, members)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	// Rolling replacement
+	for {
+		outdated := filterMembers(members, hash, true)
+		if len(outdated) == 0 {
+			break
+		}
+		batch := minInt(len(outdated), maxUnavailable+maxSurge)
+		first := minInt(batch, maxUnavailable)
+		log.Printf("[INFO] container host group %s: replacing %d of %d outdated members", d.Id(), batch, len(outdated))
+
+		remaining, err := destroyGroupMembers(client, members, outdated[:first])
+		members = remaining
+		setGroupMembers(d, members)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		created, err := createGroupMembers(ctx, d, c, client, batch, hash, time.Until(deadline))
+		members = append(members, created...)
+		setGroupMembers(d, members)
+		if err != nil {
+			return diag.FromErr(fmt.Errorf("rolling replacement halted: %w", err))
+		}
+		remaining, err = destroyGroupMembers(client, members, outdated[first:batch])
+		members = remaining
+		setGroupMembers(d, members)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	// Scale up
+	if len(members) < size {
+		created, err := createGroupMembers(ctx, d, c, client, size-len(members), hash, time.Until(deadline))
+		members = append(members, created...)
+		setGroupMembers(d, members)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+	return nil
+}
+
+// createGroupMembers creates count members in parallel and waits for them
+// to become healthy. Members which fail are destroyed, those which succeed
+// are returned also when others failed.
+func createGroupMembers(ctx context.Context, d *schema.ResourceData, c *config.Config, client *cartel.Client, count int, hash string, timeout time.Duration) ([]groupMember, error) {
+	prefix := d.Get("name_prefix").(string)
+	opts := groupLaunchOptions(d, c)
+	healthCheck, err := expandHealthCheck(d)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	created := make([]groupMember, 0, count)
+	var errs []string
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			member, err := createGroupMember(ctx, client, prefix, opts, healthCheck, timeout)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err.Error())
+				return
+			}
+			member.LaunchConfigHash = hash
+			created = append(created, member)
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return created, fmt.Errorf("%d of %d members failed: %s", len(errs), count, strings.Join(errs, "; "))
+	}
+	return created, nil
+}
+
+func createGroupMember(ctx context.Context, client *cartel.Client, prefix string, opts []cartel.RequestOptionFunc, healthCheck *readinessCheck, timeout time.Duration) (groupMember, error) {
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix)
+	name := fmt.Sprintf("%s-%s", prefix, hex.EncodeToString(suffix))
+
+	ch, _, err := client.Create(name, opts...)
+	if err != nil {
+		if err != cartel.ErrHostnameAlreadyExists {
+			_, _, _ = client.Destroy(name)
+		}
+		return groupMember{}, fmt.Errorf("%s: %w", name, err)
+	}
+	member := groupMember{ID: ch.InstanceID(), Name: name, PrivateIP: ch.IPAddress()}
+
+	stateConf := &resource.StateChangeConf{
+		Pending:    []string{"provisioning", "indeterminate"},
+		Target:     []string{"succeeded"},
+		Refresh:    InstanceStateRefreshFunc(client, name, []string{"failed", "terminated", "shutting-down"}),
+		Timeout:    timeout,
+		Delay:      groupPollDelay,
+		MinTimeout: 3 * time.Second,
+	}
+	if _, err = stateConf.WaitForStateContext(ctx); err == nil && healthCheck != nil {
+		check := *healthCheck
+		check.HTTPURL = strings.ReplaceAll(check.HTTPURL, privateIPPlaceholder, member.PrivateIP)
+		err = waitForReadiness(ctx, nil, &check)
+	}
+	if err != nil {
+		_, _, _ = client.Destroy(name)
+		return groupMember{}, fmt.Errorf("%s did not become healthy: %w", name, err)
+	}
+	return member, nil
+}
+
+// destroyGroupMembers destroys remove and returns the members which remain
+func destroyGroupMembers(client *cartel.Client, members, remove []groupMember) ([]groupMember, error) {
+	removed := make(map[string]bool, len(remove))
+	var err error
+	for _, member := range remove {
+		if _, _, destroyErr := client.Destroy(member.Name); destroyErr != nil && findInstanceByName(client, member.Name) != nil {
+			err = fmt.Errorf("destroying %s: %w", member.Name, destroyErr)
+			break
+		}
+		removed[member.Name] = true
+	}
+	remaining := make([]groupMember, 0, len(members))
+	for _, member := range members {
+		if !removed[member.Name] {
+			remaining = append(remaining, member)
+		}
+	}
+	return remaining, err
+}
+
+func groupLaunchOptions(d *schema.ResourceData, c *config.Config) []cartel.RequestOptionFunc {
+	tags := c.MergeTags(tools.ExpandStringMap(d.Get("tags").(map[string]interface{})))
+	return []cartel.RequestOptionFunc{
+		cartel.SecurityGroups(tools.ExpandStringList(d.Get("security_groups").(*schema.Set).List())...),
+		cartel.UserGroups(tools.ExpandStringList(d.Get("user_groups").(*schema.Set).List())...),
+		cartel.VolumeType(d.Get("volume_type").(string)),
+		cartel.IOPs(d.Get("iops").(int)),
+		cartel.InstanceType(d.Get("instance_type").(string)),
+		cartel.VolumesAndSize(d.Get("volumes").(int), d.Get("volume_size").(int)),
+		cartel.VolumeEncryption(d.Get("encrypt_volumes").(bool)),
+		cartel.InstanceRole(d.Get("instance_role").(string)),
+		cartel.SubnetType(d.Get("subnet_type").(string)),
+		cartel.Tags(tags),
+		cartel.Image(d.Get("image").(string)),
+	}
+}
+
+func expandHealthCheck(d *schema.ResourceData) (*readinessCheck, error) {
+	list := d.Get(healthCheckField).([]interface{})
+	if len(list) == 0 || list[0] == nil {
+		return nil, nil
+	}
+	block := list[0].(map[string]interface{})
+	timeout, err := time.ParseDuration(block["timeout"].(string))
+	if err != nil {
+		return nil, fmt.Errorf("health_check: %w", err)
+	}
+	return &readinessCheck{
+		HTTPURL:        block["http_url"].(string),
+		ExpectedStatus: block["expected_status"].(int),
+		Timeout:        timeout,
+	}, nil
+}
+
+// filterMembers returns the members whose launch configuration differs
+// from hash when outdated is set, or matches it otherwise
+func filterMembers(members []groupMember, hash string, outdated bool) []groupMember {
+	filtered := make([]groupMember, 0, len(members))
+	for _, member := range members {
+		if (member.LaunchConfigHash != hash) == outdated {
+			filtered = append(filtered, member)
+		}
+	}
+	return filtered
+}
+
+func expandGroupMembers(d *schema.ResourceData) []groupMember {
+	list := d.Get("instances").([]interface{})
+	members := make([]groupMember, 0, len(list))
+	for _, raw := range list {
+		entry := raw.(map[string]interface{})
+		members = append(members, groupMember{
+			ID:               entry["id"].(string),
+			Name:             entry["name"].(string),
+			PrivateIP:        entry["private_ip"].(string),
+			PublicIP:         entry["public_ip"].(string),
+			LaunchConfigHash: entry[launchConfigHashField].(string),
+		})
+	}
+	return members
+}
+
+func setGroupMembers(d *schema.ResourceData, members []groupMember) {
+	instances := make([]interface{}, 0, len(members))
+	ids := make([]string, 0, len(members))
+	ips := make([]string, 0, len(members))
+	for _, member := range members {
+		instances = append(instances, map[string]interface{}{
+			"id":                  member.ID,
+			"name":                member.Name,
+			"private_ip":          member.PrivateIP,
+			"public_ip":           member.PublicIP,
+			launchConfigHashField: member.LaunchConfigHash,
+		})
+		ids = append(ids, member.ID)
+		ips = append(ips, member.PrivateIP)
+	}
+	_ = d.Set("instances", instances)
+	_ = d.Set("instance_ids", ids)
+	_ = d.Set("private_ips", ips)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,87 @@
+package ch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceContainerHostGroupRollingReplace(t *testing.T) {
+	c, sim := newSimulatedConfig(t)
+	delay := groupPollDelay
+	groupPollDelay = 0
+	t.Cleanup(func() { groupPollDelay = delay })
+
+	ctx := context.Background()
+	raw := map[string]interface{}{
+		"name_prefix":   "web",
+		"size":          3,
+		"max_surge":     1,
+		"instance_type": "m5.large",
+	}
+	d := schema.TestResourceDataRaw(t, ResourceContainerHostGroup().Schema, raw)
+	diags := resourceContainerHostGroupCreate(ctx, d, c)
+	if !assert.False(t, diags.HasError(), diags) {
+		return
+	}
+	original := expandGroupMembers(d)
+	assert.Len(t, original, 3)
+	assert.Len(t, d.Get("private_ips").([]interface{}), 3)
+
+	// Change a launch setting
+	raw["instance_type"] = "m5.xlarge"
+	d = groupWithMembers(t, raw, d)
+	diags = resourceContainerHostGroupUpdate(ctx, d, c)
+	if !assert.False(t, diags.HasError(), diags) {
+		return
+	}
+	replaced := expandGroupMembers(d)
+	assert.Len(t, replaced, 3)
+	for _, old := range original {
+		_, ok := sim.Instance(old.Name)
+		assert.False(t, ok, "%s is replaced", old.Name)
+	}
+	for _, member := range replaced {
+		state, ok := sim.Instance(member.Name)
+		assert.True(t, ok)
+		assert.Equal(t, "m5.xlarge", state.InstanceType)
+		assert.Equal(t, d.Get(launchConfigHashField), member.LaunchConfigHash)
+	}
+
+	// Scale down
+	raw["size"] = 1
+	d = groupWithMembers(t, raw, d)
+	diags = resourceContainerHostGroupUpdate(ctx, d, c)
+	if !assert.False(t, diags.HasError(), diags) {
+		return
+	}
+	assert.Len(t, expandGroupMembers(d), 1)
+
+	diags = resourceContainerHostGroupDelete(ctx, d, c)
+	assert.False(t, diags.HasError(), diags)
+	client, _ := c.CartelClient()
+	instances, _, err := client.GetAllInstances()
+	if assert.Nil(t, err) {
+		assert.Empty(t, *instances)
+	}
+}
+
+// groupWithMembers returns resource data for raw with the members of prev
+func groupWithMembers(t *testing.T, raw map[string]interface{}, prev *schema.ResourceData) *schema.ResourceData {
+	d := schema.TestResourceDataRaw(t, ResourceContainerHostGroup().Schema, raw)
+	d.SetId(prev.Id())
+	setGroupMembers(d, expandGroupMembers(prev))
+	return d
+}
+
+func TestFilterMembers(t *testing.T) {
+	members := []groupMember{
+		{Name: "a", LaunchConfigHash: "new"},
+		{Name: "b", LaunchConfigHash: "old"},
+		{Name: "c", LaunchConfigHash: "new"},
+	}
+	assert.Equal(t, []groupMember{members[1]}, filterMembers(members, "new", true))
+	assert.Equal(t, []groupMember{members[0], members[2]}, filterMembers(members, "new", false))
+}
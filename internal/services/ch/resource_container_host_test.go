@@ -0,0 +1,237 @@
+package ch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"github.com/philips-software/go-hsdp-api/cartel"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+	"github.com/philips-software/terraform-provider-hsdp/internal/simulator"
+	"github.com/stretchr/testify/assert"
+)
+
+// newSimulatedConfig returns a provider config whose Cartel calls go to a
+// simulator, which is closed when the test ends
+func newSimulatedConfig(t *testing.T) (*config.Config, *simulator.Simulator) {
+	sim := simulator.New()
+	t.Cleanup(sim.Close)
+
+	c := &config.Config{}
+	c.Region = "us-east"
+	c.CartelToken = "token"
+	c.CartelSecret = "secret"
+	c.SimulatorURL = sim.URL()
+	return c, sim
+}
+
+func TestCustomizeContainerHostDiffUnknownTags(t *testing.T) {
+	state := &terraform.InstanceState{
+		ID: "i-0123456789",
+		Attributes: map[string]string{
+			"id":              "i-0123456789",
+			"name":            "db",
+			"instance_role":   "container-host",
+			"instance_type":   "m5.large",
+			"encrypt_volumes": "true",
+			"volumes":         "1",
+			"volume_size":     "250",
+		},
+	}
+	// Tags computed from another resource are unknown during plan
+	raw := cty.ObjectVal(map[string]cty.Value{
+		"name":        cty.StringVal("db"),
+		"volumes":     cty.NumberIntVal(1),
+		"volume_size": cty.NumberIntVal(100),
+		"tags":        cty.UnknownVal(cty.Map(cty.String)),
+	})
+	state.RawConfig = raw
+	resource := ResourceContainerHost()
+	cfg := terraform.NewResourceConfigShimmed(raw, resource.CoreConfigSchema())
+	diff, err := resource.Diff(context.Background(), state, cfg, &config.Config{})
+	if !assert.Nil(t, err) {
+		return
+	}
+	if assert.NotNil(t, diff) {
+		if assert.NotNil(t, diff.Attributes["volume_size"]) {
+			assert.True(t, diff.Attributes["volume_size"].RequiresNew, "resizing volumes replaces the host")
+		}
+		assert.NotNil(t, diff.Attributes["file_hashes.%"])
+		assert.True(t, diff.Attributes["tags_all.%"].NewComputed)
+	}
+}
+
+func TestResourceContainerHostPowerState(t *testing.T) {
+	c, sim := newSimulatedConfig(t)
+
+	client, err := c.CartelClient()
+	if !assert.Nil(t, err) {
+		return
+	}
+	ch, _, err := client.Create("app", cartel.InstanceType("m5.large"))
+	if !assert.Nil(t, err) {
+		return
+	}
+	// Stopped overnight, outside Terraform
+	_, _, err = client.Stop("app")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	d := schema.TestResourceDataRaw(t, ResourceContainerHost().Schema, map[string]interface{}{
+		"name": "app",
+	})
+	d.SetId(ch.InstanceID())
+	diags := resourceContainerHostRead(ctx, d, c)
+	assert.False(t, diags.HasError(), diags)
+	assert.Equal(t, ch.InstanceID(), d.Id(), "stopped hosts stay in state")
+	assert.Equal(t, "stopped", d.Get("power_state"))
+
+	d = schema.TestResourceDataRaw(t, ResourceContainerHost().Schema, map[string]interface{}{
+		"name":        "app",
+		"power_state": "running",
+	})
+	d.SetId(ch.InstanceID())
+	diags = resourceContainerHostUpdate(ctx, d, c)
+	if !assert.False(t, diags.HasError(), diags) {
+		return
+	}
+	state, _ := sim.Instance("app")
+	assert.Equal(t, "running", state.State)
+	assert.Equal(t, 1, state.Starts)
+
+	// Rebooting stops and starts the instance
+	resource := ResourceContainerHost()
+	instance := &terraform.InstanceState{
+		ID: ch.InstanceID(),
+		Attributes: map[string]string{
+			"id":              ch.InstanceID(),
+			"name":            "app",
+			"instance_role":   "container-host",
+			"instance_type":   "m5.large",
+			"encrypt_volumes": "true",
+			"power_state":     "running",
+		},
+	}
+	diff, err := resource.Diff(ctx, instance, terraform.NewResourceConfigRaw(map[string]interface{}{
+		"name":           "app",
+		"power_state":    "running",
+		"reboot_trigger": map[string]interface{}{"kernel": "5.15"},
+	}), c)
+	if !assert.Nil(t, err) {
+		return
+	}
+	d, err = schema.InternalMap(resource.Schema).Data(instance, diff)
+	if !assert.Nil(t, err) {
+		return
+	}
+	diags = resourceContainerHostUpdate(ctx, d, c)
+	if !assert.False(t, diags.HasError(), diags) {
+		return
+	}
+	state, _ = sim.Instance("app")
+	assert.Equal(t, "running", state.State)
+	assert.Equal(t, 2, state.Starts)
+}
+
+func TestExpandReadinessCheck(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, ResourceContainerHost().Schema, map[string]interface{}{
+		"name": "web",
+		"readiness_check": []interface{}{
+			map[string]interface{}{"http_url": "http://web.dev.example.com:8080/", "timeout": "15m"},
+		},
+	})
+	check, err := expandReadinessCheck(d)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusOK, check.ExpectedStatus)
+	assert.Equal(t, 15*time.Minute, check.Timeout)
+
+	d = schema.TestResourceDataRaw(t, ResourceContainerHost().Schema, map[string]interface{}{
+		"name": "web",
+		"readiness_check": []interface{}{
+			map[string]interface{}{"http_url": "http://web.dev.example.com:8080/", "marker_file": "/tmp/ready"},
+		},
+	})
+	_, err = expandReadinessCheck(d)
+	assert.Error(t, err, "marker_file and http_url are exclusive")
+
+	d = schema.TestResourceDataRaw(t, ResourceContainerHost().Schema, map[string]interface{}{
+		"name": "web",
+	})
+	check, err = expandReadinessCheck(d)
+	assert.Nil(t, err)
+	assert.Nil(t, check)
+}
+
+func TestWaitForReadiness(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	err := waitForReadiness(ctx, nil, &readinessCheck{HTTPURL: server.URL, ExpectedStatus: http.StatusNoContent, Timeout: time.Minute})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	host := &fakeHost{}
+	err = waitForReadiness(ctx, host, &readinessCheck{MarkerFile: "/var/lib/cloud/instance/boot-finished", Timeout: time.Minute})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test -f '/var/lib/cloud/instance/boot-finished'"}, host.commands)
+}
+
+func TestCustomizeContainerHostDiffSecurityGroups(t *testing.T) {
+	c, sim := newSimulatedConfig(t)
+
+	resource := ResourceContainerHost()
+	plan := func(securityGroups cty.Value) error {
+		raw := cty.ObjectVal(map[string]cty.Value{
+			"name":            cty.StringVal("app"),
+			"security_groups": securityGroups,
+		})
+		state := &terraform.InstanceState{RawConfig: raw}
+		_, err := resource.Diff(context.Background(), state, terraform.NewResourceConfigShimmed(raw, resource.CoreConfigSchema()), c)
+		return err
+	}
+
+	err := plan(cty.SetVal([]cty.Value{cty.StringVal("https-from-cloud"), cty.StringVal("tcp-8080")}))
+	assert.Nil(t, err)
+
+	err = plan(cty.SetVal([]cty.Value{cty.StringVal("htps-from-cloud")}))
+	if assert.NotNil(t, err) {
+		assert.Equal(t, `unknown security group: "htps-from-cloud" (did you mean "https-from-cloud"?)`, err.Error())
+	}
+
+	// Groups computed from another resource are checked when they are known
+	err = plan(cty.UnknownVal(cty.Set(cty.String)))
+	assert.Nil(t, err)
+
+	// The groups are fetched once per provider instance
+	lookups := 0
+	for _, r := range sim.Requests() {
+		if strings.HasSuffix(r.Path, "/get_security_groups") {
+			lookups++
+		}
+	}
+	assert.Equal(t, 1, lookups)
+
+	ds := schema.TestResourceDataRaw(t, DataSourceContainerHostSecurityGroups().Schema, map[string]interface{}{
+		"names": []interface{}{"base", "tcp-8443"},
+	})
+	diags := dataSourceContainerHostSecurityGroupsRead(context.Background(), ds, c)
+	assert.False(t, diags.HasError(), diags)
+	assert.Equal(t, []interface{}{"base", "tcp-8443"}, ds.Get("names"))
+}
@@ -6,7 +6,6 @@ import (
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	discovery "github.com/philips-software/go-hsdp-api/config"
 	"github.com/philips-software/terraform-provider-hsdp/internal/config"
 	"github.com/philips-software/terraform-provider-hsdp/internal/tools"
 )
@@ -89,22 +88,19 @@ func dataSourceConfigRead(_ context.Context, d *schema.ResourceData, m interface
 	if environment == "" {
 		environment = providerConfig.Environment
 	}
-	c, err := discovery.New(discovery.WithEnv(environment),
-		discovery.WithRegion(region))
-	if err != nil {
-		return diag.FromErr(err)
-	}
+	c := providerConfig.Discovery
+	found := c.Service(region, environment, service)
 	d.SetId("data" + region + environment + service)
-	if url := c.Service(service).URL; url != "" {
+	if url := found.URL; url != "" {
 		_ = d.Set("url", url)
 	}
-	if host := c.Service(service).Host; host != "" {
+	if host := found.Host; host != "" {
 		_ = d.Set("host", host)
 	}
-	if domain := c.Service(service).Domain; domain != "" {
+	if domain := found.Domain; domain != "" {
 		_ = d.Set("domain", domain)
 	}
-	_ = d.Set("services", c.Services())
+	_ = d.Set("services", c.Services(region, environment))
 	_ = d.Set("service_id", providerConfig.ServiceID)
 	_ = d.Set("org_admin_username", providerConfig.OrgAdminUsername)
 	_ = d.Set("regions", c.Regions())
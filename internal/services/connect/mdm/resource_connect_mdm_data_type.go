@@ -21,6 +21,7 @@ func ResourceConnectMDMDataType() *schema.Resource {
 		ReadContext:   resourceConnectMDMDataTypeRead,
 		UpdateContext: resourceConnectMDMDataTypeUpdate,
 		DeleteContext: resourceConnectMDMDataTypeDelete,
+		CustomizeDiff: customizeConnectMDMDataTypeDiff,
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -43,6 +44,11 @@ func ResourceConnectMDMDataType() *schema.Resource {
 				Optional: true,
 				Elem:     tools.StringSchema(),
 			},
+			"tags_all": {
+				Type:     schema.TypeSet,
+				Computed: true,
+				Elem:     tools.StringSchema(),
+			},
 			"version_id": {
 				Type:     schema.TypeString,
 				Computed: true,
@@ -55,11 +61,23 @@ func ResourceConnectMDMDataType() *schema.Resource {
 	}
 }
 
-func schemaToDataType(d *schema.ResourceData) mdm.DataType {
+// customizeConnectMDMDataTypeDiff plans tags_all, the tags of the resource
+// merged with the provider default tags
+func customizeConnectMDMDataTypeDiff(_ context.Context, d *schema.ResourceDiff, m interface{}) error {
+	c := m.(*config.Config)
+
+	if !d.NewValueKnown("tags") {
+		return d.SetNewComputed("tags_all")
+	}
+	tags := tools.ExpandStringList(d.Get("tags").(*schema.Set).List())
+	return d.SetNew("tags_all", tools.SchemaSetStrings(c.MergeLabels(tags)))
+}
+
+func schemaToDataType(d *schema.ResourceData, c *config.Config) mdm.DataType {
 	name := d.Get("name").(string)
 	description := d.Get("description").(string)
 	propositionId := d.Get("proposition_id").(string)
-	tags := tools.ExpandStringList(d.Get("tags").(*schema.Set).List())
+	tags := c.MergeLabels(tools.ExpandStringList(d.Get("tags").(*schema.Set).List()))
 
 	resource := mdm.DataType{
 		Name:          name,
@@ -70,11 +88,13 @@ func schemaToDataType(d *schema.ResourceData) mdm.DataType {
 	return resource
 }
 
-func dataTypeToSchema(resource mdm.DataType, d *schema.ResourceData) {
+func dataTypeToSchema(resource mdm.DataType, d *schema.ResourceData, c *config.Config) {
+	configuredTags := tools.ExpandStringList(d.Get("tags").(*schema.Set).List())
 	_ = d.Set("name", resource.Name)
 	_ = d.Set("description", resource.Description)
 	_ = d.Set("name", resource.Name)
-	_ = d.Set("tags", tools.SchemaSetStrings(resource.Tags))
+	_ = d.Set("tags", tools.SchemaSetStrings(c.ResourceLabels(resource.Tags, configuredTags)))
+	_ = d.Set("tags_all", tools.SchemaSetStrings(resource.Tags))
 	_ = d.Set("guid", resource.ID)
 	_ = d.Set("proposition_id", resource.PropositionId.Reference)
 }
@@ -87,7 +107,7 @@ func resourceConnectMDMDataTypeCreate(ctx context.Context, d *schema.ResourceDat
 		return diag.FromErr(err)
 	}
 
-	resource := schemaToDataType(d)
+	resource := schemaToDataType(d, c)
 
 	var created *mdm.DataType
 	var resp *mdm.Response
@@ -145,7 +165,7 @@ func resourceConnectMDMDataTypeRead(ctx context.Context, d *schema.ResourceData,
 		}
 		return diag.FromErr(err)
 	}
-	dataTypeToSchema(*resource, d)
+	dataTypeToSchema(*resource, d, c)
 	return diags
 }
 
@@ -161,7 +181,7 @@ func resourceConnectMDMDataTypeUpdate(ctx context.Context, d *schema.ResourceDat
 
 	id := d.Get("guid").(string)
 
-	service := schemaToDataType(d)
+	service := schemaToDataType(d, c)
 	service.ID = id
 
 	_, _, err = client.DataTypes.Update(service)
@@ -136,7 +136,7 @@ func resourceMDMPropositionCreate(ctx context.Context, d *schema.ResourceData, m
 			return nil, err
 		}
 		return resp.Response, err
-	}, http.StatusForbidden, http.StatusInternalServerError, http.StatusTooManyRequests)
+	}, http.StatusForbidden, http.StatusInternalServerError)
 	if err != nil {
 		if resp == nil {
 			return diag.FromErr(err)
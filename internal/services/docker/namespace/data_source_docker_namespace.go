@@ -14,6 +14,10 @@ func DataSourceDockerNamespace() *schema.Resource {
 	return &schema.Resource{
 		ReadContext: dataSourceDockerNamespaceRead,
 		Schema: map[string]*schema.Schema{
+			"region": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
 			"name": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -44,7 +48,7 @@ func dataSourceDockerNamespaceRead(ctx context.Context, d *schema.ResourceData,
 	var diags diag.Diagnostics
 
 	c := m.(*config.Config)
-	client, err := c.DockerClient()
+	client, err := c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
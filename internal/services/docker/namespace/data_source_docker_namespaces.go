@@ -12,6 +12,10 @@ func DataSourceDockerNamespaces() *schema.Resource {
 	return &schema.Resource{
 		ReadContext: dataSourceDockerNamespacesRead,
 		Schema: map[string]*schema.Schema{
+			"region": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
 			"names": {
 				Type:     schema.TypeList,
 				Computed: true,
@@ -31,7 +35,7 @@ func dataSourceDockerNamespacesRead(ctx context.Context, d *schema.ResourceData,
 	var diags diag.Diagnostics
 
 	c := m.(*config.Config)
-	client, err := c.DockerClient()
+	client, err := c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -21,6 +21,11 @@ func ResourceDockerNamespace() *schema.Resource {
 		DeleteContext: resourceDockerNamespaceDelete,
 
 		Schema: map[string]*schema.Schema{
+			"region": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
 			"name": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -48,7 +53,7 @@ func resourceDockerNamespaceDelete(ctx context.Context, d *schema.ResourceData,
 	var client *docker.Client
 	var err error
 
-	client, err = c.DockerClient()
+	client, err = c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -67,7 +72,7 @@ func resourceDockerNamespaceRead(ctx context.Context, d *schema.ResourceData, m
 	var client *docker.Client
 	var err error
 
-	client, err = c.DockerClient()
+	client, err = c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -89,7 +94,7 @@ func resourceDockerNamespaceCreate(ctx context.Context, d *schema.ResourceData,
 	var client *docker.Client
 	var err error
 
-	client, err = c.DockerClient()
+	client, err = c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
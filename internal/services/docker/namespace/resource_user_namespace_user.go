@@ -21,6 +21,11 @@ func ResourceDockerNamespaceUser() *schema.Resource {
 		DeleteContext: resourceDockerNamespaceUserDelete,
 
 		Schema: map[string]*schema.Schema{
+			"region": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
 			"username": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -64,7 +69,7 @@ func resourceDockerNamespaceUserUpdate(ctx context.Context, d *schema.ResourceDa
 	var client *docker.Client
 	var err error
 
-	client, err = c.DockerClient()
+	client, err = c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -94,7 +99,7 @@ func resourceDockerNamespaceUserDelete(ctx context.Context, d *schema.ResourceDa
 	var client *docker.Client
 	var err error
 
-	client, err = c.DockerClient()
+	client, err = c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -115,7 +120,7 @@ func resourceDockerNamespaceUserRead(ctx context.Context, d *schema.ResourceData
 	var client *docker.Client
 	var err error
 
-	client, err = c.DockerClient()
+	client, err = c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -151,7 +156,7 @@ func resourceDockerNamespaceUserCreate(ctx context.Context, d *schema.ResourceDa
 	var client *docker.Client
 	var err error
 
-	client, err = c.DockerClient()
+	client, err = c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
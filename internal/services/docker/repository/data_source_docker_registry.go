@@ -14,6 +14,10 @@ func DataSourceDockerRepository() *schema.Resource {
 	return &schema.Resource{
 		ReadContext: dataSourceDockerRepositoryRead,
 		Schema: map[string]*schema.Schema{
+			"region": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
 			"namespace_id": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -83,7 +87,7 @@ func dataSourceDockerRepositoryRead(ctx context.Context, d *schema.ResourceData,
 
 	var diags diag.Diagnostics
 
-	client, err := c.DockerClient()
+	client, err := c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
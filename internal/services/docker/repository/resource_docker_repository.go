@@ -21,6 +21,11 @@ func ResourceDockerRepository() *schema.Resource {
 		DeleteContext: resourceDockerRepositoryDelete,
 
 		Schema: map[string]*schema.Schema{
+			"region": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
 			"name": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -91,7 +96,7 @@ func resourceDockerRepositoryUpdate(ctx context.Context, d *schema.ResourceData,
 	var client *docker.Client
 	var err error
 
-	client, err = c.DockerClient()
+	client, err = c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -116,7 +121,7 @@ func resourceDockerRepositoryDelete(ctx context.Context, d *schema.ResourceData,
 	var client *docker.Client
 	var err error
 
-	client, err = c.DockerClient()
+	client, err = c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -143,7 +148,7 @@ func resourceDockerRepositoryCreate(ctx context.Context, d *schema.ResourceData,
 	var client *docker.Client
 	var err error
 
-	client, err = c.DockerClient()
+	client, err = c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -21,6 +21,11 @@ func ResourceDockerServiceKey() *schema.Resource {
 		DeleteContext: resourceDockerServiceKeyDelete,
 
 		Schema: map[string]*schema.Schema{
+			"region": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
 			"description": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -49,7 +54,7 @@ func resourceDockerServiceKeyDelete(ctx context.Context, d *schema.ResourceData,
 	var client *docker.Client
 	var err error
 
-	client, err = c.DockerClient()
+	client, err = c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -69,7 +74,7 @@ func resourceDockerServiceKeyRead(ctx context.Context, d *schema.ResourceData, m
 	var client *docker.Client
 	var err error
 
-	client, err = c.DockerClient()
+	client, err = c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -93,7 +98,7 @@ func resourceDockerServiceKeyCreate(ctx context.Context, d *schema.ResourceData,
 	var client *docker.Client
 	var err error
 
-	client, err = c.DockerClient()
+	client, err = c.DockerClient(d.Get("region").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -55,7 +55,7 @@ func resourceEdgeAppUpdate(ctx context.Context, d *schema.ResourceData, m interf
 	var err error
 
 	if endpoint, ok := d.GetOk("endpoint"); ok {
-		client, err = c.STLClient(endpoint.(string))
+		client, err = c.STLClientFromEndpoint(endpoint.(string))
 	} else {
 		client, err = c.STLClient()
 	}
@@ -86,7 +86,7 @@ func resourceEdgeAppDelete(ctx context.Context, d *schema.ResourceData, m interf
 	var err error
 
 	if endpoint, ok := d.GetOk("endpoint"); ok {
-		client, err = c.STLClient(endpoint.(string))
+		client, err = c.STLClientFromEndpoint(endpoint.(string))
 	} else {
 		client, err = c.STLClient()
 	}
@@ -113,7 +113,7 @@ func resourceEdgeAppRead(ctx context.Context, d *schema.ResourceData, m interfac
 	var err error
 
 	if endpoint, ok := d.GetOk("endpoint"); ok {
-		client, err = c.STLClient(endpoint.(string))
+		client, err = c.STLClientFromEndpoint(endpoint.(string))
 	} else {
 		client, err = c.STLClient()
 	}
@@ -147,7 +147,7 @@ func resourceEdgeAppCreate(ctx context.Context, d *schema.ResourceData, m interf
 	var err error
 
 	if endpoint, ok := d.GetOk("endpoint"); ok {
-		client, err = c.STLClient(endpoint.(string))
+		client, err = c.STLClientFromEndpoint(endpoint.(string))
 	} else {
 		client, err = c.STLClient()
 	}
@@ -144,7 +144,7 @@ func resourceEdgeConfigDelete(ctx context.Context, d *schema.ResourceData, m int
 	var err error
 
 	if endpoint, ok := d.GetOk("endpoint"); ok {
-		client, err = c.STLClient(endpoint.(string))
+		client, err = c.STLClientFromEndpoint(endpoint.(string))
 	} else {
 		client, err = c.STLClient()
 	}
@@ -362,7 +362,7 @@ func resourceEdgeConfigRead(ctx context.Context, d *schema.ResourceData, m inter
 	var err error
 
 	if endpoint, ok := d.GetOk("endpoint"); ok {
-		client, err = c.STLClient(endpoint.(string))
+		client, err = c.STLClientFromEndpoint(endpoint.(string))
 	} else {
 		client, err = c.STLClient()
 	}
@@ -391,7 +391,7 @@ func resourceEdgeConfigCreate(ctx context.Context, d *schema.ResourceData, m int
 	var err error
 
 	if endpoint, ok := d.GetOk("endpoint"); ok {
-		client, err = c.STLClient(endpoint.(string))
+		client, err = c.STLClientFromEndpoint(endpoint.(string))
 	} else {
 		client, err = c.STLClient()
 	}
@@ -54,7 +54,7 @@ func resourceEdgeCustomCertDelete(ctx context.Context, d *schema.ResourceData, m
 	var err error
 
 	if endpoint, ok := d.GetOk("endpoint"); ok {
-		client, err = c.STLClient(endpoint.(string))
+		client, err = c.STLClientFromEndpoint(endpoint.(string))
 	} else {
 		client, err = c.STLClient()
 	}
@@ -79,7 +79,7 @@ func resourceEdgeCustomCertUpdate(ctx context.Context, d *schema.ResourceData, m
 	var err error
 
 	if endpoint, ok := d.GetOk("endpoint"); ok {
-		client, err = c.STLClient(endpoint.(string))
+		client, err = c.STLClientFromEndpoint(endpoint.(string))
 	} else {
 		client, err = c.STLClient()
 	}
@@ -108,7 +108,7 @@ func resourceEdgeCustomCertRead(ctx context.Context, d *schema.ResourceData, m i
 	var err error
 
 	if endpoint, ok := d.GetOk("endpoint"); ok {
-		client, err = c.STLClient(endpoint.(string))
+		client, err = c.STLClientFromEndpoint(endpoint.(string))
 	} else {
 		client, err = c.STLClient()
 	}
@@ -134,7 +134,7 @@ func resourceEdgeCustomCertCreate(ctx context.Context, d *schema.ResourceData, m
 	var err error
 
 	if endpoint, ok := d.GetOk("endpoint"); ok {
-		client, err = c.STLClient(endpoint.(string))
+		client, err = c.STLClientFromEndpoint(endpoint.(string))
 	} else {
 		client, err = c.STLClient()
 	}
@@ -0,0 +1,379 @@
+package function
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	siderite "github.com/philips-labs/siderite/models"
+	"github.com/philips-software/go-hsdp-api/iron"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+)
+
+const (
+	backendSiderite = "siderite"
+	backendFerrite  = "ferrite"
+	backendCF       = "cf"
+)
+
+var (
+	errNoScheduler = errors.New("the cf backend has no scheduler, remove 'schedule' and 'run_every'")
+	errNoTasks     = errors.New("the siderite and ferrite backends run functions through their gateway, set 'endpoint' instead")
+)
+
+// backend runs the functions of hsdp_function. Codes, schedules and tasks
+// are modelled after Iron, which the siderite and ferrite backends use.
+type backend interface {
+	// Bootstrap connects to the backend, before any other method is called
+	Bootstrap() error
+	// DockerLogin stores the credentials to pull images from their registry
+	DockerLogin(credentials dockerCredentials) error
+	// RegisterCode registers image as the code codeName, or as a new
+	// revision of it when the code exists
+	RegisterCode(codeName, image string) (*iron.Code, error)
+	// GetCode returns the code with codeID, or nil when it does not exist
+	GetCode(codeID string) (*iron.Code, error)
+	DeleteCode(codeID string) error
+	// EncryptPayload prepares a payload so only the function can read it
+	EncryptPayload(payload []byte) (string, error)
+	// ReadPayload returns the command and environment the code with codeID
+	// runs with, or nil when only the function can read its payloads
+	ReadPayload(codeID string) (*siderite.Payload, error)
+	// CreateSchedule creates schedule and returns its ID
+	CreateSchedule(schedule iron.Schedule) (string, error)
+	GetSchedules(codeName string) ([]iron.Schedule, error)
+	CancelSchedule(scheduleID string) error
+	// GetTasks returns the tasks of the code with codeID since the given
+	// time, or all when since is zero. It reports whether more than
+	// maxTaskPages pages of tasks were found, which are left out.
+	GetTasks(codeID string, since time.Time) ([]iron.Task, bool, error)
+	// RunTask runs the code with codeID once and waits up to timeout for the
+	// task to end
+	RunTask(codeID string, timeout time.Duration) (*iron.Task, error)
+	// Gateway returns the siderite gateway settings passed in payloads
+	Gateway() gateway
+}
+
+// gateway are the settings of the siderite gateway which exposes functions
+// as HTTP endpoints
+type gateway struct {
+	Upstream string
+	Token    string
+	AuthType string
+}
+
+type dockerCredentials struct {
+	Email         string
+	Username      string
+	Password      string
+	ServerAddress string
+}
+
+func backendSchema() *schema.Schema {
+	exactlyOneOf := []string{"backend.0.credentials", "backend.0.siderite", "backend.0.ferrite", "backend.0.cf"}
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Required: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"credentials": {
+					Type:         schema.TypeMap,
+					Optional:     true,
+					Sensitive:    true,
+					Deprecated:   "use the siderite, ferrite or cf block instead",
+					ExactlyOneOf: exactlyOneOf,
+				},
+				backendSiderite: {
+					Type:         schema.TypeList,
+					Optional:     true,
+					MaxItems:     1,
+					ExactlyOneOf: exactlyOneOf,
+					Elem: &schema.Resource{
+						Schema: ironBackendSchema(map[string]*schema.Schema{
+							"project_id": {
+								Type:     schema.TypeString,
+								Required: true,
+							},
+							"project": {
+								Type:     schema.TypeString,
+								Optional: true,
+							},
+							"email": {
+								Type:     schema.TypeString,
+								Optional: true,
+							},
+							"password": {
+								Type:      schema.TypeString,
+								Optional:  true,
+								Sensitive: true,
+							},
+							"user_id": {
+								Type:     schema.TypeString,
+								Optional: true,
+							},
+							"cluster_id": {
+								Type:     schema.TypeString,
+								Required: true,
+							},
+							"cluster_name": {
+								Type:     schema.TypeString,
+								Optional: true,
+							},
+							"cluster_public_key": {
+								Description: "The PEM encoded public key of the cluster, used to encrypt payloads.",
+								Type:        schema.TypeString,
+								Required:    true,
+							},
+						}),
+					},
+				},
+				backendFerrite: {
+					Type:         schema.TypeList,
+					Optional:     true,
+					MaxItems:     1,
+					ExactlyOneOf: exactlyOneOf,
+					Elem: &schema.Resource{
+						Schema: ironBackendSchema(map[string]*schema.Schema{}),
+					},
+				},
+				backendCF: {
+					Type:         schema.TypeList,
+					Optional:     true,
+					MaxItems:     1,
+					ExactlyOneOf: exactlyOneOf,
+					Elem: &schema.Resource{
+						Schema: map[string]*schema.Schema{
+							"api_url": {
+								Type:         schema.TypeString,
+								Required:     true,
+								ValidateFunc: validation.IsURLWithHTTPS,
+							},
+							"username": {
+								Type:     schema.TypeString,
+								Required: true,
+							},
+							"password": {
+								Type:      schema.TypeString,
+								Required:  true,
+								Sensitive: true,
+							},
+							"space_id": {
+								Description:  "The GUID of the space to deploy functions to.",
+								Type:         schema.TypeString,
+								Required:     true,
+								ValidateFunc: validation.IsUUID,
+							},
+							"memory": {
+								Description:  "The memory of a task in MB.",
+								Type:         schema.TypeInt,
+								Optional:     true,
+								Default:      512,
+								ValidateFunc: validation.IntBetween(64, 8192),
+							},
+							"disk": {
+								Description:  "The disk of a task in MB.",
+								Type:         schema.TypeInt,
+								Optional:     true,
+								Default:      1024,
+								ValidateFunc: validation.IntBetween(64, 8192),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// ironBackendSchema adds the settings shared by the Iron based backends to s
+func ironBackendSchema(s map[string]*schema.Schema) map[string]*schema.Schema {
+	s["base_url"] = &schema.Schema{
+		Type:         schema.TypeString,
+		Required:     true,
+		ValidateFunc: validation.IsURLWithHTTPorHTTPS,
+	}
+	s["token"] = &schema.Schema{
+		Type:      schema.TypeString,
+		Required:  true,
+		Sensitive: true,
+	}
+	s["upstream"] = &schema.Schema{
+		Description: "The host of the siderite gateway.",
+		Type:        schema.TypeString,
+		Optional:    true,
+	}
+	s["gateway_token"] = &schema.Schema{
+		Type:      schema.TypeString,
+		Optional:  true,
+		Sensitive: true,
+	}
+	s["auth_type"] = &schema.Schema{
+		Type:         schema.TypeString,
+		Optional:     true,
+		ValidateFunc: validation.StringInSlice([]string{authTypeNone, authTypeToken, authTypeIAM}, false),
+	}
+	return s
+}
+
+// newBackend returns the bootstrapped backend configured in d
+func newBackend(d *schema.ResourceData, m interface{}) (backend, error) {
+	c := m.(*config.Config)
+	b, err := expandBackend(d.Get("backend").([]interface{}), c)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Bootstrap(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// expandBackend returns the backend of the backend block in raw. The
+// deprecated credentials map is converted to the typed settings.
+func expandBackend(raw []interface{}, c *config.Config) (backend, error) {
+	if len(raw) == 0 || raw[0] == nil {
+		return nil, fmt.Errorf("missing 'backend'")
+	}
+	block, ok := raw[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected backend format")
+	}
+	if credentials, ok := block["credentials"].(map[string]interface{}); ok && len(credentials) > 0 {
+		return backendFromCredentials(credentials, c)
+	}
+	if settings := nestedBlock(block, backendSiderite); settings != nil {
+		b := newIronBackend(backendSiderite, settings, c)
+		b.config.ProjectID = settings["project_id"].(string)
+		b.config.Project = settings["project"].(string)
+		b.config.Email = settings["email"].(string)
+		b.config.Password = settings["password"].(string)
+		b.config.UserID = settings["user_id"].(string)
+		b.config.ClusterInfo = []iron.ClusterInfo{{
+			ClusterID:   settings["cluster_id"].(string),
+			ClusterName: settings["cluster_name"].(string),
+			Pubkey:      settings["cluster_public_key"].(string),
+		}}
+		return b, nil
+	}
+	if settings := nestedBlock(block, backendFerrite); settings != nil {
+		return newIronBackend(backendFerrite, settings, c), nil
+	}
+	if settings := nestedBlock(block, backendCF); settings != nil {
+		return &cfBackend{
+			apiURL:   strings.TrimSuffix(settings["api_url"].(string), "/"),
+			username: settings["username"].(string),
+			password: settings["password"].(string),
+			spaceID:  settings["space_id"].(string),
+			memory:   settings["memory"].(int),
+			disk:     settings["disk"].(int),
+			config:   c,
+		}, nil
+	}
+	return nil, fmt.Errorf("one of 'siderite', 'ferrite' or 'cf' must be set in 'backend'")
+}
+
+// backendFromCredentials converts the credentials map of the siderite
+// backend module
+func backendFromCredentials(credentials map[string]interface{}, c *config.Config) (backend, error) {
+	cfg := make(map[string]interface{})
+	for k, v := range credentials {
+		if str, ok := v.(string); ok {
+			cfg[k] = str
+		}
+	}
+	get := func(key string) string {
+		str, _ := cfg[key].(string)
+		return str
+	}
+	backendType := get("type")
+	if !(backendType == backendSiderite || backendType == backendFerrite) {
+		return nil, fmt.Errorf("expected backend type of ['siderite' | 'ferrite']")
+	}
+	b := newIronBackend(backendType, map[string]interface{}{
+		"base_url":      get("base_url"),
+		"token":         get("token"),
+		"upstream":      get("siderite_upstream"),
+		"gateway_token": get("siderite_token"),
+		"auth_type":     get("siderite_auth_type"),
+	}, c)
+	b.config.ProjectID = get("project_id")
+	b.config.Project = get("project")
+	b.config.Email = get("email")
+	b.config.Password = get("password")
+	b.config.UserID = get("user_id")
+	b.config.ClusterInfo = []iron.ClusterInfo{{
+		ClusterID:   get("cluster_info_0_cluster_id"),
+		ClusterName: get("cluster_info_0_cluster_name"),
+		Pubkey:      get("cluster_info_0_pubkey"),
+		UserID:      get("cluster_info_0_user_id"),
+	}}
+	return b, nil
+}
+
+// backendIdentity identifies where the backend in raw runs functions.
+// Functions are only replaced when it changes, not when credentials rotate
+// or move from the credentials map to a typed block.
+func backendIdentity(raw []interface{}) string {
+	b, err := expandBackend(raw, &config.Config{})
+	if err != nil {
+		return ""
+	}
+	switch b := b.(type) {
+	case *ironBackend:
+		return strings.Join([]string{b.kind, strings.TrimSuffix(b.config.BaseURL, "/"), b.config.ProjectID}, " ")
+	case *cfBackend:
+		return strings.Join([]string{backendCF, b.apiURL, b.spaceID}, " ")
+	}
+	return ""
+}
+
+func nestedBlock(block map[string]interface{}, key string) map[string]interface{} {
+	list, ok := block[key].([]interface{})
+	if !ok || len(list) == 0 {
+		return nil
+	}
+	settings, _ := list[0].(map[string]interface{})
+	return settings
+}
+
+// expandDockerCredentials returns the docker_credentials in d for the
+// registry of the docker_image
+func expandDockerCredentials(d *schema.ResourceData) (*dockerCredentials, error) {
+	v, ok := d.GetOk("docker_credentials")
+	if !ok {
+		return nil, nil
+	}
+	dockerImage := d.Get("docker_image").(string)
+	ref, err := reference.ParseNormalizedNamed(dockerImage)
+	if err != nil {
+		return nil, fmt.Errorf("error normalizing docker '%s': %w", dockerImage, err)
+	}
+	registry := ""
+	if str := strings.Split(ref.Name(), "/"); len(str) > 1 {
+		registry = str[0]
+	}
+	vv := v.(map[string]interface{})
+	username, _ := vv["username"].(string)
+	password, _ := vv["password"].(string)
+	return &dockerCredentials{
+		Email:         fmt.Sprintf("terraform-%s@localhost.localdomain", d.Get("name").(string)),
+		Username:      username,
+		Password:      password,
+		ServerAddress: registry,
+	}, nil
+}
+
+// parseFunctionID splits an ID of the form {codeID}-{signature}. The code ID
+// of the cf backend is a GUID which contains dashes itself.
+func parseFunctionID(id string) (codeID string, signature string, ok bool) {
+	i := strings.LastIndex(id, "-")
+	if i <= 0 || i == len(id)-1 {
+		return "", "", false
+	}
+	return id[:i], id[i+1:], true
+}
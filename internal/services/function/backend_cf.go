@@ -0,0 +1,572 @@
+package function
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	siderite "github.com/philips-labs/siderite/models"
+	"github.com/philips-software/go-hsdp-api/iron"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+)
+
+var (
+	errCFNotFound = errors.New("not found")
+
+	cfPollInterval   = 2 * time.Second
+	cfStagingTimeout = 10 * time.Minute
+)
+
+// cfScheduleModes are the annotations of an app which record the sync and
+// async schedules of a function
+var cfScheduleModes = []string{"sync", "async"}
+
+// cfBackend runs functions as Cloud Foundry tasks. The image is staged as a
+// docker app which runs no instances. Cloud Foundry has no scheduler, so
+// the schedules of a function only configure the command and environment
+// of the tasks of the app, which are recorded as annotations.
+type cfBackend struct {
+	apiURL   string
+	username string
+	password string
+	spaceID  string
+	memory   int
+	disk     int
+
+	config      *config.Config
+	client      *http.Client
+	token       string
+	credentials *dockerCredentials
+}
+
+type cfResource struct {
+	GUID      string     `json:"guid"`
+	Name      string     `json:"name,omitempty"`
+	State     string     `json:"state,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	Image     string     `json:"image,omitempty"`
+	Command   string     `json:"command,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Droplet   *struct {
+		GUID string `json:"guid"`
+	} `json:"droplet,omitempty"`
+	Result *struct {
+		FailureReason string `json:"failure_reason"`
+	} `json:"result,omitempty"`
+	Metadata *struct {
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata,omitempty"`
+}
+
+type cfList struct {
+	Pagination struct {
+		TotalResults int `json:"total_results"`
+		TotalPages   int `json:"total_pages"`
+	} `json:"pagination"`
+	Resources []cfResource `json:"resources"`
+}
+
+type cfErrors struct {
+	Errors []struct {
+		Detail string `json:"detail"`
+		Title  string `json:"title"`
+	} `json:"errors"`
+}
+
+func (b *cfBackend) Bootstrap() error {
+	b.client = b.config.FunctionHTTPClient(b.apiURL)
+	var root struct {
+		Links map[string]struct {
+			Href string `json:"href"`
+		} `json:"links"`
+	}
+	if err := b.do(http.MethodGet, "/", nil, &root); err != nil {
+		return fmt.Errorf("discovering %s: %w", b.apiURL, err)
+	}
+	login := root.Links["login"].Href
+	if login == "" {
+		return fmt.Errorf("discovering %s: no login endpoint", b.apiURL)
+	}
+	form := url.Values{
+		"grant_type": {"password"},
+		"username":   {b.username},
+		"password":   {b.password},
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(login, "/")+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth("cf", "")
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cf login: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cf login: got HTTP %d", resp.StatusCode)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("cf login: %w", err)
+	}
+	b.token = token.AccessToken
+	return nil
+}
+
+// do calls the Cloud Controller v3 API
+func (b *cfBackend) do(method, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, b.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s %s: %w", method, path, errCFNotFound)
+	}
+	if resp.StatusCode >= 400 {
+		var cfErr cfErrors
+		_ = json.NewDecoder(resp.Body).Decode(&cfErr)
+		if len(cfErr.Errors) > 0 {
+			return fmt.Errorf("%s %s: %s: %s", method, path, cfErr.Errors[0].Title, cfErr.Errors[0].Detail)
+		}
+		return fmt.Errorf("%s %s: got HTTP %d", method, path, resp.StatusCode)
+	}
+	if result == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// DockerLogin keeps the credentials, they are stored with the next package
+func (b *cfBackend) DockerLogin(credentials dockerCredentials) error {
+	b.credentials = &credentials
+	return nil
+}
+
+func (b *cfBackend) findApp(name string) (*cfResource, error) {
+	var apps cfList
+	query := url.Values{"names": {name}, "space_guids": {b.spaceID}}
+	if err := b.do(http.MethodGet, "/v3/apps?"+query.Encode(), nil, &apps); err != nil {
+		return nil, err
+	}
+	if len(apps.Resources) == 0 {
+		return nil, nil
+	}
+	return &apps.Resources[0], nil
+}
+
+func (b *cfBackend) RegisterCode(codeName, image string) (*iron.Code, error) {
+	app, err := b.findApp(codeName)
+	if err != nil {
+		return nil, err
+	}
+	if app == nil {
+		app = &cfResource{}
+		err := b.do(http.MethodPost, "/v3/apps", map[string]interface{}{
+			"name":      codeName,
+			"lifecycle": map[string]interface{}{"type": "docker", "data": map[string]interface{}{}},
+			"relationships": map[string]interface{}{
+				"space": map[string]interface{}{"data": map[string]string{"guid": b.spaceID}},
+			},
+		}, app)
+		if err != nil {
+			return nil, fmt.Errorf("creating app %s: %w", codeName, err)
+		}
+	}
+	data := map[string]string{"image": image}
+	if b.credentials != nil {
+		data["username"] = b.credentials.Username
+		data["password"] = b.credentials.Password
+	}
+	var pkg cfResource
+	err = b.do(http.MethodPost, "/v3/packages", map[string]interface{}{
+		"type": "docker",
+		"data": data,
+		"relationships": map[string]interface{}{
+			"app": map[string]interface{}{"data": map[string]string{"guid": app.GUID}},
+		},
+	}, &pkg)
+	if err != nil {
+		return nil, fmt.Errorf("creating package of %s: %w", image, err)
+	}
+	droplet, err := b.stage(pkg.GUID)
+	if err != nil {
+		return nil, fmt.Errorf("staging %s: %w", image, err)
+	}
+	err = b.do(http.MethodPatch, "/v3/apps/"+app.GUID+"/relationships/current_droplet", map[string]interface{}{
+		"data": map[string]string{"guid": droplet},
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("setting droplet of %s: %w", codeName, err)
+	}
+	return b.GetCode(app.GUID)
+}
+
+// stage builds the package and returns the droplet
+func (b *cfBackend) stage(packageGUID string) (string, error) {
+	var build cfResource
+	err := b.do(http.MethodPost, "/v3/builds", map[string]interface{}{
+		"package": map[string]string{"guid": packageGUID},
+	}, &build)
+	if err != nil {
+		return "", err
+	}
+	deadline := time.Now().Add(cfStagingTimeout)
+	for build.State == "STAGING" {
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("build %s did not finish within %s", build.GUID, cfStagingTimeout)
+		}
+		time.Sleep(cfPollInterval)
+		if err := b.do(http.MethodGet, "/v3/builds/"+build.GUID, nil, &build); err != nil {
+			return "", err
+		}
+	}
+	if build.State != "STAGED" || build.Droplet == nil {
+		return "", fmt.Errorf("build %s %s: %s", build.GUID, strings.ToLower(build.State), build.Error)
+	}
+	return build.Droplet.GUID, nil
+}
+
+func (b *cfBackend) GetCode(codeID string) (*iron.Code, error) {
+	var app cfResource
+	if err := b.do(http.MethodGet, "/v3/apps/"+codeID, nil, &app); err != nil {
+		if errors.Is(err, errCFNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var droplet cfResource
+	if err := b.do(http.MethodGet, "/v3/apps/"+codeID+"/droplets/current", nil, &droplet); err != nil && !errors.Is(err, errCFNotFound) {
+		return nil, err
+	}
+	// Every deploy creates a package, their count is the revision
+	var packages cfList
+	query := url.Values{"app_guids": {codeID}, "per_page": {"1"}}
+	if err := b.do(http.MethodGet, "/v3/packages?"+query.Encode(), nil, &packages); err != nil {
+		return nil, err
+	}
+	return &iron.Code{
+		ID:        app.GUID,
+		Name:      app.Name,
+		Image:     droplet.Image,
+		Rev:       packages.Pagination.TotalResults,
+		CreatedAt: app.CreatedAt,
+	}, nil
+}
+
+func (b *cfBackend) DeleteCode(codeID string) error {
+	err := b.do(http.MethodDelete, "/v3/apps/"+codeID, nil, nil)
+	if errors.Is(err, errCFNotFound) {
+		return nil
+	}
+	return err
+}
+
+// EncryptPayload returns the payload as is. It never leaves the backend:
+// the Cloud Controller stores the environment encrypted and only a digest
+// is recorded in the annotations.
+func (b *cfBackend) EncryptPayload(payload []byte) (string, error) {
+	return string(payload), nil
+}
+
+// CreateSchedule configures the command and environment of the tasks of the
+// app from the payload of a sync schedule. Only sync and async schedules,
+// which the gateway serves, can be created.
+func (b *cfBackend) CreateSchedule(schedule iron.Schedule) (string, error) {
+	var cfg siderite.CronPayload
+	if err := json.Unmarshal([]byte(schedule.Payload), &cfg); err != nil || cfg.Schedule != "" || cfg.Type == "" {
+		return "", errNoScheduler
+	}
+	app, err := b.findApp(schedule.CodeName)
+	if err != nil {
+		return "", err
+	}
+	if app == nil {
+		return "", fmt.Errorf("app %s not found", schedule.CodeName)
+	}
+	if cfg.Type == "sync" {
+		var payload siderite.Payload
+		if err := json.Unmarshal([]byte(cfg.EncryptedPayload), &payload); err != nil {
+			return "", fmt.Errorf("decoding payload: %w", err)
+		}
+		if err := b.configureTasks(app.GUID, payload); err != nil {
+			return "", err
+		}
+	}
+	// The name tells this schedule apart from the one it replaces
+	sum := sha256.Sum256([]byte(cfg.EncryptedPayload))
+	name := strings.Replace(uuid.New().String(), "-", "", -1)
+	record, _ := json.Marshal(siderite.CronPayload{
+		Name:             name,
+		EncryptedPayload: hex.EncodeToString(sum[:]),
+		Type:             cfg.Type,
+		Timeout:          schedule.Timeout,
+	})
+	err = b.do(http.MethodPatch, "/v3/apps/"+app.GUID, map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{cfAnnotation(cfg.Type): string(record)},
+		},
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	return cfScheduleID(app.GUID, cfg.Type, name), nil
+}
+
+// configureTasks sets the environment of the app and the command and
+// resources of its web process, which tasks use as their template
+func (b *cfBackend) configureTasks(appGUID string, payload siderite.Payload) error {
+	var current struct {
+		Var map[string]interface{} `json:"var"`
+	}
+	if err := b.do(http.MethodGet, "/v3/apps/"+appGUID+"/environment_variables", nil, &current); err != nil {
+		return err
+	}
+	env := make(map[string]interface{})
+	for k := range current.Var {
+		env[k] = nil
+	}
+	for k, v := range payload.Env {
+		env[k] = v
+	}
+	if err := b.do(http.MethodPatch, "/v3/apps/"+appGUID+"/environment_variables", map[string]interface{}{"var": env}, nil); err != nil {
+		return fmt.Errorf("setting environment: %w", err)
+	}
+	var process cfResource
+	if err := b.do(http.MethodGet, "/v3/apps/"+appGUID+"/processes/web", nil, &process); err != nil {
+		return err
+	}
+	if err := b.do(http.MethodPatch, "/v3/processes/"+process.GUID, map[string]interface{}{
+		"command": strings.Join(payload.Cmd, " "),
+	}, nil); err != nil {
+		return fmt.Errorf("setting command: %w", err)
+	}
+	return b.do(http.MethodPost, "/v3/processes/"+process.GUID+"/actions/scale", map[string]interface{}{
+		"instances":    0,
+		"memory_in_mb": b.memory,
+		"disk_in_mb":   b.disk,
+	}, nil)
+}
+
+// ReadPayload reads the environment of the app and the command of its web
+// process, which the tasks run with
+func (b *cfBackend) ReadPayload(codeID string) (*siderite.Payload, error) {
+	var current struct {
+		Var map[string]interface{} `json:"var"`
+	}
+	if err := b.do(http.MethodGet, "/v3/apps/"+codeID+"/environment_variables", nil, &current); err != nil {
+		return nil, err
+	}
+	var process cfResource
+	if err := b.do(http.MethodGet, "/v3/apps/"+codeID+"/processes/web", nil, &process); err != nil {
+		return nil, err
+	}
+	payload := &siderite.Payload{
+		Cmd: strings.Fields(process.Command),
+		Env: make(map[string]string, len(current.Var)),
+	}
+	for k, v := range current.Var {
+		if str, ok := v.(string); ok {
+			payload.Env[k] = str
+			continue
+		}
+		data, _ := json.Marshal(v)
+		payload.Env[k] = string(data)
+	}
+	return payload, nil
+}
+
+func (b *cfBackend) GetSchedules(codeName string) ([]iron.Schedule, error) {
+	app, err := b.findApp(codeName)
+	if err != nil || app == nil {
+		return nil, err
+	}
+	schedules := make([]iron.Schedule, 0)
+	if app.Metadata == nil {
+		return schedules, nil
+	}
+	for _, mode := range cfScheduleModes {
+		record, ok := app.Metadata.Annotations[cfAnnotation(mode)]
+		if !ok || record == "" {
+			continue
+		}
+		var cfg siderite.CronPayload
+		_ = json.Unmarshal([]byte(record), &cfg)
+		schedules = append(schedules, iron.Schedule{
+			ID:        cfScheduleID(app.GUID, mode, cfg.Name),
+			CodeName:  codeName,
+			Payload:   record,
+			Timeout:   cfg.Timeout,
+			Status:    "scheduled",
+			CreatedAt: app.CreatedAt,
+		})
+	}
+	return schedules, nil
+}
+
+// CancelSchedule removes the annotation of the schedule, unless it was
+// replaced by a newer schedule already
+func (b *cfBackend) CancelSchedule(scheduleID string) error {
+	parts := strings.SplitN(scheduleID, "/", 3)
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid schedule ID %s", scheduleID)
+	}
+	var app cfResource
+	if err := b.do(http.MethodGet, "/v3/apps/"+parts[0], nil, &app); err != nil {
+		return err
+	}
+	if app.Metadata == nil {
+		return nil
+	}
+	var cfg siderite.CronPayload
+	if err := json.Unmarshal([]byte(app.Metadata.Annotations[cfAnnotation(parts[1])]), &cfg); err != nil || cfg.Name != parts[2] {
+		return nil
+	}
+	return b.do(http.MethodPatch, "/v3/apps/"+parts[0], map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{cfAnnotation(parts[1]): nil},
+		},
+	}, nil)
+}
+
+// GetTasks pages through the tasks of the app, most recent first, until
+// since
+func (b *cfBackend) GetTasks(codeID string, since time.Time) ([]iron.Task, bool, error) {
+	query := url.Values{"order_by": {"-created_at"}, "per_page": {strconv.Itoa(taskPageSize)}}
+	if !since.IsZero() {
+		query.Set("created_ats[gte]", since.UTC().Format(time.RFC3339))
+	}
+	var resources []cfResource
+	truncated := false
+	for page := 1; ; page++ {
+		var tasks cfList
+		query.Set("page", strconv.Itoa(page))
+		if err := b.do(http.MethodGet, "/v3/apps/"+codeID+"/tasks?"+query.Encode(), nil, &tasks); err != nil {
+			return nil, false, err
+		}
+		resources = append(resources, tasks.Resources...)
+		if page >= tasks.Pagination.TotalPages {
+			break
+		}
+		if page == maxTaskPages {
+			truncated = true
+			break
+		}
+	}
+	converted := make([]iron.Task, 0, len(resources))
+	for _, t := range resources {
+		converted = append(converted, cfTask(codeID, t))
+	}
+	return codeTasks(converted, codeID), truncated, nil
+}
+
+// RunTask runs the command of the web process of the app once as a task
+// and waits until the task ended or timeout passed
+func (b *cfBackend) RunTask(codeID string, timeout time.Duration) (*iron.Task, error) {
+	var process cfResource
+	if err := b.do(http.MethodGet, "/v3/apps/"+codeID+"/processes/web", nil, &process); err != nil {
+		return nil, err
+	}
+	var task cfResource
+	if err := b.do(http.MethodPost, "/v3/apps/"+codeID+"/tasks", map[string]interface{}{
+		"command":      process.Command,
+		"memory_in_mb": b.memory,
+		"disk_in_mb":   b.disk,
+	}, &task); err != nil {
+		return nil, fmt.Errorf("running task: %w", err)
+	}
+	deadline := time.Now().Add(timeout)
+	for task.State != "SUCCEEDED" && task.State != "FAILED" {
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("task %s did not end within %s", task.GUID, timeout)
+		}
+		time.Sleep(cfPollInterval)
+		if err := b.do(http.MethodGet, "/v3/tasks/"+task.GUID, nil, &task); err != nil {
+			return nil, err
+		}
+	}
+	converted := cfTask(codeID, task)
+	return &converted, nil
+}
+
+// Gateway returns no gateway, the tasks are run through Cloud Foundry
+func (b *cfBackend) Gateway() gateway {
+	return gateway{}
+}
+
+// cfTask converts a task of the app with codeID to an Iron task
+func cfTask(codeID string, t cfResource) iron.Task {
+	task := iron.Task{
+		ID:        t.GUID,
+		CodeID:    codeID,
+		Status:    cfTaskStatus(t.State),
+		CreatedAt: t.CreatedAt,
+		UpdatedAt: t.UpdatedAt,
+	}
+	if t.Result != nil {
+		task.Msg = t.Result.FailureReason
+	}
+	if t.State == "SUCCEEDED" || t.State == "FAILED" {
+		task.EndTime = t.UpdatedAt
+	}
+	return task
+}
+
+func cfScheduleID(appGUID, mode, name string) string {
+	return appGUID + "/" + mode + "/" + name
+}
+
+func cfAnnotation(mode string) string {
+	return "function-" + mode
+}
+
+// cfTaskStatus maps the state of a Cloud Foundry task to an Iron status
+func cfTaskStatus(state string) string {
+	switch state {
+	case "PENDING":
+		return "queued"
+	case "RUNNING":
+		return "running"
+	case "SUCCEEDED":
+		return "complete"
+	case "FAILED":
+		return "error"
+	case "CANCELING":
+		return "cancelled"
+	}
+	return strings.ToLower(state)
+}
@@ -0,0 +1,373 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	siderite "github.com/philips-labs/siderite/models"
+	"github.com/philips-software/go-hsdp-api/iron"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// cfSimulator is a minimal Cloud Controller v3 API for a single space
+type cfSimulator struct {
+	sync.Mutex
+	url         string
+	apps        map[string]*cfApp
+	builds      map[string]string
+	lastGUID    int
+	processCmd  map[string]string
+	scaledMemMB map[string]int
+	tasks       map[string]*cfSimTask
+}
+
+type cfSimTask struct {
+	app     string
+	command string
+	memory  int
+	disk    int
+}
+
+type cfApp struct {
+	name        string
+	images      []string
+	users       []string
+	droplet     string
+	env         map[string]string
+	annotations map[string]string
+}
+
+func newCFSimulator() (*cfSimulator, *httptest.Server) {
+	sim := &cfSimulator{
+		apps:        make(map[string]*cfApp),
+		builds:      make(map[string]string),
+		processCmd:  make(map[string]string),
+		scaledMemMB: make(map[string]int),
+		tasks:       make(map[string]*cfSimTask),
+	}
+	server := httptest.NewServer(sim)
+	sim.url = server.URL
+	return sim, server
+}
+
+func (s *cfSimulator) guid() string {
+	s.lastGUID++
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", s.lastGUID)
+}
+
+func (s *cfSimulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	var body map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	reply := func(v interface{}) {
+		_ = json.NewEncoder(w).Encode(v)
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/":
+		reply(map[string]interface{}{"links": map[string]interface{}{"login": map[string]string{"href": s.url}}})
+	case r.URL.Path == "/oauth/token":
+		reply(map[string]string{"access_token": "token"})
+	case r.Header.Get("Authorization") != "Bearer token":
+		w.WriteHeader(http.StatusUnauthorized)
+	case r.URL.Path == "/v3/apps" && r.Method == http.MethodGet:
+		resources := make([]interface{}, 0)
+		for guid, app := range s.apps {
+			if app.name == r.URL.Query().Get("names") {
+				resources = append(resources, s.appJSON(guid))
+			}
+		}
+		reply(map[string]interface{}{"resources": resources})
+	case r.URL.Path == "/v3/apps" && r.Method == http.MethodPost:
+		guid := s.guid()
+		s.apps[guid] = &cfApp{name: body["name"].(string), env: map[string]string{}, annotations: map[string]string{}}
+		reply(s.appJSON(guid))
+	case r.URL.Path == "/v3/packages" && r.Method == http.MethodPost:
+		app := body["relationships"].(map[string]interface{})["app"].(map[string]interface{})["data"].(map[string]interface{})["guid"].(string)
+		s.apps[app].images = append(s.apps[app].images, body["data"].(map[string]interface{})["image"].(string))
+		user, _ := body["data"].(map[string]interface{})["username"].(string)
+		s.apps[app].users = append(s.apps[app].users, user)
+		reply(map[string]string{"guid": app + "|" + body["data"].(map[string]interface{})["image"].(string)})
+	case r.URL.Path == "/v3/packages":
+		reply(map[string]interface{}{"pagination": map[string]int{"total_results": len(s.apps[r.URL.Query().Get("app_guids")].images)}})
+	case r.URL.Path == "/v3/builds":
+		guid := s.guid()
+		s.builds[guid] = body["package"].(map[string]interface{})["guid"].(string)
+		reply(map[string]string{"guid": guid, "state": "STAGING"})
+	case parts[1] == "builds":
+		reply(map[string]interface{}{"guid": parts[2], "state": "STAGED", "droplet": map[string]string{"guid": s.builds[parts[2]]}})
+	case parts[1] == "processes" && len(parts) == 3:
+		s.processCmd[parts[2]] = body["command"].(string)
+	case parts[1] == "processes":
+		s.scaledMemMB[parts[2]] = int(body["memory_in_mb"].(float64))
+	case parts[1] == "tasks":
+		// Tasks end as soon as they are polled
+		reply(map[string]interface{}{"guid": parts[2], "state": "SUCCEEDED"})
+	case parts[1] == "apps":
+		app, ok := s.apps[parts[2]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch {
+		case len(parts) == 3 && r.Method == http.MethodDelete:
+			delete(s.apps, parts[2])
+			w.WriteHeader(http.StatusAccepted)
+		case len(parts) == 3 && r.Method == http.MethodPatch:
+			annotations := body["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+			for k, v := range annotations {
+				if v == nil {
+					delete(app.annotations, k)
+				} else {
+					app.annotations[k] = v.(string)
+				}
+			}
+			reply(s.appJSON(parts[2]))
+		case len(parts) == 3:
+			reply(s.appJSON(parts[2]))
+		case parts[3] == "relationships":
+			app.droplet = strings.Split(body["data"].(map[string]interface{})["guid"].(string), "|")[1]
+		case parts[3] == "droplets":
+			reply(map[string]string{"guid": "droplet", "image": app.droplet})
+		case parts[3] == "environment_variables" && r.Method == http.MethodPatch:
+			for k, v := range body["var"].(map[string]interface{}) {
+				if v == nil {
+					delete(app.env, k)
+				} else {
+					app.env[k] = v.(string)
+				}
+			}
+		case parts[3] == "environment_variables":
+			reply(map[string]interface{}{"var": app.env})
+		case parts[3] == "processes":
+			reply(map[string]string{"guid": "web-" + parts[2], "command": s.processCmd["web-"+parts[2]]})
+		case parts[3] == "tasks" && r.Method == http.MethodPost:
+			guid := s.guid()
+			s.tasks[guid] = &cfSimTask{
+				app:     parts[2],
+				command: body["command"].(string),
+				memory:  int(body["memory_in_mb"].(float64)),
+				disk:    int(body["disk_in_mb"].(float64)),
+			}
+			reply(map[string]interface{}{"guid": guid, "state": "RUNNING"})
+		case parts[3] == "tasks":
+			reply(map[string]interface{}{"resources": []interface{}{
+				map[string]interface{}{"guid": "t1", "state": "FAILED", "created_at": "2021-11-01T04:00:00Z", "updated_at": "2021-11-01T04:01:00Z", "result": map[string]string{"failure_reason": "exit status 1"}},
+				map[string]interface{}{"guid": "t2", "state": "SUCCEEDED", "created_at": "2021-11-02T04:00:00Z", "updated_at": "2021-11-02T04:01:00Z"},
+			}})
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *cfSimulator) appJSON(guid string) map[string]interface{} {
+	return map[string]interface{}{
+		"guid":     guid,
+		"name":     s.apps[guid].name,
+		"metadata": map[string]interface{}{"annotations": s.apps[guid].annotations},
+	}
+}
+
+func syncSchedule(t *testing.T, codeName string, env map[string]string) iron.Schedule {
+	payload, _ := json.Marshal(siderite.Payload{Version: "1", Type: "function", Env: env, Cmd: []string{"/app/server", "--migrate"}, Mode: "sync"})
+	cfg, err := json.Marshal(siderite.CronPayload{EncryptedPayload: string(payload), Type: "sync"})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return iron.Schedule{CodeName: codeName, Payload: string(cfg), Timeout: 60}
+}
+
+func TestCFBackend(t *testing.T) {
+	cfPollInterval = 0
+	sim, server := newCFSimulator()
+	defer server.Close()
+
+	b := &cfBackend{apiURL: server.URL, username: "user", password: "secret", spaceID: "space", memory: 256, disk: 512, config: &config.Config{}}
+	if !assert.Nil(t, b.Bootstrap()) {
+		return
+	}
+	code, err := b.RegisterCode("migrate-abc", "app:1")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "app:1", code.Image)
+	assert.Equal(t, 1, code.Rev)
+
+	code, err = b.RegisterCode("migrate-abc", "app:2")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "app:2", code.Image)
+	assert.Equal(t, 2, code.Rev)
+	assert.Len(t, sim.apps, 1)
+
+	first, err := b.CreateSchedule(syncSchedule(t, "migrate-abc", map[string]string{"a": "1", "b": "2"}))
+	assert.Nil(t, err)
+	second, err := b.CreateSchedule(syncSchedule(t, "migrate-abc", map[string]string{"a": "1"}))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"a": "1"}, sim.apps[code.ID].env)
+	assert.Equal(t, "/app/server --migrate", sim.processCmd["web-"+code.ID])
+	assert.Equal(t, 256, sim.scaledMemMB["web-"+code.ID])
+
+	// Changed outside Terraform
+	sim.apps[code.ID].env["b"] = "3"
+	payload, err := b.ReadPayload(code.ID)
+	if assert.Nil(t, err) && assert.NotNil(t, payload) {
+		assert.Equal(t, []string{"/app/server", "--migrate"}, payload.Cmd)
+		assert.Equal(t, map[string]string{"a": "1", "b": "3"}, payload.Env)
+	}
+
+	// Invoking the function runs it once as a task
+	d := schema.TestResourceDataRaw(t, ResourceFunctionInvocation().Schema, map[string]interface{}{
+		"function_id": code.ID + "-signature",
+		"backend": []interface{}{map[string]interface{}{
+			"cf": []interface{}{map[string]interface{}{
+				"api_url":  server.URL,
+				"username": "user",
+				"password": "secret",
+				"space_id": "space",
+				"memory":   256,
+				"disk":     512,
+			}},
+		}},
+	})
+	diags := resourceFunctionInvocationCreate(context.Background(), d, &config.Config{})
+	if assert.False(t, diags.HasError(), diags) && assert.Len(t, sim.tasks, 1) {
+		task := sim.tasks[d.Get("task_id").(string)]
+		if assert.NotNil(t, task) {
+			assert.Equal(t, code.ID, task.app)
+			assert.Equal(t, "/app/server --migrate", task.command)
+			assert.Equal(t, 256, task.memory)
+			assert.Equal(t, 512, task.disk)
+		}
+		assert.Equal(t, "complete", d.Get("task_status"))
+	}
+
+	// Cancelling the replaced schedule keeps the new one
+	assert.Nil(t, b.CancelSchedule(first))
+	schedules, err := b.GetSchedules("migrate-abc")
+	assert.Nil(t, err)
+	if assert.Len(t, schedules, 1) {
+		assert.Equal(t, second, schedules[0].ID)
+		assert.Equal(t, 60, schedules[0].Timeout)
+	}
+
+	cron, _ := json.Marshal(siderite.CronPayload{Schedule: "0 4 * * *", EncryptedPayload: "{}"})
+	_, err = b.CreateSchedule(iron.Schedule{CodeName: "migrate-abc", Payload: string(cron)})
+	assert.Equal(t, errNoScheduler, err)
+
+	tasks, truncated, err := b.GetTasks(code.ID, time.Time{})
+	assert.Nil(t, err)
+	assert.False(t, truncated)
+	if assert.Len(t, tasks, 2) {
+		assert.Equal(t, "complete", tasks[0].Status)
+		assert.Equal(t, "error", tasks[1].Status)
+		assert.Equal(t, "exit status 1", tasks[1].Msg)
+	}
+
+	assert.Nil(t, b.DeleteCode(code.ID))
+	code, err = b.GetCode(code.ID)
+	assert.Nil(t, err)
+	assert.Nil(t, code)
+}
+
+// cfFunctionConfig returns the configuration of a function on the cf
+// backend of the simulator at url
+func cfFunctionConfig(url, image string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":         "migrate",
+		"docker_image": image,
+		"backend": []interface{}{map[string]interface{}{
+			"cf": []interface{}{map[string]interface{}{
+				"api_url":  url,
+				"username": "user",
+				"password": "secret",
+				"space_id": "space",
+			}},
+		}},
+	}
+}
+
+// updateFunction applies raw as an update to the function in d
+func updateFunction(t *testing.T, d *schema.ResourceData, raw map[string]interface{}, c *config.Config) (*schema.ResourceData, diag.Diagnostics) {
+	resource := ResourceFunction()
+	state := d.State()
+	diff, err := resource.Diff(context.Background(), state, terraform.NewResourceConfigRaw(raw), c)
+	if !assert.Nil(t, err) {
+		return nil, diag.FromErr(err)
+	}
+	d, err = schema.InternalMap(resource.Schema).Data(state, diff)
+	if !assert.Nil(t, err) {
+		return nil, diag.FromErr(err)
+	}
+	return d, resourceFunctionUpdate(context.Background(), d, c)
+}
+
+func TestCFBackendUpdateKeepsCode(t *testing.T) {
+	cfPollInterval = 0
+	sim, server := newCFSimulator()
+	defer server.Close()
+
+	c := &config.Config{}
+	raw := cfFunctionConfig(server.URL, "app@sha256:1111111111111111111111111111111111111111111111111111111111111111")
+	d := schema.TestResourceDataRaw(t, ResourceFunction().Schema, raw)
+	if diags := resourceFunctionCreate(context.Background(), d, c); !assert.False(t, diags.HasError(), diags) {
+		return
+	}
+	id, endpoint := d.Id(), d.Get("endpoint")
+
+	raw["docker_image"] = "app@sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	d, diags := updateFunction(t, d, raw, c)
+	if !assert.False(t, diags.HasError(), diags) {
+		return
+	}
+	// The deploy is a new revision of the same code
+	assert.Equal(t, id, d.Id())
+	assert.Equal(t, endpoint, d.Get("endpoint"))
+	if assert.Len(t, sim.apps, 1) {
+		for _, app := range sim.apps {
+			assert.Len(t, app.images, 2)
+		}
+	}
+	assert.Equal(t, 2, d.Get(activeVersionField))
+	assert.Equal(t, 2, d.Get("versions.1.code_revision"))
+	assert.Equal(t, "sha256:2222222222222222222222222222222222222222222222222222222222222222", d.Get("versions.1.image_digest"))
+}
+
+func TestCFBackendUpdateLogsIn(t *testing.T) {
+	cfPollInterval = 0
+	sim, server := newCFSimulator()
+	defer server.Close()
+
+	c := &config.Config{}
+	raw := cfFunctionConfig(server.URL, "app@sha256:1111111111111111111111111111111111111111111111111111111111111111")
+	raw["docker_credentials"] = map[string]interface{}{"username": "robot", "password": "secret"}
+	d := schema.TestResourceDataRaw(t, ResourceFunction().Schema, raw)
+	if diags := resourceFunctionCreate(context.Background(), d, c); !assert.False(t, diags.HasError(), diags) {
+		return
+	}
+
+	// Only the image changes, the backend of the update has not seen the
+	// credentials yet
+	raw["docker_image"] = "app@sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	_, diags := updateFunction(t, d, raw, c)
+	assert.False(t, diags.HasError(), diags)
+	if assert.Len(t, sim.apps, 1) {
+		for _, app := range sim.apps {
+			assert.Equal(t, []string{"robot", "robot"}, app.users, "every package is pulled with the credentials")
+		}
+	}
+}
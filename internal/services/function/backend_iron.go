@@ -0,0 +1,241 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/philips-labs/ferrite/server"
+	siderite "github.com/philips-labs/siderite/models"
+	"github.com/philips-software/go-hsdp-api/iron"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+)
+
+// ironBackend runs functions on Iron. The siderite backend is HSDP Iron,
+// the ferrite backend is an Iron compatible server which provides the
+// project and cluster when bootstrapped.
+type ironBackend struct {
+	kind    string
+	config  iron.Config
+	gateway gateway
+	client  *iron.Client
+	// provider creates the HTTP client for the calls the Iron client does
+	// not support
+	provider   *config.Config
+	httpClient *http.Client
+}
+
+func newIronBackend(kind string, settings map[string]interface{}, c *config.Config) *ironBackend {
+	get := func(key string) string {
+		str, _ := settings[key].(string)
+		return str
+	}
+	return &ironBackend{
+		kind: kind,
+		config: iron.Config{
+			BaseURL:  get("base_url"),
+			Token:    get("token"),
+			DebugLog: c.DebugLog,
+		},
+		gateway: gateway{
+			Upstream: get("upstream"),
+			Token:    get("gateway_token"),
+			AuthType: get("auth_type"),
+		},
+		provider: c,
+	}
+}
+
+func (b *ironBackend) Bootstrap() error {
+	if b.kind == backendFerrite {
+		bootstrap, err := server.Bootstrap(b.config.BaseURL, b.config.Token)
+		if err != nil {
+			return fmt.Errorf("error bootstrapping ferrite: %w", err)
+		}
+		b.config.Project = bootstrap.ProjectID
+		b.config.ProjectID = bootstrap.ProjectID
+		b.config.ClusterInfo = []iron.ClusterInfo{{
+			ClusterID: bootstrap.ClusterID,
+			Pubkey:    bootstrap.PublicKey,
+		}}
+	}
+	if len(b.config.ClusterInfo) == 0 || b.config.ClusterInfo[0].ClusterID == "" {
+		return fmt.Errorf("invalid Iron.io discovery: missing cluster")
+	}
+	client, err := iron.NewClient(&b.config)
+	if err != nil {
+		return fmt.Errorf("iron.NewClient: %w", err)
+	}
+	b.client = client
+	b.httpClient = b.provider.FunctionHTTPClient(b.config.BaseURL)
+	return nil
+}
+
+func (b *ironBackend) DockerLogin(credentials dockerCredentials) error {
+	ok, _, err := b.client.Codes.DockerLogin(iron.DockerCredentials{
+		Email:         credentials.Email,
+		Username:      credentials.Username,
+		Password:      credentials.Password,
+		ServerAddress: credentials.ServerAddress,
+	})
+	if !ok {
+		return fmt.Errorf("invalid docker credentials for '%s': %w", credentials.ServerAddress, err)
+	}
+	return nil
+}
+
+func (b *ironBackend) RegisterCode(codeName, image string) (*iron.Code, error) {
+	code, resp, err := b.client.Codes.CreateOrUpdateCode(iron.Code{
+		Name:      codeName,
+		Image:     image,
+		ProjectID: b.config.ProjectID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("CreateOrUpdateCode(%s): %w", codeName, err)
+	}
+	if resp == nil || resp.StatusCode != http.StatusOK || code.Image != image {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		return nil, fmt.Errorf("failed to register code '%s': got HTTP %d", image, status)
+	}
+	return code, nil
+}
+
+func (b *ironBackend) GetCode(codeID string) (*iron.Code, error) {
+	code, resp, err := b.client.Codes.GetCode(codeID)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("GetCode(%s): %w", codeID, err)
+	}
+	if code == nil || code.ID != codeID {
+		return nil, nil
+	}
+	return code, nil
+}
+
+// DeleteCode deletes the code, which cascade deletes its schedules as well
+func (b *ironBackend) DeleteCode(codeID string) error {
+	_, _, err := b.client.Codes.DeleteCode(codeID)
+	return err
+}
+
+func (b *ironBackend) EncryptPayload(payload []byte) (string, error) {
+	return iron.EncryptPayload([]byte(b.config.ClusterInfo[0].Pubkey), payload)
+}
+
+// ReadPayload returns nil, the payloads are encrypted with the public key
+// of the cluster
+func (b *ironBackend) ReadPayload(string) (*siderite.Payload, error) {
+	return nil, nil
+}
+
+func (b *ironBackend) CreateSchedule(schedule iron.Schedule) (string, error) {
+	schedule.Cluster = b.config.ClusterInfo[0].ClusterID
+	created, resp, err := b.client.Schedules.CreateSchedule(schedule)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("got HTTP %d", resp.StatusCode)
+	}
+	if err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (b *ironBackend) GetSchedules(codeName string) ([]iron.Schedule, error) {
+	schedules, _, err := b.client.Schedules.GetSchedulesWithCode(codeName)
+	if err != nil {
+		return nil, fmt.Errorf("GetSchedulesWithCode(%s): %w", codeName, err)
+	}
+	return *schedules, nil
+}
+
+func (b *ironBackend) CancelSchedule(scheduleID string) error {
+	_, _, err := b.client.Schedules.CancelSchedule(scheduleID)
+	return err
+}
+
+// GetTasks pages through the tasks of the code, most recent first, until
+// since. The Iron client only returns the first page of the project tasks.
+func (b *ironBackend) GetTasks(codeID string, since time.Time) ([]iron.Task, bool, error) {
+	code, err := b.GetCode(codeID)
+	if err != nil || code == nil {
+		return []iron.Task{}, false, err
+	}
+	seen := make(map[string]bool)
+	var tasks []iron.Task
+	for page := 0; page < maxTaskPages; page++ {
+		found, err := b.taskPage(code.Name, since, page)
+		if err != nil {
+			return nil, false, fmt.Errorf("listing tasks of '%s': %w", code.Name, err)
+		}
+		added := 0
+		for _, t := range found {
+			if !seen[t.ID] {
+				seen[t.ID] = true
+				tasks = append(tasks, t)
+				added++
+			}
+		}
+		// Servers which do not page return everything at once
+		last := len(found) != taskPageSize || added == 0
+		if !since.IsZero() && len(found) > 0 && taskTime(found[len(found)-1]).Before(since) {
+			last = true
+		}
+		if last {
+			return codeTasks(tasks, codeID), false, nil
+		}
+	}
+	return codeTasks(tasks, codeID), true, nil
+}
+
+// taskPage returns a page of the tasks of the code codeName created since
+func (b *ironBackend) taskPage(codeName string, since time.Time, page int) ([]iron.Task, error) {
+	query := url.Values{
+		"code_name": {codeName},
+		"page":      {strconv.Itoa(page)},
+		"per_page":  {strconv.Itoa(taskPageSize)},
+	}
+	if !since.IsZero() {
+		query.Set("from_time", strconv.FormatInt(since.Unix(), 10))
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/2/projects/%s/tasks?%s",
+		strings.TrimSuffix(b.config.BaseURL, "/"), b.config.ProjectID, query.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "OAuth "+b.config.Token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got HTTP %d", resp.StatusCode)
+	}
+	var result struct {
+		Tasks []iron.Task `json:"tasks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Tasks, nil
+}
+
+// RunTask is refused, Iron tasks of a function are queued by its gateway
+// with the encrypted payload of its schedule
+func (b *ironBackend) RunTask(string, time.Duration) (*iron.Task, error) {
+	return nil, errNoTasks
+}
+
+func (b *ironBackend) Gateway() gateway {
+	return b.gateway
+}
@@ -0,0 +1,87 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/philips-software/go-hsdp-api/iron"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIronBackendGetTasks(t *testing.T) {
+	start := time.Date(2021, 11, 1, 0, 0, 0, 0, time.UTC)
+	// 250 tasks of the code, one a minute, most recent first
+	var tasks []iron.Task
+	for i := 249; i >= 0; i-- {
+		at := start.Add(time.Duration(i) * time.Minute)
+		tasks = append(tasks, iron.Task{ID: fmt.Sprintf("t%d", i), CodeID: "c1", CodeName: "migrate", Status: "complete", CreatedAt: &at})
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "OAuth token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/2/projects/p1/codes/c1":
+			_ = json.NewEncoder(w).Encode(iron.Code{ID: "c1", Name: "migrate"})
+		case "/2/projects/p1/tasks":
+			query := r.URL.Query()
+			assert.Equal(t, "migrate", query.Get("code_name"))
+			page, _ := strconv.Atoi(query.Get("page"))
+			perPage, _ := strconv.Atoi(query.Get("per_page"))
+			var matching []iron.Task
+			for _, task := range tasks {
+				if from := query.Get("from_time"); from != "" {
+					unix, _ := strconv.ParseInt(from, 10, 64)
+					if task.CreatedAt.Before(time.Unix(unix, 0)) {
+						continue
+					}
+				}
+				matching = append(matching, task)
+			}
+			result := make([]iron.Task, 0)
+			if page*perPage < len(matching) {
+				end := (page + 1) * perPage
+				if end > len(matching) {
+					end = len(matching)
+				}
+				result = matching[page*perPage : end]
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"tasks": result})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	b := newIronBackend(backendSiderite, map[string]interface{}{"base_url": server.URL, "token": "token"}, &config.Config{})
+	b.config.ProjectID = "p1"
+	b.config.ClusterInfo = []iron.ClusterInfo{{ClusterID: "cluster"}}
+	if !assert.Nil(t, b.Bootstrap()) {
+		return
+	}
+
+	found, truncated, err := b.GetTasks("c1", time.Time{})
+	assert.Nil(t, err)
+	assert.False(t, truncated)
+	if assert.Len(t, found, 250, "tasks beyond the first page are read") {
+		assert.Equal(t, "t249", found[0].ID)
+		assert.Equal(t, "t0", found[249].ID)
+	}
+
+	found, truncated, err = b.GetTasks("c1", start.Add(200*time.Minute))
+	assert.Nil(t, err)
+	assert.False(t, truncated)
+	assert.Len(t, found, 50)
+
+	found, truncated, err = b.GetTasks("unknown", time.Time{})
+	assert.Nil(t, err)
+	assert.False(t, truncated)
+	assert.Len(t, found, 0)
+}
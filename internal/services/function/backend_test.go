@@ -0,0 +1,85 @@
+package function
+
+import (
+	"testing"
+
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandBackend(t *testing.T) {
+	c := &config.Config{}
+	credentials := []interface{}{map[string]interface{}{
+		"credentials": map[string]interface{}{
+			"type":                      "siderite",
+			"base_url":                  "https://iron.example.com",
+			"project_id":                "project",
+			"token":                     "token",
+			"cluster_info_0_cluster_id": "cluster",
+			"cluster_info_0_pubkey":     "key",
+			"siderite_upstream":         "gateway.example.com",
+			"siderite_token":            "gateway-token",
+			"siderite_auth_type":        "token",
+		},
+	}}
+	b, err := expandBackend(credentials, c)
+	if !assert.Nil(t, err) {
+		return
+	}
+	iron, ok := b.(*ironBackend)
+	if assert.True(t, ok) {
+		assert.Equal(t, "cluster", iron.config.ClusterInfo[0].ClusterID)
+		assert.Equal(t, gateway{Upstream: "gateway.example.com", Token: "gateway-token", AuthType: "token"}, iron.Gateway())
+	}
+
+	typed := []interface{}{map[string]interface{}{
+		"siderite": []interface{}{map[string]interface{}{
+			"base_url":           "https://iron.example.com/",
+			"project_id":         "project",
+			"project":            "",
+			"email":              "",
+			"password":           "",
+			"user_id":            "",
+			"token":              "rotated",
+			"cluster_id":         "cluster",
+			"cluster_name":       "",
+			"cluster_public_key": "key",
+			"upstream":           "gateway.example.com",
+			"gateway_token":      "gateway-token",
+			"auth_type":          "token",
+		}},
+	}}
+	assert.Equal(t, backendIdentity(credentials), backendIdentity(typed), "moving to the typed block keeps the function")
+
+	cf := []interface{}{map[string]interface{}{
+		"cf": []interface{}{map[string]interface{}{
+			"api_url":  "https://api.cf.example.com",
+			"username": "user",
+			"password": "secret",
+			"space_id": "5d6e4c1a-2b7f-4e34-9f38-8a1c2f3d4e5f",
+			"memory":   512,
+			"disk":     1024,
+		}},
+	}}
+	assert.NotEqual(t, backendIdentity(typed), backendIdentity(cf))
+
+	_, err = expandBackend([]interface{}{map[string]interface{}{
+		"credentials": map[string]interface{}{"type": "lambda"},
+	}}, c)
+	assert.NotNil(t, err)
+}
+
+func TestParseFunctionID(t *testing.T) {
+	codeID, signature, ok := parseFunctionID("61a5e0f5c1d2-3f2b6c2e")
+	assert.True(t, ok)
+	assert.Equal(t, "61a5e0f5c1d2", codeID)
+	assert.Equal(t, "3f2b6c2e", signature)
+
+	codeID, signature, ok = parseFunctionID("5d6e4c1a-2b7f-4e34-9f38-8a1c2f3d4e5f-3f2b6c2e")
+	assert.True(t, ok)
+	assert.Equal(t, "5d6e4c1a-2b7f-4e34-9f38-8a1c2f3d4e5f", codeID)
+	assert.Equal(t, "3f2b6c2e", signature)
+
+	_, _, ok = parseFunctionID("malformed")
+	assert.False(t, ok)
+}
@@ -0,0 +1,178 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/philips-software/go-hsdp-api/iron"
+	"github.com/philips-software/terraform-provider-hsdp/internal/tools"
+)
+
+func DataSourceFunctionTasks() *schema.Resource {
+	return &schema.Resource{
+		Description: `The ` + "`hsdp_function_tasks`" + ` data source retrieves the recent tasks of a function.`,
+
+		ReadContext: dataSourceFunctionTasksRead,
+
+		Schema: map[string]*schema.Schema{
+			"function_id": {
+				Description: "The ID of the hsdp_function.",
+				Type:        schema.TypeString,
+				Required:    true,
+			},
+			"backend": backendSchema(),
+			"statuses": {
+				Type:     schema.TypeSet,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validation.StringInSlice(taskStatuses, false),
+				},
+			},
+			"since": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.IsRFC3339Time,
+			},
+			"until": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.IsRFC3339Time,
+			},
+			"ids": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem:     tools.StringSchema(),
+			},
+			"tasks": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"status": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"message": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"schedule_id": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"code_revision": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"created_at": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"started_at": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"ended_at": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"duration": {
+							Description: "The run time in milliseconds.",
+							Type:        schema.TypeInt,
+							Computed:    true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func dataSourceFunctionTasksRead(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	b, err := newBackend(d, m)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	functionID := d.Get("function_id").(string)
+	codeID, _, ok := parseFunctionID(functionID)
+	if !ok {
+		return diag.FromErr(fmt.Errorf("invalid function_id '%s'", functionID))
+	}
+
+	filter, err := expandTaskFilter(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	var diags diag.Diagnostics
+	tasks, truncated, err := b.GetTasks(codeID, filter.since)
+	if err != nil {
+		return diag.FromErr(fmt.Errorf("reading tasks: %w", err))
+	}
+	if truncated {
+		diags = append(diags, diag.Diagnostic{
+			Severity: diag.Warning,
+			Summary:  "task history truncated",
+			Detail:   fmt.Sprintf("only the %d most recent tasks were read, set 'since' to narrow the window", taskPageSize*maxTaskPages),
+		})
+	}
+	ids := make([]string, 0)
+	flattened := make([]interface{}, 0)
+	for _, t := range tasks {
+		if !filter.matches(t) {
+			continue
+		}
+		ids = append(ids, t.ID)
+		flattened = append(flattened, flattenTask(t))
+	}
+	d.SetId(functionID)
+	_ = d.Set("ids", ids)
+	_ = d.Set("tasks", flattened)
+	return diags
+}
+
+func expandTaskFilter(d *schema.ResourceData) (taskFilter, error) {
+	filter := taskFilter{
+		statuses: tools.ExpandStringList(d.Get("statuses").(*schema.Set).List()),
+	}
+	var err error
+	if since := d.Get("since").(string); since != "" {
+		if filter.since, err = time.Parse(time.RFC3339, since); err != nil {
+			return filter, fmt.Errorf("since: %w", err)
+		}
+	}
+	if until := d.Get("until").(string); until != "" {
+		if filter.until, err = time.Parse(time.RFC3339, until); err != nil {
+			return filter, fmt.Errorf("until: %w", err)
+		}
+	}
+	return filter, nil
+}
+
+func flattenTask(t iron.Task) map[string]interface{} {
+	timeOf := func(at *time.Time) string {
+		if at == nil {
+			return ""
+		}
+		return formatTime(*at)
+	}
+	return map[string]interface{}{
+		"id":            t.ID,
+		"status":        t.Status,
+		"message":       t.Msg,
+		"schedule_id":   t.ScheduleID,
+		"code_revision": t.CodeRev,
+		"created_at":    timeOf(t.CreatedAt),
+		"started_at":    timeOf(t.StartTime),
+		"ended_at":      timeOf(t.EndTime),
+		"duration":      t.Duration,
+	}
+}
@@ -0,0 +1,245 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+)
+
+const (
+	authTypeNone  = "none"
+	authTypeToken = "token"
+	authTypeIAM   = "iam"
+
+	defaultInvocationTimeout = 5 * time.Minute
+	// maxResponseBody caps the response body kept in state
+	maxResponseBody = 1 << 20
+)
+
+// invocation is a call of the sync endpoint of a function, or a task run
+// of a function by its backend
+type invocation struct {
+	Endpoint     string
+	FunctionID   string
+	Backend      []interface{}
+	AuthType     string
+	Token        string
+	Payload      string
+	Timeout      time.Duration
+	FailOnError  bool
+	ResponseBody string
+	StatusCode   int
+	TaskID       string
+	TaskStatus   string
+	Duration     time.Duration
+}
+
+// invocationSchema returns the arguments and results of an invocation. Any
+// change of the arguments invokes the function again.
+func invocationSchema() map[string]*schema.Schema {
+	taskBackend := backendSchema()
+	taskBackend.Required = false
+	taskBackend.Optional = true
+	taskBackend.ForceNew = true
+	taskBackend.RequiredWith = []string{"function_id"}
+
+	return map[string]*schema.Schema{
+		"endpoint": {
+			Description:  "The sync endpoint of the function.",
+			Type:         schema.TypeString,
+			Optional:     true,
+			ForceNew:     true,
+			ValidateFunc: validation.IsURLWithHTTPorHTTPS,
+			ExactlyOneOf: []string{"endpoint", "function_id"},
+		},
+		"function_id": {
+			Description:  "The ID of a hsdp_function with a cf backend, which is run once as a task.",
+			Type:         schema.TypeString,
+			Optional:     true,
+			ForceNew:     true,
+			ExactlyOneOf: []string{"endpoint", "function_id"},
+			RequiredWith: []string{"backend"},
+		},
+		"backend": taskBackend,
+		"auth_type": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			ForceNew:     true,
+			Default:      authTypeToken,
+			ValidateFunc: validation.StringInSlice([]string{authTypeNone, authTypeToken, authTypeIAM}, false),
+		},
+		"token": {
+			Type:      schema.TypeString,
+			Optional:  true,
+			ForceNew:  true,
+			Sensitive: true,
+		},
+		"payload": {
+			Description:  "The JSON payload to post.",
+			Type:         schema.TypeString,
+			Optional:     true,
+			ForceNew:     true,
+			Default:      "{}",
+			ValidateFunc: validation.StringIsJSON,
+		},
+		"timeout": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			ForceNew:     true,
+			Default:      defaultInvocationTimeout.String(),
+			ValidateFunc: validateDuration,
+		},
+		"fail_on_error": {
+			Description: "Fail when the function does not return a 2xx status.",
+			Type:        schema.TypeBool,
+			Optional:    true,
+			ForceNew:    true,
+			Default:     true,
+		},
+		"response_body": {
+			Type:      schema.TypeString,
+			Computed:  true,
+			Sensitive: true,
+		},
+		"status_code": {
+			Type:     schema.TypeInt,
+			Computed: true,
+		},
+		"task_id": {
+			Type:     schema.TypeString,
+			Computed: true,
+		},
+		"task_status": {
+			Type:     schema.TypeString,
+			Computed: true,
+		},
+		"duration": {
+			Type:     schema.TypeString,
+			Computed: true,
+		},
+	}
+}
+
+func expandInvocation(d *schema.ResourceData) (*invocation, error) {
+	timeout, err := time.ParseDuration(d.Get("timeout").(string))
+	if err != nil {
+		return nil, fmt.Errorf("timeout: %w", err)
+	}
+	inv := &invocation{
+		Endpoint:    d.Get("endpoint").(string),
+		FunctionID:  d.Get("function_id").(string),
+		Backend:     d.Get("backend").([]interface{}),
+		AuthType:    d.Get("auth_type").(string),
+		Token:       d.Get("token").(string),
+		Payload:     d.Get("payload").(string),
+		Timeout:     timeout,
+		FailOnError: d.Get("fail_on_error").(bool),
+	}
+	if inv.FunctionID != "" {
+		if inv.Payload != "{}" {
+			return nil, fmt.Errorf("'payload' cannot be passed to a task, set 'endpoint' instead")
+		}
+		return inv, nil
+	}
+	if inv.AuthType == authTypeToken && inv.Token == "" {
+		return nil, fmt.Errorf("'token' must be set when 'auth_type' is '%s'", authTypeToken)
+	}
+	return inv, nil
+}
+
+// invoke posts the payload to the endpoint and records the response in inv
+func (inv *invocation) invoke(ctx context.Context, c *config.Config) error {
+	if inv.FunctionID != "" {
+		return inv.runTask(c)
+	}
+	ctx, cancel := context.WithTimeout(ctx, inv.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, inv.Endpoint, bytes.NewBufferString(inv.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	switch inv.AuthType {
+	case authTypeToken:
+		req.Header.Set("Authorization", "Token "+inv.Token)
+	case authTypeIAM:
+		client, err := c.IAMClient()
+		if err != nil {
+			return err
+		}
+		token := client.Token()
+		if token == "" {
+			return fmt.Errorf("no IAM token available, check the IAM credentials of the provider")
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	start := time.Now()
+	resp, err := c.FunctionInvocationClient(inv.Endpoint).Do(req)
+	if err != nil {
+		return fmt.Errorf("invoking %s: %w", inv.Endpoint, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	inv.Duration = time.Since(start)
+	if err != nil {
+		return fmt.Errorf("reading response of %s: %w", inv.Endpoint, err)
+	}
+	inv.ResponseBody = string(body)
+	inv.StatusCode = resp.StatusCode
+	if inv.FailOnError && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return fmt.Errorf("function %s returned status %d: %s", inv.Endpoint, resp.StatusCode, inv.ResponseBody)
+	}
+	return nil
+}
+
+// runTask runs the function once as a task of its backend and records the
+// task in inv. The failure reason of the task is kept as the response body.
+func (inv *invocation) runTask(c *config.Config) error {
+	codeID, _, ok := parseFunctionID(inv.FunctionID)
+	if !ok {
+		return fmt.Errorf("invalid function_id '%s'", inv.FunctionID)
+	}
+	b, err := expandBackend(inv.Backend, c)
+	if err != nil {
+		return err
+	}
+	if err := b.Bootstrap(); err != nil {
+		return err
+	}
+	start := time.Now()
+	task, err := b.RunTask(codeID, inv.Timeout)
+	inv.Duration = time.Since(start)
+	if err != nil {
+		return fmt.Errorf("running function %s: %w", inv.FunctionID, err)
+	}
+	inv.TaskID = task.ID
+	inv.TaskStatus = task.Status
+	inv.ResponseBody = task.Msg
+	if inv.FailOnError && task.Status != "complete" {
+		return fmt.Errorf("task %s of function %s ended with status %s: %s", task.ID, inv.FunctionID, task.Status, task.Msg)
+	}
+	return nil
+}
+
+func (inv *invocation) setResults(d *schema.ResourceData) {
+	_ = d.Set("response_body", inv.ResponseBody)
+	_ = d.Set("status_code", inv.StatusCode)
+	_ = d.Set("task_id", inv.TaskID)
+	_ = d.Set("task_status", inv.TaskStatus)
+	_ = d.Set("duration", inv.Duration.String())
+}
+
+func validateDuration(v interface{}, k string) (warns []string, errs []error) {
+	if _, err := time.ParseDuration(v.(string)); err != nil {
+		errs = append(errs, fmt.Errorf("%q: %w", k, err))
+	}
+	return
+}
@@ -0,0 +1,98 @@
+package function
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvoke(t *testing.T) {
+	var auth, payload string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		payload = string(body)
+		if payload == `{"fail":true}` {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(`{"error":"boom"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, ResourceFunctionInvocation().Schema, map[string]interface{}{
+		"endpoint": server.URL + "/function/sync",
+		"token":    "secret",
+		"payload":  `{"name":"hsdp"}`,
+	})
+	inv, err := expandInvocation(d)
+	if !assert.Nil(t, err) {
+		return
+	}
+	c := &config.Config{}
+	assert.Nil(t, inv.invoke(context.Background(), c))
+	assert.Equal(t, "Token secret", auth)
+	assert.Equal(t, `{"name":"hsdp"}`, payload)
+	inv.setResults(d)
+	assert.Equal(t, http.StatusOK, d.Get("status_code"))
+	assert.Equal(t, `{"ok":true}`, d.Get("response_body"))
+
+	inv.Payload = `{"fail":true}`
+	assert.NotNil(t, inv.invoke(context.Background(), c), "a 500 fails the invocation")
+	inv.FailOnError = false
+	assert.Nil(t, inv.invoke(context.Background(), c))
+	assert.Equal(t, http.StatusInternalServerError, inv.StatusCode)
+	assert.Equal(t, `{"error":"boom"}`, inv.ResponseBody)
+}
+
+func TestInvokeIsNotRetried(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	inv := &invocation{
+		Endpoint:    server.URL + "/function/sync",
+		AuthType:    authTypeNone,
+		Payload:     `{}`,
+		Timeout:     defaultInvocationTimeout,
+		FailOnError: true,
+	}
+	assert.NotNil(t, inv.invoke(context.Background(), &config.Config{}))
+	assert.Equal(t, 1, calls, "the function may have run, so it is not invoked again")
+	assert.Equal(t, http.StatusServiceUnavailable, inv.StatusCode)
+}
+
+func TestExpandInvocationRequiresToken(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, ResourceFunctionInvocation().Schema, map[string]interface{}{
+		"endpoint": "https://function.example.com/function/sync",
+	})
+	_, err := expandInvocation(d)
+	assert.NotNil(t, err, "token auth needs a token")
+	_ = d.Set("auth_type", authTypeNone)
+	_, err = expandInvocation(d)
+	assert.Nil(t, err)
+}
+
+func TestExpandInvocationTask(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, ResourceFunctionInvocation().Schema, map[string]interface{}{
+		"function_id": "00000000-0000-0000-0000-000000000001-signature",
+	})
+	inv, err := expandInvocation(d)
+	if assert.Nil(t, err, "tasks need no token") {
+		assert.Equal(t, "00000000-0000-0000-0000-000000000001-signature", inv.FunctionID)
+	}
+	_ = d.Set("payload", `{"version":2}`)
+	_, err = expandInvocation(d)
+	assert.NotNil(t, err, "tasks take no payload")
+}
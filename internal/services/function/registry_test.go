@@ -0,0 +1,55 @@
+package function
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveDigest(t *testing.T) {
+	const digest = "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b"
+	var scope string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			username, password, _ := r.BasicAuth()
+			if username != "user" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			scope = r.URL.Query().Get("scope")
+			_, _ = w.Write([]byte(`{"token":"registry-token"}`))
+		case r.Header.Get("Authorization") != "Bearer registry-token":
+			w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+r.Host+`/token",service="registry",scope="repository:team/app:pull,push"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.Method == http.MethodHead && r.URL.Path == "/v2/team/app/manifests/1.0":
+			w.Header().Set("Docker-Content-Digest", digest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	registry := strings.TrimPrefix(server.URL, "http://")
+	credentials := &dockerCredentials{Username: "user", Password: "secret"}
+	resolved, err := resolveDigest(http.DefaultClient, registry+"/team/app:1.0", credentials)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, digest, resolved)
+	assert.Equal(t, "repository:team/app:pull,push", scope)
+	assert.Equal(t, registry+"/team/app@"+digest, pinnedImage(registry+"/team/app:1.0", resolved))
+	assert.Equal(t, digest, imageDigest(pinnedImage(registry+"/team/app:1.0", resolved)))
+
+	_, err = resolveDigest(http.DefaultClient, registry+"/team/app:2.0", credentials)
+	assert.Error(t, err, "unknown tags are not resolved")
+	_, err = resolveDigest(http.DefaultClient, registry+"/team/app:1.0", nil)
+	assert.Error(t, err)
+
+	resolved, err = resolveDigest(nil, "philipslabs/hello@"+digest, nil)
+	assert.Nil(t, err, "pinned images are not looked up")
+	assert.Equal(t, digest, resolved)
+}
@@ -2,17 +2,19 @@ package function
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"log"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/docker/distribution/reference"
 	"github.com/google/uuid"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	"github.com/philips-labs/ferrite/server"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	siderite "github.com/philips-labs/siderite/models"
 	"github.com/philips-software/go-hsdp-api/iron"
 	"github.com/philips-software/terraform-provider-hsdp/internal/config"
@@ -22,6 +24,11 @@ import (
 
 const (
 	aLongTime = 86400 * 365 * 30
+
+	payloadDigestField = "payload_digest"
+	// payloadDrifted marks a payload digest which no longer matches the
+	// schedules in Iron, so the next plan updates the function
+	payloadDrifted = "drifted"
 )
 
 func ResourceFunction() *schema.Resource {
@@ -34,6 +41,7 @@ func ResourceFunction() *schema.Resource {
 		ReadContext:   resourceFunctionRead,
 		UpdateContext: resourceFunctionUpdate,
 		DeleteContext: resourceFunctionDelete,
+		CustomizeDiff: customizeFunctionDiff,
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -81,22 +89,7 @@ func ResourceFunction() *schema.Resource {
 				Optional: true,
 				Default:  1800,
 			},
-			"backend": {
-				Type:     schema.TypeList,
-				Required: true,
-				MaxItems: 1,
-				ForceNew: true,
-				Elem: &schema.Resource{
-					Schema: map[string]*schema.Schema{
-						"credentials": {
-							Type:      schema.TypeMap,
-							Optional:  true,
-							Sensitive: true,
-							ForceNew:  true,
-						},
-					},
-				},
-			},
+			"backend": backendSchema(),
 			"token": {
 				Type:      schema.TypeString,
 				Sensitive: true,
@@ -118,25 +111,90 @@ func ResourceFunction() *schema.Resource {
 				Type:     schema.TypeString,
 				Computed: true,
 			},
+			lastRunAtField: {
+				Description: "When the most recent task of the function ran.",
+				Type:        schema.TypeString,
+				Computed:    true,
+			},
+			lastStatusField: {
+				Description: "The status of the most recent task of the function.",
+				Type:        schema.TypeString,
+				Computed:    true,
+			},
+			nextRunAtField: {
+				Description: "When the schedule of the function runs next.",
+				Type:        schema.TypeString,
+				Computed:    true,
+			},
+			versionsToKeepField: {
+				Description:  "The number of deployed versions to retain for rollback.",
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      defaultVersionsToKeep,
+				ValidateFunc: validation.IntBetween(1, 100),
+			},
+			pinnedVersionField: {
+				Description:  "Deploy the image of this retained version instead of docker_image.",
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+			activeVersionField: {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			versionsField: versionsSchema(),
+			payloadDigestField: {
+				Description: "SHA-256 digest of the schedule payloads in Iron. Changes made outside Terraform to the encrypted command or environment show up as a diff of this attribute.",
+				Type:        schema.TypeString,
+				Computed:    true,
+			},
 		},
 	}
 }
 
+// customizeFunctionDiff plans an update of the schedules when Read found
+// their payloads changed outside Terraform. Moving the function to another
+// backend replaces it.
+func customizeFunctionDiff(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	backendBlock := d.Get("backend").([]interface{})
+	if len(backendBlock) > 0 && backendBlock[0] != nil {
+		if nestedBlock(backendBlock[0].(map[string]interface{}), backendCF) != nil &&
+			(d.Get("schedule").(string) != "" || d.Get("run_every").(string) != "") {
+			return errNoScheduler
+		}
+	}
+	if d.Id() == "" {
+		return nil
+	}
+	if d.HasChange("backend") {
+		before, after := d.GetChange("backend")
+		if backendIdentity(before.([]interface{})) != backendIdentity(after.([]interface{})) {
+			if err := d.ForceNew("backend"); err != nil {
+				return err
+			}
+		}
+	}
+	if old, _ := d.GetChange(payloadDigestField); old.(string) == payloadDrifted {
+		if err := d.SetNewComputed(payloadDigestField); err != nil {
+			return err
+		}
+	}
+	return customizeVersionsDiff(ctx, d, m)
+}
+
 func resourceFunctionDelete(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	var diags diag.Diagnostics
-	ironClient, _, _, err := newIronClient(d, m)
+	b, err := newBackend(d, m)
 	if err != nil {
 		return diag.FromErr(err)
 	}
-	ids := strings.Split(d.Id(), "-")
-	if len(ids) < 2 {
+	codeID, _, ok := parseFunctionID(d.Id())
+	if !ok {
 		d.SetId("") // Malformed
 		return diags
 	}
-	codeID := ids[0]
-	_ = ids[1]                                      // signature
-	_, _, err = ironClient.Codes.DeleteCode(codeID) // Deleting a code cascade deletes schedules as well, jobs done!
-	if err != nil {
+	if err := b.DeleteCode(codeID); err != nil {
 		return diag.FromErr(err)
 	}
 	d.SetId("")
@@ -144,148 +202,342 @@ func resourceFunctionDelete(_ context.Context, d *schema.ResourceData, m interfa
 
 }
 
-func resourceFunctionUpdate(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+func resourceFunctionUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	var diags diag.Diagnostics
 
-	ironClient, ironConfig, modConfig, err := newIronClient(d, m)
+	b, err := newBackend(d, m)
 	if err != nil {
 		return diag.FromErr(err)
 	}
 	c := m.(*config.Config)
+	deploy := d.HasChanges("docker_image", pinnedVersionField)
 
-	if d.HasChange("docker_credentials") {
-		if _, ok := d.GetOk("docker_credentials"); ok {
-			if _, err := dockerLogin(ironClient, d); err != nil {
+	// Backends may only keep the credentials for the code registered next,
+	// so they are passed on for every deploy
+	if deploy || d.HasChange("docker_credentials") {
+		credentials, err := expandDockerCredentials(d)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		if credentials != nil {
+			if err := b.DockerLogin(*credentials); err != nil {
 				return diag.FromErr(err)
 			}
 		}
 	}
 
 	// ID Format: {codeID}-{signature}
-	ids := strings.Split(d.Id(), "-")
-	if len(ids) < 2 {
+	codeID, signature, ok := parseFunctionID(d.Id())
+	if !ok {
 		d.SetId("") // Malformed
 		return diags
 	}
-	codeID := ids[0]
-	signature := ids[1]
 	name := d.Get("name").(string)
-	codeName := fmt.Sprintf("%s-%s", name, signature)
-	if d.HasChange("docker_image") {
-		code, _, err := ironClient.Codes.GetCode(codeID)
+	current, err := b.GetCode(codeID)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if current == nil {
+		return diag.FromErr(fmt.Errorf("code '%s' of function '%s' not found", codeID, name))
+	}
+	codeName := current.Name
+	versions := expandVersions(d.Get(versionsField).([]interface{}))
+	activeVersion := d.Get(activeVersionField).(int)
+
+	// Every deploy registers a new revision of the code, so the ID and the
+	// endpoints of the function stay the same. The schedules run the code by
+	// name and pick up the new revision once it registered.
+	var registered *iron.Code
+	if deploy {
+		image, err := deployImage(d)
 		if err != nil {
-			d.SetId("")
+			return diag.FromErr(err)
+		}
+		if len(versions) == 0 {
+			// Retain the image deployed before versions were tracked
+			versions, activeVersion = recordVersion(versions, 0, current.Image, imageDigest(current.Image), current.Rev, time.Now())
+		}
+		image, diags = resolveImage(c, d, image)
+		if diags.HasError() {
 			return diags
 		}
-		code.Image = d.Get("docker_image").(string)
-		_, resp, err := ironClient.Codes.CreateOrUpdateCode(*code)
+		registered, err = b.RegisterCode(codeName, image)
 		if err != nil {
-			return diag.FromErr(fmt.Errorf("CreateOrUpdateCode(%v): %w", code, err))
-		}
-		if resp.StatusCode != http.StatusOK {
-			return diag.FromErr(fmt.Errorf("failed to update code '%s': got HTTP %d", code.Image, resp.StatusCode))
+			return append(diags, diag.FromErr(err)...)
 		}
 	}
 
 	if d.HasChange("schedule") || d.HasChange("command") ||
 		d.HasChange("run_every") || d.HasChange("environment") ||
-		d.HasChange("start_at") || d.HasChange("docker_image") || d.HasChange("timeout") {
-		schedules, _, err := ironClient.Schedules.GetSchedulesWithCode(codeName)
+		d.HasChange("start_at") || d.HasChange("timeout") ||
+		d.HasChange(payloadDigestField) {
+		schedules, err := b.GetSchedules(codeName)
 		if err != nil {
-			return diag.FromErr(fmt.Errorf("GetSchedulesWithCode(%s): %w", codeName, err))
+			return append(diags, diag.FromErr(err)...)
 		}
 		// Create new schedules
-		diags = createSchedules(ironClient, ironConfig, *modConfig, d, codeName, codeID, signature)
-		if len(diags) > 0 {
-			return diags
+		if createDiags := createSchedules(b, d, codeName, codeID, signature); len(createDiags) > 0 {
+			if registered != nil {
+				// The old schedules go back to the image they ran before
+				if _, err := b.RegisterCode(codeName, current.Image); err != nil {
+					createDiags = append(createDiags, diag.FromErr(fmt.Errorf("rolling back code '%s' to '%s': %w", codeName, current.Image, err))...)
+				}
+			}
+			return append(diags, createDiags...)
 		}
 		// Clear old ones
-		for _, s := range *schedules {
-			_, _, _ = ironClient.Schedules.CancelSchedule(s.ID)
+		for _, s := range activeSchedules(schedules) {
+			_ = b.CancelSchedule(s.ID)
 		}
+		_ = d.Set(payloadDigestField, "")
 	}
-	_, _ = c.Debug("ProjectID: %v\nSignature: %v\nCode: %v\n", ironConfig.ProjectID, signature, codeID)
-	return diags
+	if registered != nil {
+		versions, activeVersion = recordVersion(versions, d.Get(pinnedVersionField).(int), d.Get("docker_image").(string), imageDigest(registered.Image), registered.Rev, time.Now())
+	}
+	_ = d.Set(activeVersionField, activeVersion)
+	_ = d.Set(versionsField, flattenVersions(trimVersions(versions, d.Get(versionsToKeepField).(int), activeVersion)))
+	_, _ = c.Debug("Signature: %v\nCode: %v\n", signature, codeID)
+	return append(diags, resourceFunctionRead(ctx, d, m)...)
+}
+
+// resolveImage pins image to the digest it refers to now, so the deployed
+// version cannot change when its tag moves. When the registry cannot be
+// reached the image is deployed as is, with a warning.
+func resolveImage(c *config.Config, d *schema.ResourceData, image string) (string, diag.Diagnostics) {
+	credentials, err := expandDockerCredentials(d)
+	if err != nil {
+		return image, diag.FromErr(err)
+	}
+	digest, err := resolveDigest(c.FunctionHTTPClient(""), image, credentials)
+	if err != nil {
+		return image, diag.Diagnostics{{
+			Severity: diag.Warning,
+			Summary:  fmt.Sprintf("deploying '%s' without resolving its digest", image),
+			Detail:   err.Error(),
+		}}
+	}
+	return pinnedImage(image, digest), nil
 }
 
 func resourceFunctionRead(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	var diags diag.Diagnostics
 	c := m.(*config.Config)
-	ironClient, ironConfig, _, err := newIronClient(d, m)
+	b, err := newBackend(d, m)
 	if err != nil {
-		return diag.FromErr(fmt.Errorf("resourceFunctionRead.newIronClient: %w", err))
+		return diag.FromErr(fmt.Errorf("resourceFunctionRead.newBackend: %w", err))
 	}
 	// ID Format: {codeID}-{signature}
-	ids := strings.Split(d.Id(), "-")
-	codeID := ids[0]
-	signature := ids[1]
+	codeID, signature, ok := parseFunctionID(d.Id())
+	if !ok {
+		d.SetId("") // Malformed
+		return diags
+	}
 
-	code, _, err := ironClient.Codes.GetCode(codeID)
+	code, err := b.GetCode(codeID)
 	if err != nil {
 		return diag.FromErr(fmt.Errorf("resourceFunctionRead.GetCode: %w", err))
 	}
-	if code == nil || code.ID != codeID {
-		_, _ = c.Debug("could not find code with ID '%s'. marking resource as gone\n", codeID)
-		_ = d.Set("docker_image", "")
-	} else {
+	if code == nil {
+		log.Printf("[WARN] code '%s' of function '%s' not found, removing from state", codeID, d.Get("name"))
+		d.SetId("")
+		return diags
+	}
+	// The configured image differs from the code while a version is pinned
+	versions := expandVersions(d.Get(versionsField).([]interface{}))
+	if active, ok := findVersion(versions, d.Get(activeVersionField).(int)); !ok || pinnedImage(active.DockerImage, active.ImageDigest) != code.Image {
 		_ = d.Set("docker_image", code.Image)
 	}
-	schedules, _, err := ironClient.Schedules.GetSchedulesWithCode(code.Name)
+
+	allSchedules, err := b.GetSchedules(code.Name)
 	if err != nil {
-		return diag.FromErr(fmt.Errorf("resourceFunctionRead.GetSchedulesWithCode: %w", err))
+		return diag.FromErr(fmt.Errorf("resourceFunctionRead.GetSchedules: %w", err))
 	}
-
-	// Check schedules
-	if len(*schedules) == 0 {
-		_, _ = c.Debug("no schedules found for code '%s'. marking resource as gone\n", code.Name)
-		_ = d.Set("docker_image", "")
+	schedules := activeSchedules(allSchedules)
+	if len(schedules) == 0 {
+		log.Printf("[WARN] no schedules found for code '%s', removing function '%s' from state", code.Name, d.Get("name"))
+		d.SetId("")
 		return diags
 	}
-	_, _ = c.Debug("ProjectID: %v\nSignature: %v\nCode: %v\nSchedules: %d\n", ironConfig.ProjectID, signature, codeID, len(*schedules))
+	taskType := readSchedules(d, schedules)
+	payload, err := b.ReadPayload(codeID)
+	if err != nil {
+		return diag.FromErr(fmt.Errorf("resourceFunctionRead.ReadPayload: %w", err))
+	}
+	if payload != nil {
+		readPayload(d, *payload)
+	}
+	if taskType == "function" {
+		setEndpoints(d, b.Gateway(), codeID)
+	}
+	_ = d.Set("token", b.Gateway().Token)
+	_ = d.Set("auth_type", b.Gateway().AuthType)
+	_ = d.Set(nextRunAtField, formatTime(nextRun(taskType, d.Get("schedule").(string), schedules, time.Now())))
+	// The task history is informational, failing to read it does not fail the refresh
+	if tasks, _, err := b.GetTasks(codeID, time.Time{}); err != nil {
+		log.Printf("[WARN] reading tasks of function '%s': %v", d.Get("name"), err)
+	} else {
+		setLastRun(d, tasks)
+	}
+	_, _ = c.Debug("Signature: %v\nCode: %v\nSchedules: %d\n", signature, codeID, len(schedules))
 	return diags
 }
 
+// setLastRun sets the time and status of the most recent task, or clears
+// them when the backend has no tasks anymore
+func setLastRun(d *schema.ResourceData, tasks []iron.Task) {
+	if len(tasks) == 0 {
+		_ = d.Set(lastRunAtField, "")
+		_ = d.Set(lastStatusField, "")
+		return
+	}
+	_ = d.Set(lastRunAtField, formatTime(taskTime(tasks[0])))
+	_ = d.Set(lastStatusField, tasks[0].Status)
+}
+
+// setEndpoints sets the gateway endpoints of the function. Backends without
+// a gateway have none.
+func setEndpoints(d *schema.ResourceData, gw gateway, codeID string) {
+	if gw.Upstream == "" {
+		return
+	}
+	syncEndpoint := fmt.Sprintf("https://%s/function/%s", gw.Upstream, codeID)
+	_ = d.Set("endpoint", syncEndpoint)
+	_ = d.Set("sync_endpoint", syncEndpoint)
+	_ = d.Set("async_endpoint", fmt.Sprintf("https://%s/async-function/%s", gw.Upstream, codeID))
+}
+
+// activeSchedules drops the schedules which were cancelled
+func activeSchedules(schedules []iron.Schedule) []iron.Schedule {
+	active := make([]iron.Schedule, 0, len(schedules))
+	for _, s := range schedules {
+		if s.Status != "cancelled" {
+			active = append(active, s)
+		}
+	}
+	return active
+}
+
+// readSchedules sets the schedule arguments from the Iron schedules created
+// by createSchedules and returns the task type. The command and environment
+// are encrypted, changes to them are detected through the payload digest.
+func readSchedules(d *schema.ResourceData, schedules []iron.Schedule) string {
+	taskType := "schedule"
+	payloads := make([]string, 0, len(schedules))
+	for _, s := range schedules {
+		payloads = append(payloads, s.Payload)
+		var cfg siderite.CronPayload
+		if err := json.Unmarshal([]byte(s.Payload), &cfg); err != nil || cfg.EncryptedPayload == "" {
+			// Plain encrypted payload of a run_every schedule
+			_ = d.Set("timeout", s.Timeout)
+			if runEvery, _, err := calcRunEvery(d.Get("run_every").(string), ""); err != nil || runEvery != s.RunEvery {
+				_ = d.Set("run_every", formatRunEvery(s.RunEvery))
+			}
+			_ = d.Set("schedule", "")
+			continue
+		}
+		if cfg.Schedule != "" {
+			taskType = "cron"
+			_ = d.Set("schedule", cfg.Schedule)
+			_ = d.Set("timeout", cfg.Timeout)
+		} else {
+			taskType = "function"
+			_ = d.Set("schedule", "")
+			_ = d.Set("timeout", s.Timeout)
+		}
+		_ = d.Set("run_every", "")
+		_ = d.Set("start_at", "")
+	}
+
+	digest := payloadDigest(payloads)
+	switch recorded := d.Get(payloadDigestField).(string); recorded {
+	case digest, payloadDrifted:
+	case "":
+		// Not recorded yet, right after the schedules were created
+		_ = d.Set(payloadDigestField, digest)
+	default:
+		log.Printf("[WARN] schedules of function '%s' were changed outside Terraform", d.Get("name"))
+		_ = d.Set(payloadDigestField, payloadDrifted)
+	}
+	return taskType
+}
+
+// readPayload sets the command and environment from a payload the backend
+// can read. Backends may join the command, so it is compared joined.
+func readPayload(d *schema.ResourceData, payload siderite.Payload) {
+	if strings.Join(expandCommand(d), " ") != strings.Join(payload.Cmd, " ") {
+		_ = d.Set("command", payload.Cmd)
+	}
+	_ = d.Set("environment", payload.Env)
+}
+
+// payloadDigest returns the SHA-256 digest of the schedule payloads,
+// independent of their order
+func payloadDigest(payloads []string) string {
+	sorted := append([]string{}, payloads...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// formatRunEvery formats seconds in the largest unit calcRunEvery accepts
+// which divides it
+func formatRunEvery(seconds int) string {
+	switch {
+	case seconds%86400 == 0:
+		return fmt.Sprintf("%dd", seconds/86400)
+	case seconds%3600 == 0:
+		return fmt.Sprintf("%dh", seconds/3600)
+	case seconds%60 == 0:
+		return fmt.Sprintf("%dm", seconds/60)
+	}
+	return fmt.Sprintf("%ds", seconds)
+}
+
 func resourceFunctionCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	ironClient, ironConfig, modConfig, err := newIronClient(d, m)
+	b, err := newBackend(d, m)
 	if err != nil {
 		return diag.FromErr(err)
 	}
 
 	name := d.Get("name").(string)
 	dockerImage := d.Get("docker_image").(string)
-	if _, ok := d.GetOk("docker_credentials"); ok {
-		if _, err := dockerLogin(ironClient, d); err != nil {
+	credentials, err := expandDockerCredentials(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if credentials != nil {
+		if err := b.DockerLogin(*credentials); err != nil {
 			return diag.FromErr(fmt.Errorf("invalid or missing Docker credentials: %w", err))
 		}
 	}
 	signature := strings.Replace(uuid.New().String(), "-", "", -1)
 
-	if ironConfig == nil || len(ironConfig.ClusterInfo) == 0 {
-		return diag.FromErr(fmt.Errorf("invalid Iron.io discovery: %v", ironConfig))
+	image, diags := resolveImage(m.(*config.Config), d, dockerImage)
+	if diags.HasError() {
+		return diags
 	}
 	codeName := fmt.Sprintf("%s-%s", name, signature)
-	createdCode, resp, err := ironClient.Codes.CreateOrUpdateCode(iron.Code{
-		Name:      codeName,
-		Image:     dockerImage,
-		ProjectID: ironConfig.ProjectID,
-	})
+	createdCode, err := b.RegisterCode(codeName, image)
 	if err != nil {
-		return diag.FromErr(err)
+		return append(diags, diag.FromErr(err)...)
 	}
-	if resp.StatusCode == http.StatusNotFound {
-		return diag.FromErr(fmt.Errorf("code '%s' not found", dockerImage))
-	}
-	diags := createSchedules(ironClient, ironConfig, *modConfig, d, codeName, createdCode.ID, signature)
-	if len(diags) > 0 {
-		return diags
+	if createDiags := createSchedules(b, d, codeName, createdCode.ID, signature); len(createDiags) > 0 {
+		_ = b.DeleteCode(createdCode.ID)
+		return append(diags, createDiags...)
 	}
+	versions, activeVersion := recordVersion(nil, 0, dockerImage, imageDigest(createdCode.Image), createdCode.Rev, time.Now())
+	_ = d.Set(activeVersionField, activeVersion)
+	_ = d.Set(versionsField, flattenVersions(versions))
 
-	_ = d.Set("token", (*modConfig)["siderite_token"])
-	_ = d.Set("auth_type", (*modConfig)["siderite_auth_type"])
-	return resourceFunctionRead(ctx, d, m)
+	_ = d.Set("token", b.Gateway().Token)
+	_ = d.Set("auth_type", b.Gateway().AuthType)
+	return append(diags, resourceFunctionRead(ctx, d, m)...)
 }
 
-func createSchedules(ironClient *iron.Client, ironConfig *iron.Config, modConfig map[string]string, d *schema.ResourceData, codeName, codeID, signature string) diag.Diagnostics {
+// createSchedules creates the schedules of the function for codeName. When
+// this fails the schedules it created are cancelled again, leaving existing
+// schedules of the function untouched.
+func createSchedules(b backend, d *schema.ResourceData, codeName, codeID, signature string) diag.Diagnostics {
 	var diags diag.Diagnostics
 
 	taskType := "schedule"
@@ -299,13 +551,24 @@ func createSchedules(ironClient *iron.Client, ironConfig *iron.Config, modConfig
 	if schedule != nil && schedule.CRON != nil {
 		taskType = "cron"
 	}
-	encryptedSyncPayload, encryptedAsyncPayload, err := preparePayloads(taskType, modConfig, d, ironConfig)
+	encryptedSyncPayload, encryptedAsyncPayload, err := preparePayloads(taskType, b, d)
 	if err != nil {
-		_, _, _ = ironClient.Codes.DeleteCode(codeID)
 		return diag.FromErr(err)
 	}
 	timeout := d.Get("timeout").(int)
 	startAt := time.Now().Add(aLongTime * time.Second)
+	var created []string
+	create := func(kind string, s iron.Schedule) error {
+		id, err := b.CreateSchedule(s)
+		if err != nil {
+			for _, id := range created {
+				_ = b.CancelSchedule(id)
+			}
+			return fmt.Errorf("create %s schedule: %w", kind, err)
+		}
+		created = append(created, id)
+		return nil
+	}
 	var syncSchedule *iron.Schedule
 	var asyncSchedule *iron.Schedule
 	switch taskType {
@@ -319,16 +582,10 @@ func createSchedules(ironClient *iron.Client, ironConfig *iron.Config, modConfig
 		cronSchedule := iron.Schedule{
 			CodeName: codeName,
 			Payload:  string(jsonPayload),
-			Cluster:  ironConfig.ClusterInfo[0].ClusterID,
 			StartAt:  &startAt,
 			RunEvery: aLongTime,
 		}
-		_, resp, err := ironClient.Schedules.CreateSchedule(cronSchedule)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			_, _, _ = ironClient.Codes.DeleteCode(codeID)
-			if err == nil {
-				return diag.FromErr(fmt.Errorf("create CRON schedule failed with code %d", resp.StatusCode))
-			}
+		if err := create("CRON", cronSchedule); err != nil {
 			return diag.FromErr(err)
 		}
 		d.SetId(fmt.Sprintf("%s-%s", codeID, signature))
@@ -341,20 +598,13 @@ func createSchedules(ironClient *iron.Client, ironConfig *iron.Config, modConfig
 		syncSchedule = &iron.Schedule{
 			CodeName: codeName,
 			Payload:  string(jsonPayload),
-			Cluster:  ironConfig.ClusterInfo[0].ClusterID,
 			StartAt:  &startAt,
 			RunEvery: aLongTime,
 			Timeout:  timeout,
 		}
-		_, resp, err := ironClient.Schedules.CreateSchedule(*syncSchedule)
-		if err != nil {
-			_, _, _ = ironClient.Codes.DeleteCode(codeID)
+		if err := create("sync", *syncSchedule); err != nil {
 			return diag.FromErr(err)
 		}
-		if resp.StatusCode != http.StatusOK {
-			_, _, _ = ironClient.Codes.DeleteCode(codeID)
-			return diag.FromErr(fmt.Errorf("creating sync schedule failed with code %d", resp.StatusCode))
-		}
 		cfg = siderite.CronPayload{
 			EncryptedPayload: encryptedAsyncPayload,
 			Type:             "async",
@@ -363,20 +613,13 @@ func createSchedules(ironClient *iron.Client, ironConfig *iron.Config, modConfig
 		asyncSchedule = &iron.Schedule{
 			CodeName: codeName,
 			Payload:  string(jsonPayload),
-			Cluster:  ironConfig.ClusterInfo[0].ClusterID,
 			StartAt:  &startAt,
 			RunEvery: aLongTime,
 			Timeout:  timeout,
 		}
-		_, resp, err = ironClient.Schedules.CreateSchedule(*asyncSchedule)
-		if err != nil {
-			_, _, _ = ironClient.Codes.DeleteCode(codeID)
+		if err := create("async", *asyncSchedule); err != nil {
 			return diag.FromErr(err)
 		}
-		if resp.StatusCode != http.StatusOK {
-			_, _, _ = ironClient.Codes.DeleteCode(codeID)
-			return diag.FromErr(fmt.Errorf("creating async schedule failed with code %d", resp.StatusCode))
-		}
 		d.SetId(fmt.Sprintf("%s-%s", codeID, signature))
 	case "schedule":
 		if schedule == nil {
@@ -384,45 +627,27 @@ func createSchedules(ironClient *iron.Client, ironConfig *iron.Config, modConfig
 		}
 		schedule.Iron.CodeName = codeName
 		schedule.Iron.Payload = encryptedSyncPayload
-		schedule.Iron.Cluster = ironConfig.ClusterInfo[0].ClusterID
-		_, resp, err := ironClient.Schedules.CreateSchedule(*schedule.Iron)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			_, _, _ = ironClient.Codes.DeleteCode(codeID)
-			if err == nil {
-				return diag.FromErr(fmt.Errorf("create schedule failed with code %d", resp.StatusCode))
-			}
+		if err := create("run_every", *schedule.Iron); err != nil {
 			return diag.FromErr(err)
 		}
 		d.SetId(fmt.Sprintf("%s-%s", codeID, signature))
 	}
-	if syncSchedule != nil {
-		syncEndpoint := fmt.Sprintf("https://%s/function/%s", (modConfig)["siderite_upstream"], codeID)
-		_ = d.Set("endpoint", syncEndpoint)
-		_ = d.Set("sync_endpoint", syncEndpoint)
-	}
-	if asyncSchedule != nil {
-		asyncEndpoint := fmt.Sprintf("https://%s/async-function/%s", (modConfig)["siderite_upstream"], codeID)
-		_ = d.Set("async_endpoint", asyncEndpoint)
+	if syncSchedule != nil && asyncSchedule != nil {
+		setEndpoints(d, b.Gateway(), codeID)
 	}
 	return diags
 }
 
-func preparePayloads(taskType string, modConfig map[string]string, d *schema.ResourceData, config *iron.Config) (string, string, error) {
-	command := []string{"/app/server"}
-	if list, ok := d.Get("command").([]interface{}); ok && len(list) > 0 {
-		command = []string{}
-		for i := 0; i < len(list); i++ {
-			command = append(command, list[i].(string))
-		}
-	}
+func preparePayloads(taskType string, b backend, d *schema.ResourceData) (string, string, error) {
+	command := expandCommand(d)
 	environment := getEnvironment(d)
 
 	payload := siderite.Payload{
 		Version:  "1",
 		Type:     taskType,
-		Token:    modConfig["siderite_token"],
-		Upstream: modConfig["siderite_upstream"],
-		Auth:     modConfig["siderite_auth_type"],
+		Token:    b.Gateway().Token,
+		Upstream: b.Gateway().Upstream,
+		Auth:     b.Gateway().AuthType,
 		Cmd:      command,
 		Env:      environment,
 		Mode:     "sync",
@@ -431,7 +656,7 @@ func preparePayloads(taskType string, modConfig map[string]string, d *schema.Res
 	if err != nil {
 		return "", "", fmt.Errorf("preparePayload: %w", err)
 	}
-	syncPayload, err := iron.EncryptPayload([]byte(config.ClusterInfo[0].Pubkey), payloadJSON)
+	syncPayload, err := b.EncryptPayload(payloadJSON)
 	if err != nil {
 		return "", "", fmt.Errorf("preparePayloads.sync: %w", err)
 	}
@@ -441,13 +666,26 @@ func preparePayloads(taskType string, modConfig map[string]string, d *schema.Res
 	if err != nil {
 		return "", "", fmt.Errorf("preparePayload: %w", err)
 	}
-	asyncPayload, err := iron.EncryptPayload([]byte(config.ClusterInfo[0].Pubkey), payloadJSON)
+	asyncPayload, err := b.EncryptPayload(payloadJSON)
 	if err != nil {
 		return "", "", fmt.Errorf("preparePayloads.async: %w", err)
 	}
 	return syncPayload, asyncPayload, nil
 }
 
+// expandCommand returns the command of the function, the siderite server
+// of the image by default
+func expandCommand(d *schema.ResourceData) []string {
+	command := []string{"/app/server"}
+	if list, ok := d.Get("command").([]interface{}); ok && len(list) > 0 {
+		command = []string{}
+		for i := 0; i < len(list); i++ {
+			command = append(command, list[i].(string))
+		}
+	}
+	return command
+}
+
 func getEnvironment(d *schema.ResourceData) map[string]string {
 	environment := make(map[string]string)
 	if e, ok := d.GetOk("environment"); ok {
@@ -462,36 +700,6 @@ func getEnvironment(d *schema.ResourceData) map[string]string {
 	return environment
 }
 
-func dockerLogin(ironClient *iron.Client, d *schema.ResourceData) (bool, error) {
-	name := d.Get("name").(string)
-	dockerImage := d.Get("docker_image").(string)
-	ref, err := reference.ParseNormalizedNamed(dockerImage)
-	if err != nil {
-		return false, fmt.Errorf("error normalizing docker '%s': %w", dockerImage, err)
-	}
-	registry := ""
-	if str := strings.Split(ref.Name(), "/"); len(str) > 1 {
-		registry = str[0]
-	}
-	v, ok := d.GetOk("docker_credentials")
-	if !ok { // No credentials after all
-		return true, nil
-	}
-	vv := v.(map[string]interface{})
-	username := vv["username"].(string)
-	password := vv["password"].(string)
-	ok, _, err = ironClient.Codes.DockerLogin(iron.DockerCredentials{
-		Email:         fmt.Sprintf("terraform-%s@localhost.localdomain", name),
-		Username:      username,
-		Password:      password,
-		ServerAddress: registry,
-	})
-	if !ok {
-		return false, fmt.Errorf("invalid docker credentials for '%s': %w", registry, err)
-	}
-	return true, nil
-}
-
 type taskSchedule struct {
 	Timeout int
 	Iron    *iron.Schedule
@@ -570,67 +778,3 @@ func calcRunEvery(runEvery, startAt string) (int, *time.Time, error) {
 	}
 	return seconds, &firstRun, nil
 }
-
-func newIronClient(d *schema.ResourceData, m interface{}) (*iron.Client, *iron.Config, *map[string]string, error) {
-	c := m.(*config.Config)
-	backend, ok := d.Get("backend").([]interface{})
-	if !ok {
-		return nil, nil, nil, fmt.Errorf("expected array of 'backend' discovery")
-	}
-	backendResource, ok := backend[0].(map[string]interface{})
-	if !ok {
-		return nil, nil, nil, fmt.Errorf("unexpected backend format")
-	}
-
-	configMap, ok := backendResource["credentials"].(map[string]interface{})
-	if !ok {
-		return nil, nil, nil, fmt.Errorf("invalid or missing iron discovery credentials")
-	}
-	cfg := make(map[string]string)
-	for k, v := range configMap {
-		if str, ok := v.(string); ok {
-			cfg[k] = str
-		}
-	}
-	backendType := cfg["type"]
-	if !(backendType == "siderite" || backendType == "ferrite") {
-		return nil, nil, nil, fmt.Errorf("expected backend type of ['siderite' | 'ferrite']")
-	}
-	// Bootstrap ferrite
-	if backendType == "ferrite" {
-		token := cfg["token"]
-		bootstrap, err := server.Bootstrap(cfg["base_url"], token)
-		if err != nil {
-			return nil, nil, nil, fmt.Errorf("error bootstrapping ferrite: %w", err)
-		}
-		// Inject bootstrap data
-		cfg["project"] = bootstrap.ProjectID
-		cfg["project_id"] = bootstrap.ProjectID
-		cfg["cluster_info_0_cluster_id"] = bootstrap.ClusterID
-		cfg["cluster_info_0_pubkey"] = bootstrap.PublicKey
-	}
-	ironConfig := iron.Config{
-		BaseURL:   cfg["base_url"],
-		Email:     cfg["email"],
-		Password:  cfg["password"],
-		Project:   cfg["project"],
-		ProjectID: cfg["project_id"],
-		Token:     cfg["token"],
-		UserID:    cfg["user_id"],
-		DebugLog:  c.DebugLog,
-		ClusterInfo: []iron.ClusterInfo{
-			{
-				ClusterID:   cfg["cluster_info_0_cluster_id"],
-				ClusterName: cfg["cluster_info_0_cluster_name"],
-				Pubkey:      cfg["cluster_info_0_pubkey"],
-				UserID:      cfg["cluster_info_0_user_id"],
-			},
-		},
-	}
-
-	client, err := iron.NewClient(&ironConfig)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("iron.NewClient: %w", err)
-	}
-	return client, &ironConfig, &cfg, nil
-}
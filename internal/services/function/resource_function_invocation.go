@@ -0,0 +1,54 @@
+package function
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+)
+
+func ResourceFunctionInvocation() *schema.Resource {
+	s := invocationSchema()
+	s["triggers"] = &schema.Schema{
+		Description: "A map of arbitrary strings that, when changed, will invoke the function again.",
+		Type:        schema.TypeMap,
+		Optional:    true,
+		ForceNew:    true,
+	}
+	return &schema.Resource{
+		Description: `The ` + "`hsdp_function_invocation`" + ` resource calls the sync endpoint of a function, or runs it as a task, once and captures the response.
+Changing any argument or the ` + "`triggers`" + ` invokes the function again.`,
+
+		CreateContext: resourceFunctionInvocationCreate,
+		ReadContext:   resourceFunctionInvocationRead,
+		DeleteContext: resourceFunctionInvocationDelete,
+
+		Schema: s,
+	}
+}
+
+func resourceFunctionInvocationCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	c := m.(*config.Config)
+
+	inv, err := expandInvocation(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if err := inv.invoke(ctx, c); err != nil {
+		return diag.FromErr(err)
+	}
+	d.SetId(uuid.New().String())
+	inv.setResults(d)
+	return nil
+}
+
+func resourceFunctionInvocationRead(_ context.Context, _ *schema.ResourceData, _ interface{}) diag.Diagnostics {
+	return nil
+}
+
+func resourceFunctionInvocationDelete(_ context.Context, d *schema.ResourceData, _ interface{}) diag.Diagnostics {
+	d.SetId("")
+	return nil
+}
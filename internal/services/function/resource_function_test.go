@@ -0,0 +1,102 @@
+package function
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	siderite "github.com/philips-labs/siderite/models"
+	"github.com/philips-software/go-hsdp-api/iron"
+	"github.com/stretchr/testify/assert"
+)
+
+func cronPayload(t *testing.T, cfg siderite.CronPayload) string {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestReadSchedules(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, ResourceFunction().Schema, map[string]interface{}{
+		"name":         "cron",
+		"docker_image": "philipslabs/hello",
+		"schedule":     "0 * * * *",
+	})
+	cron := []iron.Schedule{{
+		Payload: cronPayload(t, siderite.CronPayload{Schedule: "*/5 * * * *", EncryptedPayload: "c2VjcmV0", Timeout: 60}),
+	}}
+	assert.Equal(t, "cron", readSchedules(d, cron))
+	assert.Equal(t, "*/5 * * * *", d.Get("schedule"), "schedule edits in Iron show up")
+	assert.Equal(t, 60, d.Get("timeout"))
+	digest := d.Get(payloadDigestField).(string)
+	assert.Equal(t, payloadDigest([]string{cron[0].Payload}), digest)
+
+	// The encrypted payload changed outside Terraform
+	cron[0].Payload = cronPayload(t, siderite.CronPayload{Schedule: "*/5 * * * *", EncryptedPayload: "b3RoZXI=", Timeout: 60})
+	readSchedules(d, cron)
+	assert.Equal(t, payloadDrifted, d.Get(payloadDigestField))
+
+	d = schema.TestResourceDataRaw(t, ResourceFunction().Schema, map[string]interface{}{
+		"name":         "fn",
+		"docker_image": "philipslabs/hello",
+	})
+	function := []iron.Schedule{
+		{Payload: cronPayload(t, siderite.CronPayload{EncryptedPayload: "c3luYw==", Type: "sync"}), Timeout: 300},
+		{Payload: cronPayload(t, siderite.CronPayload{EncryptedPayload: "YXN5bmM=", Type: "async"}), Timeout: 300},
+	}
+	assert.Equal(t, "function", readSchedules(d, function))
+	assert.Equal(t, 300, d.Get("timeout"))
+	assert.Equal(t, payloadDigest([]string{function[1].Payload, function[0].Payload}), d.Get(payloadDigestField))
+
+	d = schema.TestResourceDataRaw(t, ResourceFunction().Schema, map[string]interface{}{
+		"name":         "batch",
+		"docker_image": "philipslabs/hello",
+		"run_every":    "60m",
+	})
+	batch := []iron.Schedule{{Payload: "ZW5jcnlwdGVk", RunEvery: 3600, Timeout: 1800}}
+	assert.Equal(t, "schedule", readSchedules(d, batch))
+	assert.Equal(t, "60m", d.Get("run_every"), "equivalent values are kept")
+	batch[0].RunEvery = 7200
+	readSchedules(d, batch)
+	assert.Equal(t, "2h", d.Get("run_every"))
+}
+
+func TestActiveSchedules(t *testing.T) {
+	schedules := activeSchedules([]iron.Schedule{{ID: "a", Status: "scheduled"}, {ID: "b", Status: "cancelled"}})
+	assert.Len(t, schedules, 1)
+	assert.Equal(t, "a", schedules[0].ID)
+}
+
+func TestSetLastRun(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, ResourceFunction().Schema, map[string]interface{}{
+		"name":         "cron",
+		"docker_image": "philipslabs/hello",
+	})
+	at := time.Date(2021, 11, 2, 4, 0, 0, 0, time.UTC)
+	setLastRun(d, []iron.Task{{ID: "t2", Status: "complete", StartTime: &at}})
+	assert.Equal(t, "2021-11-02T04:00:00Z", d.Get(lastRunAtField))
+	assert.Equal(t, "complete", d.Get(lastStatusField))
+
+	// The task history expired
+	setLastRun(d, nil)
+	assert.Equal(t, "", d.Get(lastRunAtField))
+	assert.Equal(t, "", d.Get(lastStatusField))
+}
+
+func TestReadPayload(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, ResourceFunction().Schema, map[string]interface{}{
+		"name":         "cron",
+		"docker_image": "philipslabs/hello",
+		"environment":  map[string]interface{}{"a": "1"},
+	})
+	readPayload(d, siderite.Payload{Cmd: []string{"/app/server"}, Env: map[string]string{"a": "1"}})
+	assert.Len(t, d.Get("command").([]interface{}), 0, "the default command is not set")
+	assert.Equal(t, map[string]interface{}{"a": "1"}, d.Get("environment"))
+
+	readPayload(d, siderite.Payload{Cmd: []string{"/app/server", "--debug"}, Env: map[string]string{"a": "1", "b": "2"}})
+	assert.Equal(t, []interface{}{"/app/server", "--debug"}, d.Get("command"))
+	assert.Equal(t, map[string]interface{}{"a": "1", "b": "2"}, d.Get("environment"))
+}
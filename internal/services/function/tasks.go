@@ -0,0 +1,113 @@
+package function
+
+import (
+	"sort"
+	"time"
+
+	"github.com/philips-software/go-hsdp-api/iron"
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	lastRunAtField  = "last_run_at"
+	lastStatusField = "last_status"
+	nextRunAtField  = "next_run_at"
+
+	// taskPageSize is the number of tasks read per request, at most
+	// maxTaskPages pages are read
+	taskPageSize = 100
+	maxTaskPages = 10
+)
+
+// taskStatuses are the states of an Iron task
+var taskStatuses = []string{"queued", "preparing", "running", "complete", "error", "cancelled", "killed", "timeout"}
+
+// taskTime returns when a task started, or when it was queued if it did
+// not start yet
+func taskTime(t iron.Task) time.Time {
+	if t.StartTime != nil && !t.StartTime.IsZero() {
+		return *t.StartTime
+	}
+	if t.CreatedAt != nil {
+		return *t.CreatedAt
+	}
+	return time.Time{}
+}
+
+// codeTasks returns the tasks of the code with codeID, most recent first
+func codeTasks(tasks []iron.Task, codeID string) []iron.Task {
+	filtered := make([]iron.Task, 0)
+	for _, t := range tasks {
+		if t.CodeID == codeID {
+			filtered = append(filtered, t)
+		}
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return taskTime(filtered[i]).After(taskTime(filtered[j]))
+	})
+	return filtered
+}
+
+// taskFilter selects tasks on status and on a time window. A zero since or
+// until leaves that side of the window open.
+type taskFilter struct {
+	statuses []string
+	since    time.Time
+	until    time.Time
+}
+
+func (f taskFilter) matches(t iron.Task) bool {
+	if len(f.statuses) > 0 {
+		found := false
+		for _, s := range f.statuses {
+			if s == t.Status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	at := taskTime(t)
+	if !f.since.IsZero() && at.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && at.After(f.until) {
+		return false
+	}
+	return true
+}
+
+// nextRun returns the next run of a scheduled function after now. Siderite
+// runs cron schedules itself, so their Iron schedule never starts on its own.
+func nextRun(taskType, schedule string, schedules []iron.Schedule, now time.Time) time.Time {
+	switch taskType {
+	case "cron":
+		parsed, err := cron.ParseStandard(schedule)
+		if err != nil {
+			return time.Time{}
+		}
+		return parsed.Next(now.UTC())
+	case "schedule":
+		var next time.Time
+		for _, s := range schedules {
+			if s.NextStart == nil || s.NextStart.Before(now) {
+				continue
+			}
+			if next.IsZero() || s.NextStart.Before(next) {
+				next = *s.NextStart
+			}
+		}
+		return next
+	}
+	return time.Time{}
+}
+
+// formatTime formats t as RFC3339, or returns an empty string for a zero t
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,49 @@
+package function
+
+import (
+	"testing"
+	"time"
+
+	"github.com/philips-software/go-hsdp-api/iron"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodeTasks(t *testing.T) {
+	at := func(hour int) *time.Time {
+		ts := time.Date(2021, 11, 1, hour, 0, 0, 0, time.UTC)
+		return &ts
+	}
+	tasks := []iron.Task{
+		{ID: "t1", CodeID: "code", Status: "complete", StartTime: at(1)},
+		{ID: "t2", CodeID: "other", Status: "error", StartTime: at(2)},
+		{ID: "t3", CodeID: "code", Status: "error", StartTime: at(3)},
+		{ID: "t4", CodeID: "code", Status: "queued", CreatedAt: at(4)},
+	}
+	recent := codeTasks(tasks, "code")
+	if !assert.Len(t, recent, 3) {
+		return
+	}
+	assert.Equal(t, []string{"t4", "t3", "t1"}, []string{recent[0].ID, recent[1].ID, recent[2].ID})
+
+	failed := taskFilter{statuses: []string{"error"}}
+	assert.True(t, failed.matches(recent[1]))
+	assert.False(t, failed.matches(recent[2]))
+
+	window := taskFilter{since: *at(2), until: *at(3)}
+	assert.False(t, window.matches(recent[0]))
+	assert.True(t, window.matches(recent[1]))
+	assert.False(t, window.matches(recent[2]))
+}
+
+func TestNextRun(t *testing.T) {
+	now := time.Date(2021, 11, 1, 12, 30, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2021, 11, 2, 4, 0, 0, 0, time.UTC), nextRun("cron", "0 4 * * *", nil, now))
+
+	past := now.Add(-time.Hour)
+	soon := now.Add(time.Hour)
+	later := now.Add(2 * time.Hour)
+	schedules := []iron.Schedule{{NextStart: &later}, {NextStart: &past}, {NextStart: &soon}}
+	assert.Equal(t, soon, nextRun("schedule", "", schedules, now))
+	assert.True(t, nextRun("function", "", schedules, now).IsZero())
+	assert.Equal(t, "", formatTime(time.Time{}))
+}
@@ -0,0 +1,184 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const (
+	versionsField       = "versions"
+	activeVersionField  = "active_version"
+	pinnedVersionField  = "pinned_version"
+	versionsToKeepField = "versions_to_keep"
+
+	defaultVersionsToKeep = 5
+)
+
+// functionVersion is a deploy of the function, which can be rolled back to
+// as long as it is retained
+type functionVersion struct {
+	Version      int
+	DockerImage  string
+	ImageDigest  string
+	CodeRevision int
+	DeployedAt   string
+}
+
+func versionsSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Computed: true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"version": {
+					Type:     schema.TypeInt,
+					Computed: true,
+				},
+				"docker_image": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"image_digest": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"code_revision": {
+					Type:     schema.TypeInt,
+					Computed: true,
+				},
+				"deployed_at": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+			},
+		},
+	}
+}
+
+// customizeVersionsDiff plans the versions after a deploy. A pinned version
+// must still be retained.
+func customizeVersionsDiff(_ context.Context, d *schema.ResourceDiff, _ interface{}) error {
+	if d.Id() == "" {
+		return nil
+	}
+	if d.HasChange("docker_image") || d.HasChange(pinnedVersionField) {
+		if pinned := d.Get(pinnedVersionField).(int); pinned > 0 {
+			old, _ := d.GetChange(versionsField)
+			if _, ok := findVersion(expandVersions(old.([]interface{})), pinned); !ok {
+				return fmt.Errorf("version %d is not retained, pin one of the 'versions'", pinned)
+			}
+			if err := d.SetNew(activeVersionField, pinned); err != nil {
+				return err
+			}
+		} else if err := d.SetNewComputed(activeVersionField); err != nil {
+			return err
+		}
+		return d.SetNewComputed(versionsField)
+	}
+	if d.HasChange(versionsToKeepField) {
+		return d.SetNewComputed(versionsField)
+	}
+	return nil
+}
+
+// deployImage returns the image to deploy, which is the image of the pinned
+// version when one is set. Pinned versions deploy the digest they recorded.
+func deployImage(d *schema.ResourceData) (string, error) {
+	pinned := d.Get(pinnedVersionField).(int)
+	if pinned == 0 {
+		return d.Get("docker_image").(string), nil
+	}
+	version, ok := findVersion(expandVersions(d.Get(versionsField).([]interface{})), pinned)
+	if !ok {
+		return "", fmt.Errorf("version %d is not retained", pinned)
+	}
+	return pinnedImage(version.DockerImage, version.ImageDigest), nil
+}
+
+// recordVersion adds a deploy of image, resolved to digest, as the next
+// version and returns the versions and the new version number. A pinned
+// version is not recorded again, it becomes the active version instead.
+func recordVersion(versions []functionVersion, pinned int, image, digest string, codeRevision int, now time.Time) ([]functionVersion, int) {
+	if pinned > 0 {
+		return versions, pinned
+	}
+	next := 1
+	for _, v := range versions {
+		if v.Version >= next {
+			next = v.Version + 1
+		}
+	}
+	versions = append(versions, functionVersion{
+		Version:      next,
+		DockerImage:  image,
+		ImageDigest:  digest,
+		CodeRevision: codeRevision,
+		DeployedAt:   now.UTC().Format(time.RFC3339),
+	})
+	return versions, next
+}
+
+// trimVersions keeps the keep most recent versions and the active version
+func trimVersions(versions []functionVersion, keep, active int) []functionVersion {
+	sorted := append([]functionVersion{}, versions...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version > sorted[j].Version
+	})
+	kept := make([]functionVersion, 0, keep+1)
+	for i, v := range sorted {
+		if i < keep || v.Version == active {
+			kept = append(kept, v)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		return kept[i].Version < kept[j].Version
+	})
+	return kept
+}
+
+func findVersion(versions []functionVersion, version int) (functionVersion, bool) {
+	for _, v := range versions {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return functionVersion{}, false
+}
+
+func expandVersions(raw []interface{}) []functionVersion {
+	versions := make([]functionVersion, 0, len(raw))
+	for _, r := range raw {
+		entry, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// Versions recorded before digests were resolved have none
+		digest, _ := entry["image_digest"].(string)
+		versions = append(versions, functionVersion{
+			Version:      entry["version"].(int),
+			DockerImage:  entry["docker_image"].(string),
+			ImageDigest:  digest,
+			CodeRevision: entry["code_revision"].(int),
+			DeployedAt:   entry["deployed_at"].(string),
+		})
+	}
+	return versions
+}
+
+func flattenVersions(versions []functionVersion) []interface{} {
+	flattened := make([]interface{}, 0, len(versions))
+	for _, v := range versions {
+		flattened = append(flattened, map[string]interface{}{
+			"version":       v.Version,
+			"docker_image":  v.DockerImage,
+			"image_digest":  v.ImageDigest,
+			"code_revision": v.CodeRevision,
+			"deployed_at":   v.DeployedAt,
+		})
+	}
+	return flattened
+}
@@ -0,0 +1,58 @@
+package function
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAndTrimVersions(t *testing.T) {
+	now := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	var versions []functionVersion
+	active := 0
+	for _, image := range []string{"app:1", "app:2", "app:3", "app:4"} {
+		versions, active = recordVersion(versions, 0, image, "", active+1, now)
+	}
+	assert.Equal(t, 4, active)
+	assert.Equal(t, "2021-11-01T12:00:00Z", versions[0].DeployedAt)
+
+	// Rolling back keeps the version numbers
+	versions, active = recordVersion(versions, 2, "app:2", "", 5, now)
+	assert.Equal(t, 2, active)
+	assert.Len(t, versions, 4)
+
+	kept := trimVersions(versions, 1, active)
+	assert.Equal(t, []int{2, 4}, []int{kept[0].Version, kept[1].Version}, "the active version is retained")
+
+	_, active = recordVersion(kept, 0, "app:5", "", 6, now)
+	assert.Equal(t, 5, active)
+}
+
+func TestDeployImage(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, ResourceFunction().Schema, map[string]interface{}{
+		"name":           "fn",
+		"docker_image":   "app:3",
+		"pinned_version": 1,
+	})
+	_ = d.Set(versionsField, flattenVersions([]functionVersion{
+		{Version: 1, DockerImage: "app:1", ImageDigest: "sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b", CodeRevision: 1},
+		{Version: 2, DockerImage: "app:2", CodeRevision: 2},
+	}))
+	image, err := deployImage(d)
+	assert.Nil(t, err)
+	assert.Equal(t, "app@sha256:6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b", image, "rollbacks deploy the recorded digest")
+
+	_ = d.Set(pinnedVersionField, 2)
+	image, _ = deployImage(d)
+	assert.Equal(t, "app:2", image)
+
+	_ = d.Set(pinnedVersionField, 7)
+	_, err = deployImage(d)
+	assert.Error(t, err)
+
+	_ = d.Set(pinnedVersionField, 0)
+	image, _ = deployImage(d)
+	assert.Equal(t, "app:3", image)
+}
@@ -385,7 +385,7 @@ func purgeGroupContent(ctx context.Context, client *iam.Client, id string, d *sc
 					return nil, err
 				}
 				return resp.Response, err
-			}, http.StatusInternalServerError, http.StatusTooManyRequests)
+			}, http.StatusInternalServerError)
 		}
 	}
 
@@ -402,7 +402,7 @@ func purgeGroupContent(ctx context.Context, client *iam.Client, id string, d *sc
 					return nil, err
 				}
 				return resp.Response, err
-			}, http.StatusInternalServerError, http.StatusTooManyRequests)
+			}, http.StatusInternalServerError)
 		}
 	}
 
@@ -420,7 +420,7 @@ func purgeGroupContent(ctx context.Context, client *iam.Client, id string, d *sc
 					return nil, err
 				}
 				return resp.Response, err
-			}, http.StatusInternalServerError, http.StatusTooManyRequests)
+			}, http.StatusInternalServerError)
 		}
 	}
 	return nil
@@ -454,7 +454,7 @@ func resourceIAMGroupDelete(ctx context.Context, d *schema.ResourceData, m inter
 			return nil, err
 		}
 		return resp.Response, err
-	}, http.StatusInternalServerError, http.StatusTooManyRequests)
+	}, http.StatusInternalServerError)
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -0,0 +1,66 @@
+package group
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+	"github.com/philips-software/terraform-provider-hsdp/internal/simulator"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceIAMGroupSimulated(t *testing.T) {
+	sim := simulator.New()
+	defer sim.Close()
+
+	c := &config.Config{}
+	c.Region = "us-east"
+	c.Environment = "client-test"
+	c.OrgAdminUsername = "admin"
+	c.OrgAdminPassword = "password"
+	c.OAuth2ClientID = "client"
+	c.OAuth2Secret = "secret"
+	c.SimulatorURL = sim.URL()
+
+	client, err := c.IAMClient()
+	if !assert.Nil(t, err) {
+		return
+	}
+	role, _, err := client.Roles.CreateRole("GROUPROLE", "role", simulator.RootOrganizationID)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	ctx := context.Background()
+	d := schema.TestResourceDataRaw(t, ResourceIAMGroup().Schema, map[string]interface{}{
+		"name":                  "group",
+		"description":           "simulated group",
+		"managing_organization": simulator.RootOrganizationID,
+		"roles":                 []interface{}{role.ID},
+	})
+	diags := resourceIAMGroupCreate(ctx, d, c)
+	if !assert.False(t, diags.HasError(), diags) {
+		return
+	}
+	assert.NotEmpty(t, d.Id())
+	assert.Equal(t, []interface{}{role.ID}, d.Get("roles").(*schema.Set).List())
+
+	// Remove the role behind Terraform's back and expect Read to notice
+	group, _, err := client.Groups.GetGroupByID(d.Id())
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, _, err = client.Groups.RemoveRole(*group, *role)
+	assert.Nil(t, err)
+	diags = resourceIAMGroupRead(ctx, d, c)
+	assert.False(t, diags.HasError(), diags)
+	assert.Empty(t, d.Get("roles").(*schema.Set).List())
+
+	diags = resourceIAMGroupDelete(ctx, d, c)
+	assert.False(t, diags.HasError(), diags)
+	_, resp, _ := client.Groups.GetGroupByID(group.ID)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, 404, resp.StatusCode)
+	}
+}
@@ -107,7 +107,7 @@ func resourceIAMEmailTemplateCreate(ctx context.Context, d *schema.ResourceData,
 			return nil, err
 		}
 		return resp.Response, err
-	}, http.StatusInternalServerError, http.StatusTooManyRequests)
+	}, http.StatusInternalServerError)
 
 	if err != nil {
 		if resp.StatusCode == http.StatusConflict {
@@ -14,6 +14,14 @@ func DataSourceNotificationProducer() *schema.Resource {
 	return &schema.Resource{
 		ReadContext: dataSourceNotificationProducerRead,
 		Schema: map[string]*schema.Schema{
+			"region": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"environment": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
 			"producer_id": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -60,7 +68,7 @@ func dataSourceNotificationProducerRead(_ context.Context, d *schema.ResourceDat
 
 	var diags diag.Diagnostics
 
-	client, err := c.NotificationClient()
+	client, err := c.NotificationClient(d.Get("region").(string), d.Get("environment").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -14,6 +14,14 @@ func DataSourceNotificationProducers() *schema.Resource {
 	return &schema.Resource{
 		ReadContext: dataSourceNotificationProducersRead,
 		Schema: map[string]*schema.Schema{
+			"region": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"environment": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
 			"managing_organization_id": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -33,7 +41,7 @@ func dataSourceNotificationProducersRead(_ context.Context, d *schema.ResourceDa
 
 	var diags diag.Diagnostics
 
-	client, err := c.NotificationClient()
+	client, err := c.NotificationClient(d.Get("region").(string), d.Get("environment").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -14,6 +14,14 @@ func DataSourceNotificationSubscriber() *schema.Resource {
 	return &schema.Resource{
 		ReadContext: dataSourceNotificationSubscriberRead,
 		Schema: map[string]*schema.Schema{
+			"region": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"environment": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
 			"subscriber_id": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -56,7 +64,7 @@ func dataSourceNotificationSubscriberRead(_ context.Context, d *schema.ResourceD
 
 	var diags diag.Diagnostics
 
-	client, err := c.NotificationClient()
+	client, err := c.NotificationClient(d.Get("region").(string), d.Get("environment").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
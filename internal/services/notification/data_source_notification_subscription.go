@@ -14,6 +14,14 @@ func DataSourceNotificationSubscription() *schema.Resource {
 	return &schema.Resource{
 		ReadContext: dataSourceNotificationSubscriptionRead,
 		Schema: map[string]*schema.Schema{
+			"region": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"environment": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
 			"subscription_id": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -39,7 +47,7 @@ func dataSourceNotificationSubscriptionRead(_ context.Context, d *schema.Resourc
 
 	var diags diag.Diagnostics
 
-	client, err := c.NotificationClient()
+	client, err := c.NotificationClient(d.Get("region").(string), d.Get("environment").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
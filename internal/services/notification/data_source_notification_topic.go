@@ -14,6 +14,14 @@ func DataSourceNotificationTopic() *schema.Resource {
 	return &schema.Resource{
 		ReadContext: dataSourceNotificationTopicRead,
 		Schema: map[string]*schema.Schema{
+			"region": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"environment": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
 			"topic_id": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -52,7 +60,7 @@ func dataSourceNotificationTopicRead(_ context.Context, d *schema.ResourceData,
 
 	var diags diag.Diagnostics
 
-	client, err := c.NotificationClient()
+	client, err := c.NotificationClient(d.Get("region").(string), d.Get("environment").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
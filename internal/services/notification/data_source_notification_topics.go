@@ -14,6 +14,14 @@ func DataSourceNotificationTopics() *schema.Resource {
 	return &schema.Resource{
 		ReadContext: dataSourceNotificationTopicsRead,
 		Schema: map[string]*schema.Schema{
+			"region": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"environment": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
 			"name": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -32,7 +40,7 @@ func dataSourceNotificationTopicsRead(_ context.Context, d *schema.ResourceData,
 
 	var diags diag.Diagnostics
 
-	client, err := c.NotificationClient()
+	client, err := c.NotificationClient(d.Get("region").(string), d.Get("environment").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -21,6 +21,16 @@ func ResourceNotificationProducer() *schema.Resource {
 		DeleteContext: resourceNotificationProducerDelete,
 
 		Schema: map[string]*schema.Schema{
+			"region": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+			"environment": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
 			"managing_organization_id": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -74,7 +84,7 @@ func ResourceNotificationProducer() *schema.Resource {
 func resourceNotificationProducerDelete(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	var diags diag.Diagnostics
 	c := m.(*config.Config)
-	client, err := c.NotificationClient()
+	client, err := c.NotificationClient(d.Get("region").(string), d.Get("environment").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -99,7 +109,7 @@ func resourceNotificationProducerDelete(_ context.Context, d *schema.ResourceDat
 func resourceNotificationProducerRead(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	var diags diag.Diagnostics
 	c := m.(*config.Config)
-	client, err := c.NotificationClient()
+	client, err := c.NotificationClient(d.Get("region").(string), d.Get("environment").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -135,7 +145,7 @@ func resourceNotificationProducerRead(_ context.Context, d *schema.ResourceData,
 
 func resourceNotificationProducerCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	c := m.(*config.Config)
-	client, err := c.NotificationClient()
+	client, err := c.NotificationClient(d.Get("region").(string), d.Get("environment").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -23,6 +23,16 @@ func ResourceNotificationTopic() *schema.Resource {
 		DeleteContext: resourceNotificationTopicDelete,
 
 		Schema: map[string]*schema.Schema{
+			"region": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+			"environment": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
 			"name": {
 				Type:     schema.TypeString,
 				Required: true,
@@ -64,7 +74,7 @@ func ResourceNotificationTopic() *schema.Resource {
 func resourceNotificationTopicDelete(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	var diags diag.Diagnostics
 	c := m.(*config.Config)
-	client, err := c.NotificationClient()
+	client, err := c.NotificationClient(d.Get("region").(string), d.Get("environment").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -89,7 +99,7 @@ func resourceNotificationTopicDelete(_ context.Context, d *schema.ResourceData,
 func resourceNotificationTopicRead(_ context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	var diags diag.Diagnostics
 	c := m.(*config.Config)
-	client, err := c.NotificationClient()
+	client, err := c.NotificationClient(d.Get("region").(string), d.Get("environment").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -114,7 +124,7 @@ func resourceNotificationTopicRead(_ context.Context, d *schema.ResourceData, m
 
 func resourceNotificationTopicCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	c := m.(*config.Config)
-	client, err := c.NotificationClient()
+	client, err := c.NotificationClient(d.Get("region").(string), d.Get("environment").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
@@ -150,7 +160,7 @@ func resourceNotificationTopicUpdate(_ context.Context, d *schema.ResourceData,
 	var diags diag.Diagnostics
 
 	c := m.(*config.Config)
-	client, err := c.NotificationClient()
+	client, err := c.NotificationClient(d.Get("region").(string), d.Get("environment").(string))
 	if err != nil {
 		return diag.FromErr(err)
 	}
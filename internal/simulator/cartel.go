@@ -0,0 +1,297 @@
+package simulator
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// instance is a simulated Cartel instance
+type instance struct {
+	InstanceID     string            `json:"instance_id"`
+	NameTag        string            `json:"name_tag"`
+	InstanceType   string            `json:"instance_type"`
+	Role           string            `json:"role"`
+	State          string            `json:"state"`
+	LaunchTime     string            `json:"launch_time"`
+	PrivateAddress string            `json:"private_address"`
+	PublicAddress  string            `json:"public_address,omitempty"`
+	Protection     bool              `json:"protection"`
+	SecurityGroups []string          `json:"security_groups"`
+	LdapGroups     []string          `json:"ldap_groups"`
+	BlockDevices   []string          `json:"block_devices"`
+	Tags           map[string]string `json:"tags"`
+	Subnet         string            `json:"subnet"`
+	Vpc            string            `json:"vpc"`
+	Zone           string            `json:"zone"`
+	Owner          string            `json:"owner"`
+
+	deployState string
+	starts      int
+}
+
+// cartelRequest mirrors the Cartel request body
+type cartelRequest struct {
+	NameTag       []string          `json:"name-tag"`
+	Role          string            `json:"role"`
+	SecurityGroup []string          `json:"security_group"`
+	LDAPGroups    []string          `json:"ldap_groups"`
+	InstanceType  string            `json:"instance_type"`
+	NumVolumes    int               `json:"num_vols"`
+	VolSize       int               `json:"vol_size"`
+	SubnetType    string            `json:"subnet_type"`
+	Subnet        string            `json:"subnet"`
+	Tags          map[string]string `json:"tags"`
+	Protect       bool              `json:"protect"`
+}
+
+var cartelSubnets = map[string]map[string]string{
+	"private-a": {"id": "subnet-0000000000000000a", "network": "10.0.0.0/24"},
+	"private-b": {"id": "subnet-0000000000000000b", "network": "10.0.1.0/24"},
+	"public-a":  {"id": "subnet-0000000000000001a", "network": "10.0.100.0/24"},
+}
+
+var cartelSecurityGroups = []string{"base", "http-from-cloud", "https-from-cloud", "tcp-8080", "tcp-8443"}
+
+func (s *Simulator) registerCartel() {
+	handlers := map[string]func(body cartelRequest) (int, interface{}){
+		"create":                 s.cartelCreate,
+		"instance_details":       s.cartelDetails,
+		"deployment_status":      s.cartelDeploymentStatus,
+		"destroy":                s.cartelDestroy,
+		"get_all_instances":      s.cartelAllInstances,
+		"add_tags":               s.cartelUpdate(func(i *instance, b cartelRequest) { mergeTags(i, b.Tags) }),
+		"add_ldap_group":         s.cartelUpdate(func(i *instance, b cartelRequest) { i.LdapGroups = union(i.LdapGroups, b.LDAPGroups) }),
+		"remove_ldap_group":      s.cartelUpdate(func(i *instance, b cartelRequest) { i.LdapGroups = difference(i.LdapGroups, b.LDAPGroups) }),
+		"add_security_groups":    s.cartelUpdate(func(i *instance, b cartelRequest) { i.SecurityGroups = union(i.SecurityGroups, b.SecurityGroup) }),
+		"remove_security_groups": s.cartelUpdate(func(i *instance, b cartelRequest) { i.SecurityGroups = difference(i.SecurityGroups, b.SecurityGroup) }),
+		"protect":                s.cartelUpdate(func(i *instance, b cartelRequest) { i.Protection = b.Protect }),
+		"start":                  s.cartelUpdate(func(i *instance, _ cartelRequest) { i.State = "running"; i.starts++ }),
+		"suspend":                s.cartelUpdate(func(i *instance, _ cartelRequest) { i.State = "stopped" }),
+		"get_security_groups": func(cartelRequest) (int, interface{}) {
+			return http.StatusOK, cartelSecurityGroups
+		},
+		"security_group_details": func(b cartelRequest) (int, interface{}) {
+			details := make(map[string]interface{})
+			for _, g := range b.SecurityGroup {
+				details[g] = []map[string]interface{}{
+					{"port_range": "443", "protocol": "tcp", "source": []string{"0.0.0.0/0"}},
+				}
+			}
+			return http.StatusOK, details
+		},
+		"get_all_roles": func(cartelRequest) (int, interface{}) {
+			return http.StatusOK, []map[string]string{
+				{"role": "container-host", "description": "Docker container host"},
+				{"role": "vanilla", "description": "Plain instance"},
+			}
+		},
+		"get_all_subnets": func(cartelRequest) (int, interface{}) {
+			return http.StatusOK, cartelSubnets
+		},
+	}
+	s.handle("v3/api", func(w http.ResponseWriter, r *http.Request, rest string) {
+		handler, ok := handlers[rest]
+		if !ok || r.Method != http.MethodPost {
+			writeJSON(w, http.StatusNotFound, cartelError(http.StatusNotFound, "unknown endpoint "+rest))
+			return
+		}
+		var body cartelRequest
+		if err := decodeBody(r, &body); err != nil {
+			writeJSON(w, http.StatusBadRequest, cartelError(http.StatusBadRequest, "invalid request body"))
+			return
+		}
+		status, response := handler(body)
+		writeJSON(w, status, response)
+	})
+}
+
+func cartelError(code int, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"code":        code,
+		"description": description,
+	}
+}
+
+// Instance returns a copy of the Cartel instance with the given name tag
+func (s *Simulator) Instance(nameTag string) (InstanceState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.instances[nameTag]
+	if !ok {
+		return InstanceState{}, false
+	}
+	return InstanceState{
+		InstanceID:   i.InstanceID,
+		InstanceType: i.InstanceType,
+		State:        i.State,
+		DeployState:  i.deployState,
+		Starts:       i.starts,
+		Tags:         copyTags(i.Tags),
+	}, true
+}
+
+// InstanceState is the externally visible state of a simulated instance
+type InstanceState struct {
+	InstanceID   string
+	InstanceType string
+	State        string
+	DeployState  string
+	Starts       int
+	Tags         map[string]string
+}
+
+func (s *Simulator) cartelCreate(b cartelRequest) (int, interface{}) {
+	if len(b.NameTag) != 1 {
+		return http.StatusBadRequest, cartelError(http.StatusBadRequest, "exactly one name-tag is required")
+	}
+	name := b.NameTag[0]
+	if _, exists := s.instances[name]; exists {
+		return http.StatusBadRequest, cartelError(http.StatusBadRequest, fmt.Sprintf("Host named %s already exists!", name))
+	}
+	s.launched++
+	n := s.launched
+	subnetType := b.SubnetType
+	if subnetType == "" {
+		subnetType = "private"
+	}
+	subnet := cartelSubnets[subnetType+"-a"]["id"]
+	if b.Subnet != "" {
+		subnet = b.Subnet
+	}
+	i := &instance{
+		InstanceID:     fmt.Sprintf("i-%017x", n),
+		NameTag:        name,
+		InstanceType:   b.InstanceType,
+		Role:           b.Role,
+		State:          "running",
+		LaunchTime:     time.Now().UTC().Format(time.RFC3339),
+		PrivateAddress: fmt.Sprintf("10.0.%d.%d", n/250, n%250+4),
+		Protection:     b.Protect,
+		SecurityGroups: union([]string{"base"}, b.SecurityGroup),
+		LdapGroups:     b.LDAPGroups,
+		BlockDevices:   []string{"/dev/sda1"},
+		Tags:           copyTags(b.Tags),
+		Subnet:         subnet,
+		Vpc:            "vpc-00000000000000001",
+		Zone:           "us-east-1a",
+		Owner:          "simulator",
+		deployState:    "succeeded",
+	}
+	if i.InstanceType == "" {
+		i.InstanceType = "t2.medium"
+	}
+	if subnetType == "public" {
+		i.PublicAddress = fmt.Sprintf("203.0.113.%d", n%250+4)
+	}
+	for v := 0; v < b.NumVolumes; v++ {
+		i.BlockDevices = append(i.BlockDevices, fmt.Sprintf("/dev/xvd%c", 'b'+v))
+	}
+	s.instances[name] = i
+	return http.StatusOK, map[string]interface{}{
+		"result": "Success",
+		"message": []map[string]interface{}{
+			{
+				"instance_id": i.InstanceID,
+				"ip_address":  i.PrivateAddress,
+				"name":        name,
+				"role":        i.Role,
+			},
+		},
+	}
+}
+
+func (s *Simulator) cartelDetails(b cartelRequest) (int, interface{}) {
+	var details []map[string]*instance
+	for _, name := range b.NameTag {
+		if i, ok := s.instances[name]; ok {
+			details = append(details, map[string]*instance{name: i})
+		}
+	}
+	if len(details) == 0 {
+		return http.StatusBadRequest, cartelError(http.StatusBadRequest, "Instance not found")
+	}
+	return http.StatusOK, details
+}
+
+func (s *Simulator) cartelDeploymentStatus(b cartelRequest) (int, interface{}) {
+	status := make(map[string]interface{})
+	for _, name := range b.NameTag {
+		if i, ok := s.instances[name]; ok {
+			status[name] = map[string]string{"deploy_state": i.deployState}
+		}
+	}
+	if len(status) == 0 {
+		return http.StatusBadRequest, cartelError(http.StatusBadRequest, "Instance not found")
+	}
+	return http.StatusOK, status
+}
+
+func (s *Simulator) cartelDestroy(b cartelRequest) (int, interface{}) {
+	removed := make(map[string]string)
+	for _, name := range b.NameTag {
+		i, ok := s.instances[name]
+		if !ok {
+			return http.StatusBadRequest, cartelError(http.StatusBadRequest, "Instance not found")
+		}
+		if i.Protection {
+			return http.StatusBadRequest, cartelError(http.StatusBadRequest, "Instance "+name+" is protected")
+		}
+		delete(s.instances, name)
+		removed[name] = "Instance removed."
+	}
+	return http.StatusOK, map[string]interface{}{
+		"AWS":    "Termination started",
+		"Cartel": removed,
+	}
+}
+
+func (s *Simulator) cartelAllInstances(cartelRequest) (int, interface{}) {
+	names := make([]string, 0, len(s.instances))
+	for name := range s.instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	all := make([]*instance, 0, len(names))
+	for _, name := range names {
+		all = append(all, s.instances[name])
+	}
+	return http.StatusOK, all
+}
+
+// cartelUpdate applies update to all instances named in the request
+func (s *Simulator) cartelUpdate(update func(i *instance, b cartelRequest)) func(b cartelRequest) (int, interface{}) {
+	return func(b cartelRequest) (int, interface{}) {
+		for _, name := range b.NameTag {
+			if _, ok := s.instances[name]; !ok {
+				return http.StatusBadRequest, cartelError(http.StatusBadRequest, "Instance "+name+" not found")
+			}
+		}
+		for _, name := range b.NameTag {
+			update(s.instances[name], b)
+		}
+		return http.StatusOK, map[string]interface{}{"result": "Success", "message": "Success"}
+	}
+}
+
+func mergeTags(i *instance, tags map[string]string) {
+	if i.Tags == nil {
+		i.Tags = make(map[string]string)
+	}
+	for k, v := range tags {
+		if v == "" {
+			delete(i.Tags, k)
+			continue
+		}
+		i.Tags[k] = v
+	}
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	c := make(map[string]string, len(tags))
+	for k, v := range tags {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,119 @@
+package simulator
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// registerCDR serves a FHIR store below store/fhir/{tenant}. Resources are
+// kept as raw JSON keyed by their path so both STU3 and R4 payloads work.
+func (s *Simulator) registerCDR() {
+	s.handle("store/fhir", func(w http.ResponseWriter, r *http.Request, rest string) {
+		parts := strings.Split(rest, "/")
+		if len(parts) < 2 || parts[0] == "" {
+			writeError(w, http.StatusNotFound, "missing tenant or resource type")
+			return
+		}
+		switch {
+		case len(parts) == 2 && r.Method == http.MethodPost:
+			s.fhirCreate(w, r, rest, "")
+		case len(parts) == 3 && r.Method == http.MethodPut:
+			s.fhirCreate(w, r, strings.Join(parts[:2], "/"), parts[2])
+		case len(parts) == 3 && r.Method == http.MethodGet:
+			resource, ok := s.fhir[rest]
+			if !ok {
+				writeError(w, http.StatusNotFound, rest+" not found")
+				return
+			}
+			writeFHIR(w, http.StatusOK, resource)
+		case len(parts) == 3 && r.Method == http.MethodPatch:
+			s.fhirPatch(w, r, rest)
+		case len(parts) == 3 && r.Method == http.MethodDelete:
+			delete(s.fhir, rest)
+			writeJSON(w, http.StatusNoContent, nil)
+		case len(parts) == 2 && r.Method == http.MethodGet:
+			s.fhirSearch(w, rest)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, r.Method+" not supported on "+rest)
+		}
+	})
+}
+
+func (s *Simulator) fhirCreate(w http.ResponseWriter, r *http.Request, collection, id string) {
+	var resource map[string]interface{}
+	if err := decodeBody(r, &resource); err != nil || resource == nil {
+		writeError(w, http.StatusBadRequest, "invalid FHIR resource")
+		return
+	}
+	if id == "" {
+		id = newID()
+	}
+	resource["id"] = id
+	body, _ := json.Marshal(resource)
+	path := collection + "/" + id
+	status := http.StatusCreated
+	if _, exists := s.fhir[path]; exists {
+		status = http.StatusOK
+	}
+	s.fhir[path] = body
+	w.Header().Set("Location", "/store/fhir/"+path+"/_history/1")
+	writeFHIR(w, status, body)
+}
+
+// fhirPatch applies the add, replace and remove operations of a JSON Patch
+// to top level fields of the resource
+func (s *Simulator) fhirPatch(w http.ResponseWriter, r *http.Request, path string) {
+	current, ok := s.fhir[path]
+	if !ok {
+		writeError(w, http.StatusNotFound, path+" not found")
+		return
+	}
+	var ops []struct {
+		Op    string      `json:"op"`
+		Path  string      `json:"path"`
+		Value interface{} `json:"value"`
+	}
+	body, _ := io.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &ops); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON patch")
+		return
+	}
+	var resource map[string]interface{}
+	_ = json.Unmarshal(current, &resource)
+	for _, op := range ops {
+		field := strings.TrimPrefix(op.Path, "/")
+		switch op.Op {
+		case "add", "replace":
+			resource[field] = op.Value
+		case "remove":
+			delete(resource, field)
+		}
+	}
+	updated, _ := json.Marshal(resource)
+	s.fhir[path] = updated
+	writeFHIR(w, http.StatusOK, updated)
+}
+
+func (s *Simulator) fhirSearch(w http.ResponseWriter, collection string) {
+	entries := []map[string]interface{}{}
+	for path, resource := range s.fhir {
+		if strings.HasPrefix(path, collection+"/") {
+			entries = append(entries, map[string]interface{}{"resource": json.RawMessage(resource)})
+		}
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"resourceType": "Bundle",
+		"type":         "searchset",
+		"total":        len(entries),
+		"entry":        entries,
+	})
+	writeFHIR(w, http.StatusOK, body)
+}
+
+func writeFHIR(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/fhir+json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,313 @@
+package simulator
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type bundleStyle int
+
+const (
+	// bundleResources wraps each match in {"resource": ...} (FHIR style)
+	bundleResources bundleStyle = iota
+	// bundleEntries lists matches directly in "entry"
+	bundleEntries
+	// bundleSCIM lists matches in "Resources" (SCIM style)
+	bundleSCIM
+)
+
+type actionFunc func(w http.ResponseWriter, r *http.Request, item map[string]interface{})
+
+// collection is a generic in-memory REST collection. Search, create, read,
+// update and delete follow the conventions of the HSDP API it simulates.
+type collection struct {
+	resourceType string
+	idField      string
+	bundle       bundleStyle
+	// params maps query parameters to dotted item fields
+	params map[string]string
+	// hidden fields are only returned by create, e.g. generated secrets
+	hidden []string
+	// location, when set, is the prefix of the Location header on create
+	location string
+
+	createStatus int
+	updateStatus int
+	deleteStatus int
+
+	onCreate func(item map[string]interface{})
+	onDelete func(id string)
+	// filter handles query parameters which do not map to item fields. It
+	// returns whether item matches and whether the parameter was handled.
+	filter func(key, value string, item map[string]interface{}) (bool, bool)
+	// view transforms items in search results
+	view    func(item map[string]interface{}) map[string]interface{}
+	actions map[string]actionFunc
+	// collectionActions handle requests like POST Subscription/_confirm
+	collectionActions map[string]http.HandlerFunc
+
+	items map[string]map[string]interface{}
+	order []string
+}
+
+func newCollection(resourceType string) *collection {
+	return &collection{
+		resourceType: resourceType,
+		idField:      "id",
+		createStatus: http.StatusCreated,
+		updateStatus: http.StatusOK,
+		deleteStatus: http.StatusNoContent,
+		params:       map[string]string{"_id": "id"},
+		actions:      make(map[string]actionFunc),
+		items:        make(map[string]map[string]interface{}),
+	}
+}
+
+func (c *collection) get(id string) (map[string]interface{}, bool) {
+	item, ok := c.items[id]
+	return item, ok
+}
+
+func (c *collection) list() []map[string]interface{} {
+	items := make([]map[string]interface{}, 0, len(c.order))
+	for _, id := range c.order {
+		items = append(items, c.items[id])
+	}
+	return items
+}
+
+func (c *collection) insert(item map[string]interface{}) map[string]interface{} {
+	id, _ := item[c.idField].(string)
+	if id == "" {
+		id = newID()
+		item[c.idField] = id
+	}
+	if c.resourceType != "" {
+		if _, ok := item["resourceType"]; !ok {
+			item["resourceType"] = c.resourceType
+		}
+	}
+	if c.onCreate != nil {
+		c.onCreate(item)
+	}
+	if _, exists := c.items[id]; !exists {
+		c.order = append(c.order, id)
+	}
+	c.items[id] = item
+	return item
+}
+
+func (c *collection) remove(id string) {
+	delete(c.items, id)
+	for i, o := range c.order {
+		if o == id {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+	if c.onDelete != nil {
+		c.onDelete(id)
+	}
+}
+
+// public returns a copy of item without hidden fields
+func (c *collection) public(item map[string]interface{}) map[string]interface{} {
+	out := copyItem(item)
+	for _, h := range c.hidden {
+		delete(out, h)
+	}
+	return out
+}
+
+func (c *collection) search(query url.Values) []map[string]interface{} {
+	var found []map[string]interface{}
+	for _, item := range c.list() {
+		if c.match(query, item) {
+			found = append(found, item)
+		}
+	}
+	return found
+}
+
+func (c *collection) match(query url.Values, item map[string]interface{}) bool {
+	for key, values := range query {
+		value := values[0]
+		if c.filter != nil {
+			if ok, handled := c.filter(key, value, item); handled {
+				if !ok {
+					return false
+				}
+				continue
+			}
+		}
+		field, ok := c.params[key]
+		if !ok {
+			if strings.HasPrefix(key, "_") {
+				continue // Paging, includes and other modifiers
+			}
+			field = key
+		}
+		v, found := lookup(item, field)
+		if !found {
+			// Try the common reference and identifier suffixes
+			for _, suffix := range []string{"Id", "Guid"} {
+				if v, found = lookup(item, field+suffix); found {
+					break
+				}
+			}
+		}
+		if !found {
+			if _, mapped := c.params[key]; mapped {
+				return false
+			}
+			continue // Parameters we do not know about do not filter
+		}
+		if !matches(v, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *collection) bundleOf(items []map[string]interface{}) map[string]interface{} {
+	views := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		view := c.public(item)
+		if c.view != nil {
+			view = c.view(view)
+		}
+		views = append(views, view)
+	}
+	switch c.bundle {
+	case bundleSCIM:
+		return map[string]interface{}{
+			"schemas":      []string{"urn:ietf:params:scim:api:messages:2.0:ListResponse"},
+			"totalResults": len(views),
+			"Resources":    views,
+		}
+	case bundleEntries:
+		return map[string]interface{}{
+			"resourceType": "bundle",
+			"type":         "searchset",
+			"total":        len(views),
+			"entry":        views,
+		}
+	}
+	entries := make([]interface{}, 0, len(views))
+	for _, v := range views {
+		entries = append(entries, map[string]interface{}{"resource": v})
+	}
+	return map[string]interface{}{
+		"resourceType": "Bundle",
+		"type":         "searchset",
+		"total":        len(views),
+		"entry":        entries,
+	}
+}
+
+// serve handles a request for the collection. rest is the path below the
+// collection prefix.
+func (c *collection) serve(w http.ResponseWriter, r *http.Request, rest string) {
+	parts := strings.SplitN(rest, "/", 2)
+	id := parts[0]
+
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, c.bundleOf(c.search(r.URL.Query())))
+		case http.MethodPost:
+			var item map[string]interface{}
+			if err := decodeBody(r, &item); err != nil || item == nil {
+				writeError(w, http.StatusBadRequest, "invalid request body")
+				return
+			}
+			delete(item, c.idField)
+			created := c.insert(item)
+			if c.location != "" {
+				w.Header().Set("Location", c.location+"/"+created[c.idField].(string))
+			}
+			writeJSON(w, c.createStatus, copyItem(created))
+		default:
+			writeError(w, http.StatusMethodNotAllowed, r.Method+" not supported")
+		}
+		return
+	}
+
+	if action, ok := c.collectionActions[id]; ok && len(parts) == 1 {
+		action(w, r)
+		return
+	}
+
+	item, ok := c.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, c.resourceType+" "+id+" not found")
+		return
+	}
+
+	if len(parts) == 2 {
+		action, ok := c.actions[parts[1]]
+		if !ok {
+			writeError(w, http.StatusNotFound, "unsupported operation "+parts[1])
+			return
+		}
+		action(w, r, item)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, c.public(item))
+	case http.MethodPut:
+		var update map[string]interface{}
+		if err := decodeBody(r, &update); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		for k, v := range update {
+			if k == c.idField {
+				continue
+			}
+			item[k] = v
+		}
+		bumpVersion(item)
+		if c.updateStatus == http.StatusNoContent {
+			writeJSON(w, http.StatusNoContent, nil)
+			return
+		}
+		writeJSON(w, c.updateStatus, c.public(item))
+	case http.MethodDelete:
+		c.remove(id)
+		writeJSON(w, c.deleteStatus, nil)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, r.Method+" not supported")
+	}
+}
+
+// bumpVersion increments meta.version or meta.versionId when present
+func bumpVersion(item map[string]interface{}) {
+	meta, ok := item["meta"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for _, key := range []string{"version", "versionId"} {
+		if v, ok := meta[key].(string); ok {
+			meta[key] = nextVersion(v)
+		}
+	}
+}
+
+func nextVersion(v string) string {
+	n := 0
+	for _, r := range v {
+		if r >= '0' && r <= '9' {
+			n = n*10 + int(r-'0')
+		}
+	}
+	next := strconv.Itoa(n + 1)
+	if strings.HasPrefix(v, "W/") {
+		return `W/"` + next + `"`
+	}
+	return next
+}
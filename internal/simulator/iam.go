@@ -0,0 +1,530 @@
+package simulator
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RootOrganizationID is the ID of the organization every simulated
+// session is a member of
+const RootOrganizationID = "00000000-0000-4000-8000-000000000001"
+
+var scimFilter = regexp.MustCompile(`^\s*([\w.]+)\s+eq\s+"([^"]*)"\s*$`)
+
+func (s *Simulator) registerIAM() {
+	s.handle("authorize/oauth2/token", s.token)
+	s.handle("oauth/token", s.token) // Console UAA
+	s.handle("authorize/oauth2/introspect", s.introspect)
+	s.handle("authorize/oauth2/revoke", ok)
+	s.handle("authorize/oauth2/endsession", ok)
+
+	orgs := s.organizations()
+	groups := s.groups()
+	roles := s.roles(groups)
+	users := s.users()
+	services := s.services()
+	clients := s.clients()
+	permissions := newCollection("Permission")
+	for _, name := range []string{"ALL.READ", "ALL.WRITE", "GROUP.READ", "GROUP.WRITE", "ORGANIZATION.READ", "ORGANIZATION.WRITE"} {
+		permissions.insert(map[string]interface{}{"name": name, "description": name, "category": strings.Split(name, ".")[0], "type": "GLOBAL"})
+	}
+
+	s.handle("authorize/scim/v2/Organizations", serveOrganizations(orgs))
+	s.handle("authorize/identity/Group", groups.serve)
+	s.handle("authorize/identity/Role", roles.serve)
+	s.handle("authorize/identity/Permission", func(w http.ResponseWriter, r *http.Request, _ string) {
+		var found []map[string]interface{}
+		if roleID := r.URL.Query().Get("roleId"); roleID != "" {
+			role, _ := roles.get(roleID)
+			for _, p := range toStrings(role["permissions"]) {
+				found = append(found, permissions.search(map[string][]string{"name": {p}})...)
+			}
+		} else {
+			found = permissions.search(r.URL.Query())
+		}
+		writeJSON(w, http.StatusOK, permissions.bundleOf(found))
+	})
+	s.handle("authorize/identity/User", users.serve)
+	s.handle("security/users", s.legacyUsers(users))
+	s.handle("authorize/identity/Service", services.serve)
+	s.handle("authorize/identity/Client", clients.serve)
+}
+
+func ok(w http.ResponseWriter, _ *http.Request, _ string) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+func (s *Simulator) token(w http.ResponseWriter, r *http.Request, _ string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, r.Method+" not supported")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	switch r.Form.Get("grant_type") {
+	case "password", "refresh_token", "client_credentials", "urn:ietf:params:oauth:grant-type:jwt-bearer":
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error":             "unsupported_grant_type",
+			"error_description": "unsupported grant type '" + r.Form.Get("grant_type") + "'",
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  "sim-" + newID(),
+		"refresh_token": "sim-refresh-" + newID(),
+		"id_token":      "sim-id-" + newID(),
+		"token_type":    "Bearer",
+		"expires_in":    1799,
+		"scope":         "auth_iam_introspect auth_iam_organization mail openid profile cn",
+	})
+}
+
+func (s *Simulator) introspect(w http.ResponseWriter, _ *http.Request, _ string) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"active":     true,
+		"scope":      "auth_iam_introspect auth_iam_organization mail openid profile cn",
+		"username":   "simulator",
+		"exp":        time.Now().Add(30 * time.Minute).Unix(),
+		"sub":        RootOrganizationID,
+		"client_id":  "simulator",
+		"token_type": "Bearer",
+		"organizations": map[string]interface{}{
+			"managingOrganization": RootOrganizationID,
+			"organizationList": []map[string]interface{}{
+				{
+					"organizationId":       RootOrganizationID,
+					"organizationName":     "simulator",
+					"permissions":          []string{"ALL.READ", "ALL.WRITE"},
+					"effectivePermissions": []string{"ALL.READ", "ALL.WRITE"},
+				},
+			},
+		},
+	})
+}
+
+func (s *Simulator) organizations() *collection {
+	orgs := newCollection("Organization")
+	orgs.bundle = bundleSCIM
+	orgs.deleteStatus = http.StatusAccepted
+	orgs.filter = func(key, value string, item map[string]interface{}) (bool, bool) {
+		switch key {
+		case "attributes", "excludedAttributes":
+			return true, true
+		case "filter":
+			m := scimFilter.FindStringSubmatch(value)
+			if m == nil {
+				return false, true
+			}
+			v, found := lookup(item, m[1])
+			return found && matches(v, m[2]), true
+		}
+		return false, false
+	}
+	orgs.onCreate = func(item map[string]interface{}) {
+		now := time.Now().UTC().Format(time.RFC3339)
+		item["meta"] = map[string]interface{}{
+			"resourceType": "Organization",
+			"created":      now,
+			"lastModified": now,
+			"version":      `W/"1"`,
+			"location":     "/authorize/scim/v2/Organizations/" + item["id"].(string),
+		}
+		if _, ok := item["active"]; !ok {
+			item["active"] = true
+		}
+	}
+	orgs.insert(map[string]interface{}{
+		"id":          RootOrganizationID,
+		"name":        "simulator",
+		"displayName": "Simulator root organization",
+		"type":        "Hospital",
+		"schemas":     []string{"urn:ietf:params:scim:schemas:core:philips:hsdp:2.0:Organization"},
+	})
+	return orgs
+}
+
+// serveOrganizations adds the deleteStatus endpoint to the organizations
+// collection. Deletes complete immediately in the simulator.
+func serveOrganizations(orgs *collection) func(w http.ResponseWriter, r *http.Request, rest string) {
+	deleted := make(map[string]bool)
+	orgs.onDelete = func(id string) {
+		deleted[id] = true
+	}
+	return func(w http.ResponseWriter, r *http.Request, rest string) {
+		id := strings.TrimSuffix(rest, "/deleteStatus")
+		if id == rest {
+			orgs.serve(w, r, rest)
+			return
+		}
+		status := "IN_PROGRESS"
+		if deleted[id] {
+			status = "SUCCESS"
+		} else if _, found := orgs.get(id); !found {
+			writeError(w, http.StatusNotFound, "Organization "+id+" not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:DeleteStatus"},
+			"id":      id,
+			"status":  status,
+			"meta":    map[string]interface{}{"resourceType": "Organization"},
+		})
+	}
+}
+
+func (s *Simulator) groups() *collection {
+	groups := newCollection("Group")
+	groups.params["orgID"] = "managingOrganization"
+	groups.params["organizationId"] = "managingOrganization"
+	groups.filter = func(key, value string, item map[string]interface{}) (bool, bool) {
+		switch key {
+		case "memberType":
+			return true, true // Evaluated together with memberId
+		case "memberId":
+			for _, members := range s.groupMembers[item["id"].(string)] {
+				if containsString(members, value) {
+					return true, true
+				}
+			}
+			return false, true
+		}
+		return false, false
+	}
+	groups.view = func(item map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"_id":              item["id"],
+			"resourceType":     "Group",
+			"groupName":        item["name"],
+			"orgId":            item["managingOrganization"],
+			"groupDescription": item["description"],
+		}
+	}
+	groups.onDelete = func(id string) {
+		delete(s.groupRoles, id)
+		delete(s.groupMembers, id)
+	}
+	groups.actions["$assign-role"] = s.groupRoleAction(true)
+	groups.actions["$remove-role"] = s.groupRoleAction(false)
+	groups.actions["$add-members"] = s.groupMemberAction("USER", true)
+	groups.actions["$remove-members"] = s.groupMemberAction("USER", false)
+	groups.actions["$assign"] = s.groupMemberAction("", true)
+	groups.actions["$remove"] = s.groupMemberAction("", false)
+	return groups
+}
+
+func (s *Simulator) groupRoleAction(assign bool) actionFunc {
+	return func(w http.ResponseWriter, r *http.Request, item map[string]interface{}) {
+		var body struct {
+			Roles []string `json:"roles"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		id := item["id"].(string)
+		for _, role := range body.Roles {
+			if assign {
+				if !containsString(s.groupRoles[id], role) {
+					s.groupRoles[id] = append(s.groupRoles[id], role)
+				}
+				continue
+			}
+			s.groupRoles[id] = removeString(s.groupRoles[id], role)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{})
+	}
+}
+
+// groupMemberAction handles both the FHIR Parameters body used for users
+// and the memberType/value body used for services and devices
+func (s *Simulator) groupMemberAction(memberType string, add bool) actionFunc {
+	return func(w http.ResponseWriter, r *http.Request, item map[string]interface{}) {
+		var body struct {
+			MemberType string   `json:"memberType"`
+			Value      []string `json:"value"`
+			Parameter  []struct {
+				References []struct {
+					Reference string `json:"reference"`
+				} `json:"references"`
+			} `json:"parameter"`
+		}
+		if err := decodeBody(r, &body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		kind := memberType
+		if kind == "" {
+			kind = body.MemberType
+		}
+		ids := body.Value
+		for _, p := range body.Parameter {
+			for _, ref := range p.References {
+				ids = append(ids, ref.Reference)
+			}
+		}
+		id := item["id"].(string)
+		if s.groupMembers[id] == nil {
+			s.groupMembers[id] = make(map[string][]string)
+		}
+		for _, member := range ids {
+			if add {
+				if !containsString(s.groupMembers[id][kind], member) {
+					s.groupMembers[id][kind] = append(s.groupMembers[id][kind], member)
+				}
+				continue
+			}
+			s.groupMembers[id][kind] = removeString(s.groupMembers[id][kind], member)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"resourceType": "Parameters"})
+	}
+}
+
+func (s *Simulator) roles(groups *collection) *collection {
+	roles := newCollection("Role")
+	roles.bundle = bundleEntries
+	roles.params["roleId"] = "id"
+	roles.params["organizationId"] = "managingOrganization"
+	roles.filter = func(key, value string, item map[string]interface{}) (bool, bool) {
+		if key != "groupId" {
+			return false, false
+		}
+		if _, ok := groups.get(value); !ok {
+			return false, true
+		}
+		return containsString(s.groupRoles[value], item["id"].(string)), true
+	}
+	roles.onDelete = func(id string) {
+		for group, assigned := range s.groupRoles {
+			s.groupRoles[group] = removeString(assigned, id)
+		}
+	}
+	permissionAction := func(add bool) actionFunc {
+		return func(w http.ResponseWriter, r *http.Request, item map[string]interface{}) {
+			var body struct {
+				Permissions []string `json:"permissions"`
+			}
+			if err := decodeBody(r, &body); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid request body")
+				return
+			}
+			current := toStrings(item["permissions"])
+			for _, p := range body.Permissions {
+				if add {
+					if !containsString(current, p) {
+						current = append(current, p)
+					}
+					continue
+				}
+				current = removeString(current, p)
+			}
+			item["permissions"] = current
+			writeJSON(w, http.StatusOK, map[string]interface{}{})
+		}
+	}
+	roles.actions["$assign-permission"] = permissionAction(true)
+	roles.actions["$remove-permission"] = permissionAction(false)
+	roles.hidden = []string{"permissions"}
+	return roles
+}
+
+func (s *Simulator) services() *collection {
+	services := newCollection("Service")
+	services.bundle = bundleEntries
+	services.hidden = []string{"privateKey"}
+	services.onCreate = func(item map[string]interface{}) {
+		name, _ := item["name"].(string)
+		app, _ := item["applicationId"].(string)
+		item["serviceId"] = strings.ToLower(name) + "@" + app + ".simulator.hsdp.io"
+		item["organizationId"] = RootOrganizationID
+		item["expiresOn"] = time.Now().AddDate(1, 0, 0).UTC().Format(time.RFC3339)
+		item["privateKey"] = generatePrivateKey()
+		if _, ok := item["scopes"]; !ok {
+			item["scopes"] = []string{"openid"}
+		}
+		if _, ok := item["defaultScopes"]; !ok {
+			item["defaultScopes"] = []string{"openid"}
+		}
+	}
+	services.actions["$scopes"] = scopesAction
+	services.actions["$update-certificate"] = func(w http.ResponseWriter, _ *http.Request, item map[string]interface{}) {
+		writeJSON(w, http.StatusOK, item)
+	}
+	return services
+}
+
+func (s *Simulator) clients() *collection {
+	clients := newCollection("Client")
+	clients.bundle = bundleEntries
+	clients.location = "/authorize/identity/Client"
+	clients.hidden = []string{"password"}
+	clients.onCreate = func(item map[string]interface{}) {
+		item["realms"] = []string{"public"}
+		item["meta"] = map[string]interface{}{"versionId": "0"}
+	}
+	clients.actions["$scopes"] = scopesAction
+	return clients
+}
+
+func scopesAction(w http.ResponseWriter, r *http.Request, item map[string]interface{}) {
+	var body struct {
+		Action        string   `json:"action"`
+		Scopes        []string `json:"scopes"`
+		DefaultScopes []string `json:"defaultScopes"`
+	}
+	if err := decodeBody(r, &body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	switch body.Action {
+	case "add":
+		item["scopes"] = union(toStrings(item["scopes"]), body.Scopes)
+		item["defaultScopes"] = union(toStrings(item["defaultScopes"]), body.DefaultScopes)
+	case "remove":
+		item["scopes"] = difference(toStrings(item["scopes"]), body.Scopes)
+		item["defaultScopes"] = difference(toStrings(item["defaultScopes"]), body.DefaultScopes)
+	default:
+		item["scopes"] = body.Scopes
+		item["defaultScopes"] = body.DefaultScopes
+	}
+	writeJSON(w, http.StatusNoContent, nil)
+}
+
+func (s *Simulator) users() *collection {
+	users := newCollection("Person")
+	users.bundle = bundleEntries
+	users.location = "/authorize/identity/User"
+	users.hidden = []string{"password"}
+	users.params["organizationID"] = "managingOrganization"
+	users.filter = func(key, value string, item map[string]interface{}) (bool, bool) {
+		switch key {
+		case "userId":
+			return item["id"] == value || strings.EqualFold(item["loginId"].(string), value), true
+		case "loginId":
+			return strings.EqualFold(item["loginId"].(string), value), true
+		case "groupId":
+			return containsString(s.groupMembers[value]["USER"], item["id"].(string)), true
+		case "profileType", "pageSize", "pageNumber":
+			return true, true
+		}
+		return false, false
+	}
+	users.onCreate = func(item map[string]interface{}) {
+		for _, t := range toMaps(item["telecom"]) {
+			switch t["system"] {
+			case "email":
+				item["emailAddress"] = t["value"]
+			case "mobile":
+				item["phoneNumber"] = t["value"]
+			}
+		}
+		if _, ok := item["managingOrganization"]; !ok {
+			item["managingOrganization"] = RootOrganizationID
+		}
+		item["accountStatus"] = map[string]interface{}{
+			"mfaStatus":     "NOTREQUIRED",
+			"emailVerified": false,
+			"disabled":      item["disabled"],
+		}
+		item["passwordStatus"] = map[string]interface{}{
+			"passwordExpiresOn": time.Now().AddDate(0, 3, 0).UTC().Format(time.RFC3339),
+		}
+	}
+	users.actions["$change-loginid"] = func(w http.ResponseWriter, r *http.Request, item map[string]interface{}) {
+		var body struct {
+			LoginID string `json:"loginId"`
+		}
+		_ = decodeBody(r, &body)
+		item["loginId"] = body.LoginID
+		writeJSON(w, http.StatusNoContent, nil)
+	}
+	for _, action := range []string{"$mfa", "$unlock", "$resend-activation", "$set-password"} {
+		users.actions[action] = func(w http.ResponseWriter, _ *http.Request, _ map[string]interface{}) {
+			writeJSON(w, http.StatusOK, map[string]interface{}{})
+		}
+	}
+	// POST User/$resend-activation et al. target the collection
+	users.collectionActions = map[string]http.HandlerFunc{}
+	for _, action := range []string{"$resend-activation", "$set-password", "$recover-password", "$change-password"} {
+		users.collectionActions[action] = func(w http.ResponseWriter, _ *http.Request) {
+			writeJSON(w, http.StatusOK, map[string]interface{}{})
+		}
+	}
+	users.onDelete = func(id string) {
+		for _, members := range s.groupMembers {
+			members["USER"] = removeString(members["USER"], id)
+		}
+	}
+	return users
+}
+
+// legacyUsers serves the security/users API which wraps results in an
+// exchange object
+func (s *Simulator) legacyUsers(users *collection) func(w http.ResponseWriter, r *http.Request, rest string) {
+	return func(w http.ResponseWriter, r *http.Request, rest string) {
+		if rest == "" {
+			var list []map[string]interface{}
+			for _, u := range users.search(r.URL.Query()) {
+				list = append(list, map[string]interface{}{"userUUID": u["id"]})
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"exchange": map[string]interface{}{
+					"users":          list,
+					"nextPageExists": false,
+				},
+				"responseCode":    "200",
+				"responseMessage": "Success",
+			})
+			return
+		}
+		user, found := users.get(rest)
+		if !found {
+			writeError(w, http.StatusNotFound, "user "+rest+" not found")
+			return
+		}
+		if r.Method == http.MethodPut {
+			var body struct {
+				Profile map[string]interface{} `json:"profile"`
+			}
+			_ = decodeBody(r, &body)
+			if given, ok := body.Profile["givenName"]; ok {
+				user["name"] = map[string]interface{}{"given": given, "family": body.Profile["familyName"]}
+			}
+		}
+		name, _ := user["name"].(map[string]interface{})
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"exchange": map[string]interface{}{
+				"loginId":  user["loginId"],
+				"userUUID": user["id"],
+				"profile": map[string]interface{}{
+					"givenName":         name["given"],
+					"familyName":        name["family"],
+					"emailAddress":      user["emailAddress"],
+					"preferredLanguage": user["preferredLanguage"],
+					"contact":           map[string]interface{}{"emailAddress": user["emailAddress"], "mobilePhone": user["phoneNumber"]},
+				},
+			},
+			"responseCode":    "200",
+			"responseMessage": "Success",
+		})
+	}
+}
+
+func generatePrivateKey() string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return ""
+	}
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}))
+}
@@ -0,0 +1,54 @@
+package simulator
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// mdmSeed holds the read-only MDM resources every tenant has access to
+var mdmSeed = map[string][]map[string]interface{}{
+	"Region": {
+		{"name": "us-east", "description": "US East", "category": "HSDP", "hsdpEnabled": true, "regionId": "us-east-1"},
+	},
+	"StorageClass": {
+		{"name": "STANDARD", "description": "Standard storage"},
+	},
+	"SubscriberType": {
+		{"name": "Webhook", "description": "Webhook subscriber"},
+	},
+}
+
+func (s *Simulator) registerMDM() {
+	collections := make(map[string]*collection)
+	get := func(resourceType string) *collection {
+		c, ok := collections[resourceType]
+		if ok {
+			return c
+		}
+		c = newCollection(resourceType)
+		c.onCreate = func(item map[string]interface{}) {
+			item["meta"] = map[string]interface{}{
+				"versionId":   "1",
+				"lastUpdated": time.Now().UTC().Format(time.RFC3339),
+			}
+		}
+		for _, seed := range mdmSeed[resourceType] {
+			c.insert(copyItem(seed))
+		}
+		collections[resourceType] = c
+		return c
+	}
+	s.handle("connect/mdm", func(w http.ResponseWriter, r *http.Request, rest string) {
+		parts := strings.SplitN(rest, "/", 2)
+		if parts[0] == "" {
+			writeError(w, http.StatusNotFound, "missing resource type")
+			return
+		}
+		sub := ""
+		if len(parts) == 2 {
+			sub = parts[1]
+		}
+		get(parts[0]).serve(w, r, sub)
+	})
+}
@@ -0,0 +1,28 @@
+package simulator
+
+import "net/http"
+
+func (s *Simulator) registerNotification() {
+	for _, resourceType := range []string{"Producer", "Topic", "Subscriber", "Subscription"} {
+		c := newCollection(resourceType)
+		c.idField = "_id"
+		c.params = map[string]string{"_id": "_id"}
+		c.bundle = bundleEntries
+		c.updateStatus = http.StatusNoContent
+		if resourceType == "Subscription" {
+			c.collectionActions = map[string]http.HandlerFunc{
+				"_confirm": func(w http.ResponseWriter, _ *http.Request) {
+					writeJSON(w, http.StatusCreated, map[string]interface{}{})
+				},
+			}
+		}
+		s.handle("core/notification/"+resourceType, c.serve)
+	}
+	s.handle("core/notification/Publish", func(w http.ResponseWriter, r *http.Request, _ string) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, r.Method+" not supported")
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"messageId": newID()})
+	})
+}
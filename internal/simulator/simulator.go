@@ -0,0 +1,267 @@
+// Package simulator implements an in-memory stand-in for the HSDP APIs used by
+// the provider. It serves IAM, Cartel, Notification, Connect MDM and CDR
+// endpoints from a single httptest server so resources can be exercised
+// without network access or credentials.
+package simulator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Simulator is a running HSDP API simulator
+type Simulator struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	routes   []route
+	requests []Request
+
+	groupRoles   map[string][]string
+	groupMembers map[string]map[string][]string
+	instances    map[string]*instance
+	launched     int
+	fhir         map[string]json.RawMessage
+}
+
+// Request records a request received by the simulator
+type Request struct {
+	Method string
+	Path   string
+}
+
+type route struct {
+	prefix  string
+	handler func(w http.ResponseWriter, r *http.Request, rest string)
+}
+
+// New starts a simulator serving all supported services
+func New() *Simulator {
+	s := &Simulator{
+		groupRoles:   make(map[string][]string),
+		groupMembers: make(map[string]map[string][]string),
+		instances:    make(map[string]*instance),
+		fhir:         make(map[string]json.RawMessage),
+	}
+	s.registerIAM()
+	s.registerCartel()
+	s.registerNotification()
+	s.registerMDM()
+	s.registerCDR()
+	s.server = httptest.NewServer(s)
+	return s
+}
+
+// URL returns the base URL of the simulator
+func (s *Simulator) URL() string {
+	return s.server.URL
+}
+
+// Close shuts down the simulator
+func (s *Simulator) Close() {
+	s.server.Close()
+}
+
+// Requests returns the requests received so far
+func (s *Simulator) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Handle registers handler for all paths below prefix. Routes registered
+// later take precedence over the built-in ones for equally long prefixes.
+func (s *Simulator) Handle(prefix string, handler http.Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handle(prefix, func(w http.ResponseWriter, r *http.Request, _ string) {
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (s *Simulator) handle(prefix string, handler func(w http.ResponseWriter, r *http.Request, rest string)) {
+	s.routes = append([]route{{prefix: strings.Trim(prefix, "/"), handler: handler}}, s.routes...)
+	sort.SliceStable(s.routes, func(i, j int) bool {
+		return len(s.routes[i].prefix) > len(s.routes[j].prefix)
+	})
+}
+
+// ServeHTTP implements http.Handler
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: "/" + path})
+
+	for _, rt := range s.routes {
+		if path == rt.prefix {
+			rt.handler(w, r, "")
+			return
+		}
+		if strings.HasPrefix(path, rt.prefix+"/") {
+			rt.handler(w, r, strings.TrimPrefix(path, rt.prefix+"/"))
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "no simulated endpoint for "+r.Method+" /"+path)
+}
+
+func newID() string {
+	return uuid.New().String()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	if v == nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError responds with a FHIR style OperationOutcome
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"resourceType": "OperationOutcome",
+		"issue": []map[string]interface{}{
+			{
+				"severity":    "error",
+				"code":        http.StatusText(status),
+				"diagnostics": message,
+			},
+		},
+	})
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil && err.Error() == "EOF" {
+		return nil
+	}
+	return err
+}
+
+// lookup returns the value at the dotted path in item
+func lookup(item map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = item
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// matches reports whether value matches the field value v. References
+// match on their full value or their trailing ID, identifiers on their value.
+func matches(v interface{}, value string) bool {
+	switch field := v.(type) {
+	case string:
+		return field == value || strings.HasSuffix(field, "/"+value)
+	case bool:
+		return (field && value == "true") || (!field && value == "false")
+	case float64:
+		return strconv.FormatFloat(field, 'f', -1, 64) == value
+	case map[string]interface{}:
+		for _, key := range []string{"reference", "value"} {
+			if nested, ok := field[key]; ok {
+				return matches(nested, value)
+			}
+		}
+	case []interface{}:
+		for _, e := range field {
+			if matches(e, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func copyItem(item map[string]interface{}) map[string]interface{} {
+	b, _ := json.Marshal(item)
+	var c map[string]interface{}
+	_ = json.Unmarshal(b, &c)
+	return c
+}
+
+func toStrings(v interface{}) []string {
+	var out []string
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		for _, e := range list {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+func toMaps(v interface{}) []map[string]interface{} {
+	var out []map[string]interface{}
+	list, _ := v.([]interface{})
+	for _, e := range list {
+		if m, ok := e.(map[string]interface{}); ok {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	out := make([]string, 0, len(list))
+	for _, e := range list {
+		if e != s {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func union(a, b []string) []string {
+	out := append([]string{}, a...)
+	for _, e := range b {
+		if !containsString(out, e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func difference(a, b []string) []string {
+	out := []string{}
+	for _, e := range a {
+		if !containsString(b, e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,194 @@
+package simulator_test
+
+import (
+	"testing"
+
+	"github.com/philips-software/go-hsdp-api/connect/mdm"
+	"github.com/philips-software/go-hsdp-api/iam"
+	"github.com/philips-software/go-hsdp-api/notification"
+	"github.com/philips-software/terraform-provider-hsdp/internal/config"
+	"github.com/philips-software/terraform-provider-hsdp/internal/simulator"
+	"github.com/stretchr/testify/assert"
+)
+
+func simulatedConfig(sim *simulator.Simulator) *config.Config {
+	c := &config.Config{}
+	c.Region = "us-east"
+	c.Environment = "client-test"
+	c.OrgAdminUsername = "admin"
+	c.OrgAdminPassword = "password"
+	c.OAuth2ClientID = "client"
+	c.OAuth2Secret = "secret"
+	c.CartelToken = "token"
+	c.CartelSecret = "secret"
+	c.SimulatorURL = sim.URL()
+	return c
+}
+
+func TestIAMGroups(t *testing.T) {
+	sim := simulator.New()
+	defer sim.Close()
+	c := simulatedConfig(sim)
+
+	client, err := c.IAMClient()
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.NotEmpty(t, client.Token())
+
+	role, _, err := client.Roles.CreateRole("ROLE", "role", simulator.RootOrganizationID)
+	if !assert.Nil(t, err) {
+		return
+	}
+	group, _, err := client.Groups.CreateGroup(iam.Group{
+		Name:                 "group",
+		ManagingOrganization: simulator.RootOrganizationID,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	_, _, err = client.Groups.AssignRole(*group, *role)
+	assert.Nil(t, err)
+	roles, _, err := client.Groups.GetRoles(*group)
+	if assert.Nil(t, err) && assert.Len(t, *roles, 1) {
+		assert.Equal(t, role.ID, (*roles)[0].ID)
+	}
+	groups, _, err := client.Groups.GetGroups(&iam.GetGroupOptions{Name: &group.Name})
+	if assert.Nil(t, err) && assert.Len(t, *groups, 1) {
+		assert.Equal(t, group.ID, (*groups)[0].ID)
+	}
+
+	ok, _, err := client.Groups.DeleteGroup(*group)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, resp, err := client.Groups.GetGroupByID(group.ID)
+	assert.NotNil(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, 404, resp.StatusCode)
+	}
+}
+
+func TestIAMOrganizations(t *testing.T) {
+	sim := simulator.New()
+	defer sim.Close()
+	c := simulatedConfig(sim)
+
+	client, err := c.IAMClient()
+	if !assert.Nil(t, err) {
+		return
+	}
+	org, _, err := client.Organizations.CreateOrganization(iam.Organization{
+		Name:   "child",
+		Parent: iam.Attribute{Value: simulator.RootOrganizationID},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	found, _, err := client.Organizations.GetOrganization(iam.FilterNameEq("child"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, org.ID, found.ID)
+	}
+	org.Description = "updated"
+	updated, _, err := client.Organizations.UpdateOrganization(*org)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "updated", updated.Description)
+		assert.Equal(t, `W/"2"`, updated.Meta.Version)
+	}
+	ok, _, err := client.Organizations.DeleteOrganization(*org)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	status, _, err := client.Organizations.DeleteStatus(org.ID)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "SUCCESS", status.Status)
+	}
+}
+
+func TestCartel(t *testing.T) {
+	sim := simulator.New()
+	defer sim.Close()
+	c := simulatedConfig(sim)
+
+	client, err := c.CartelClient()
+	if !assert.Nil(t, err) {
+		return
+	}
+	created, _, err := client.Create("host.dev")
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, created.Success())
+	_, _, err = client.Create("host.dev")
+	assert.NotNil(t, err)
+
+	state, _, err := client.GetDeploymentState("host.dev")
+	assert.Nil(t, err)
+	assert.Equal(t, "succeeded", state)
+
+	_, _, err = client.AddTags([]string{"host.dev"}, map[string]string{"billing": "team"})
+	assert.Nil(t, err)
+	details, _, err := client.GetDetails("host.dev")
+	if assert.Nil(t, err) {
+		assert.Equal(t, created.InstanceID(), details.InstanceID)
+		assert.Equal(t, "team", details.Tags["billing"])
+	}
+
+	destroyed, _, err := client.Destroy("host.dev")
+	if assert.Nil(t, err) {
+		assert.True(t, destroyed.Success())
+	}
+	_, resp, err := client.GetDeploymentState("host.dev")
+	assert.NotNil(t, err)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, 400, resp.StatusCode)
+	}
+}
+
+func TestNotificationAndMDM(t *testing.T) {
+	sim := simulator.New()
+	defer sim.Close()
+	c := simulatedConfig(sim)
+
+	notificationClient, err := c.NotificationClient()
+	if !assert.Nil(t, err) {
+		return
+	}
+	producer, _, err := notificationClient.Producer.CreateProducer(notification.Producer{
+		ManagingOrganizationID:      simulator.RootOrganizationID,
+		ManagingOrganization:        "simulator",
+		ProducerProductName:         "product",
+		ProducerServiceName:         "service",
+		ProducerServiceInstanceName: "instance",
+		ProducerServiceBaseURL:      "https://example.com/",
+		ProducerServicePathURL:      "notify",
+		Description:                 "producer",
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	topic, _, err := notificationClient.Topic.CreateTopic(notification.Topic{
+		Name:       "topic",
+		ProducerID: producer.ID,
+		Scope:      "public",
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	topic.Description = "updated"
+	updated, _, err := notificationClient.Topic.UpdateTopic(*topic)
+	if assert.Nil(t, err) {
+		assert.Equal(t, "updated", updated.Description)
+	}
+
+	mdmClient, err := c.MDMClient()
+	if !assert.Nil(t, err) {
+		return
+	}
+	prop, _, err := mdmClient.Propositions.CreateProposition(mdm.Proposition{
+		Name:              "proposition",
+		OrganizationGuid:  mdm.Identifier{Value: simulator.RootOrganizationID},
+		GlobalReferenceID: "ref",
+	})
+	if assert.Nil(t, err) {
+		assert.Equal(t, "proposition", prop.Name)
+	}
+}
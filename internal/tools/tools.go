@@ -16,9 +16,11 @@ import (
 	"github.com/philips-software/go-hsdp-api/iam"
 )
 
+// TryHTTPCall retries operation on application level errors such as permissions
+// which are still propagating. Throttling is handled by the provider HTTP transport.
 func TryHTTPCall(ctx context.Context, numberOfTries uint64, operation func() (*http.Response, error), retryOnCodes ...int) error {
 	if len(retryOnCodes) == 0 {
-		retryOnCodes = []int{http.StatusForbidden, http.StatusInternalServerError, http.StatusTooManyRequests}
+		retryOnCodes = []int{http.StatusForbidden, http.StatusInternalServerError}
 	}
 	doOp := func() error {
 		resp, err := operation()
@@ -133,6 +135,18 @@ func ExpandStringList(configured []interface{}) []string {
 	return vs
 }
 
+// Takes the result of flatmap.Expand for a map of strings
+// and returns a map[string]string
+func ExpandStringMap(configured map[string]interface{}) map[string]string {
+	vs := make(map[string]string, len(configured))
+	for k, v := range configured {
+		if val, ok := v.(string); ok {
+			vs[k] = val
+		}
+	}
+	return vs
+}
+
 func StringSchema() *schema.Schema {
 	return &schema.Schema{Type: schema.TypeString}
 }
@@ -6,7 +6,6 @@ import (
 
 	"github.com/hashicorp/go-cty/cty"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
-	cfg "github.com/philips-software/go-hsdp-api/config"
 	"github.com/robfig/cron/v3"
 )
 
@@ -32,26 +31,3 @@ func ValidateUpperString(val interface{}, key string) (warns []string, errs []er
 	}
 	return
 }
-
-func ValidateRegion(i interface{}, k string) (warns []string, es []error) {
-	r := i.(string)
-	d, err := cfg.New(cfg.WithRegion(r))
-	if err != nil {
-		es = append(es, err)
-	}
-	if d.Service("cf").URL == "" {
-		warns = append(warns, fmt.Sprintf("no Cloud foundry presence in region '%s'", r))
-	}
-	if d.Env("prod").Service("iam").URL == "" {
-		warns = append(warns, fmt.Sprintf("no production IAM presence in region '%s'", r))
-	}
-	return
-}
-
-func ValidateEnvironment(i interface{}, k string) (warns []string, es []error) {
-	env := i.(string)
-	if !ContainsString([]string{"dev", "client-test", "prod"}, env) {
-		es = append(es, fmt.Errorf("environment '%s' is not a supported one", env))
-	}
-	return
-}